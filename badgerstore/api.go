@@ -3,7 +3,10 @@ package badgerstore
 import (
 	"errors"
 	"fmt"
+	"os"
+	"time"
 
+	"github.com/ceejimus/kusari/fnode"
 	"github.com/ceejimus/kusari/scry"
 	badger "github.com/dgraph-io/badger/v4"
 )
@@ -60,7 +63,6 @@ func (s *BadgerStore) AddChain(chain *scry.Chain, dirID scry.ID) error {
 }
 
 func (s *BadgerStore) AddEvent(event *scry.Event, chainID scry.ID) error {
-	var id BadgerID
 	if event.ID != nil {
 		return errors.New(fmt.Sprintf("Cannot add new event, non-nil ID %v", event))
 	}
@@ -71,22 +73,14 @@ func (s *BadgerStore) AddEvent(event *scry.Event, chainID scry.ID) error {
 	}
 
 	// add new event
-	badgerEvent := BadgerEvent{
-		ChainID:   bdgID,
-		Type:      event.Type,
-		Timestamp: event.Timestamp,
-		Path:      event.Path,
-		ModTime:   event.ModTime,
-		Size:      event.Size,
-	}
-	if event.Hash != nil {
-		badgerEvent.Hash = *event.Hash
-	}
+	badgerEvent := eventToBadgerEvent(*event)
+	badgerEvent.ChainID = bdgID
+	badgerEvent.OldPath = ""
 	if err := addEvent(s, &badgerEvent, bdgID); err != nil {
 		return err
 	}
 	// set ID
-	event.ID = &id
+	event.ID = &badgerEvent.ID
 	// set the old path
 	if badgerEvent.OldPath != "" {
 		event.OldPath = &badgerEvent.OldPath
@@ -94,6 +88,14 @@ func (s *BadgerStore) AddEvent(event *scry.Event, chainID scry.ID) error {
 	return nil
 }
 
+func (s *BadgerStore) SetChainIno(chainID scry.ID, ino uint64) error {
+	bdgID, err := toBadgerID(chainID.Encode())
+	if err != nil {
+		return err
+	}
+	return setChainIno(s, bdgID, ino)
+}
+
 func (s *BadgerStore) GetDirByID(dirID scry.ID) (*scry.Dir, error) {
 	var dir *scry.Dir
 
@@ -140,7 +142,7 @@ func (s *BadgerStore) GetChainByID(chainID scry.ID) (*scry.Chain, error) {
 	}
 	if err := s.db.View(func(txn *badger.Txn) error {
 		bdgChain, err := getChainByID(txn, bdgID)
-		if err != nil {
+		if bdgChain == nil || err != nil {
 			return err
 		}
 		converted := badgerChainToChain(*bdgChain)
@@ -160,11 +162,11 @@ func (s *BadgerStore) GetChainByPath(dirID scry.ID, path string) (*scry.Chain, e
 	}
 	if err := s.db.View(func(txn *badger.Txn) error {
 		chainID, err := getChainIDByPath(txn, bdgID, path)
-		if err != nil {
+		if chainID == nil || err != nil {
 			return nil
 		}
 		bdgChain, err := getChainByID(txn, chainID)
-		if err != nil {
+		if bdgChain == nil || err != nil {
 			return err
 		}
 		converted := badgerChainToChain(*bdgChain)
@@ -192,16 +194,175 @@ func (s *BadgerStore) GetChainByIno(ino uint64) (*scry.Chain, error) {
 	return chain, nil
 }
 
+func (s *BadgerStore) GetRemovedChainByPath(dirID scry.ID, path string) (*scry.Chain, error) {
+	var chain *scry.Chain
+	bdgID, err := toBadgerID(dirID.Encode())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.View(func(txn *badger.Txn) error {
+		bdgChain, err := getRemovedChainByPath(txn, bdgID, path)
+		if bdgChain == nil || err != nil {
+			return err
+		}
+		converted := badgerChainToChain(*bdgChain)
+		chain = &converted
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return chain, nil
+}
+
 func (s *BadgerStore) GetEventByID(eventID scry.ID) (*scry.Event, error) {
-	panic("GetEventByID not implemented")
+	return s.getEvent(eventID, getEventByID)
 }
 
 func (s *BadgerStore) GetPrevEvent(eventID scry.ID) (*scry.Event, error) {
-	panic("GetPrevEvent not implemented")
+	return s.getEvent(eventID, getEventPrev)
 }
 
 func (s *BadgerStore) GetNextEvent(eventID scry.ID) (*scry.Event, error) {
-	panic("GetNextEvent not implemented")
+	return s.getEvent(eventID, getEventNext)
+}
+
+func (s *BadgerStore) GetChainHead(chainID scry.ID) (*scry.Event, error) {
+	return s.getEvent(chainID, getChainHead)
+}
+
+func (s *BadgerStore) GetChainTail(chainID scry.ID) (*scry.Event, error) {
+	return s.getEvent(chainID, getChainTail)
+}
+
+func (s *BadgerStore) AddConflict(conflict *scry.Conflict, dirID scry.ID) error {
+	if conflict.ID != nil {
+		return errors.New(fmt.Sprintf("Cannot add new conflict, non-nil ID %v", conflict))
+	}
+	bdgDirID, err := toBadgerID(dirID.Encode())
+	if err != nil {
+		return err
+	}
+	bdgChainID, err := toBadgerID(conflict.ChainID.Encode())
+	if err != nil {
+		return err
+	}
+	badgerConflict := BadgerConflict{
+		ChainID:  bdgChainID,
+		Path:     conflict.Path,
+		Local:    eventToBadgerEvent(conflict.Local),
+		Remote:   eventToBadgerEvent(conflict.Remote),
+		Detected: conflict.Detected,
+	}
+	if err := addConflict(s, &badgerConflict, bdgDirID); err != nil {
+		return err
+	}
+
+	conflict.ID = &badgerConflict.ID
+	return nil
+}
+
+func (s *BadgerStore) GetConflictByID(conflictID scry.ID) (*scry.Conflict, error) {
+	var conflict *scry.Conflict
+	bdgID, err := toBadgerID(conflictID.Encode())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.View(func(txn *badger.Txn) error {
+		bdgConflict, err := getConflictByID(txn, bdgID)
+		if bdgConflict == nil || err != nil {
+			return err
+		}
+		converted := badgerConflictToConflict(*bdgConflict)
+		conflict = &converted
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return conflict, nil
+}
+
+func (s *BadgerStore) GetConflictsInDir(dirID scry.ID) ([]scry.Conflict, error) {
+	var bdgConflicts []BadgerConflict
+	if err := s.db.View(func(txn *badger.Txn) error {
+		bdgID, err := toBadgerID(dirID.Encode())
+		if err != nil {
+			return err
+		}
+		prefix := append(makeKey([]byte(LKP_CONFLICT_DIR), bdgID.Encode()), []byte(":")...)
+		ids, err := iterVals(txn, prefix)
+		if err != nil {
+			return err
+		}
+		bdgConflicts = make([]BadgerConflict, 0, len(ids))
+		for _, id := range ids {
+			conflictID, err := toBadgerID(id)
+			if err != nil {
+				return err
+			}
+			bdgConflict, err := getConflictByID(txn, conflictID)
+			if err != nil {
+				return err
+			}
+			if bdgConflict != nil {
+				bdgConflicts = append(bdgConflicts, *bdgConflict)
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	conflicts := make([]scry.Conflict, len(bdgConflicts))
+	for i, bdgConflict := range bdgConflicts {
+		conflicts[i] = badgerConflictToConflict(bdgConflict)
+	}
+
+	return conflicts, nil
+}
+
+func (s *BadgerStore) RemoveConflict(conflictID scry.ID) error {
+	bdgID, err := toBadgerID(conflictID.Encode())
+	if err != nil {
+		return err
+	}
+	return removeConflict(s, bdgID)
+}
+
+func (s *BadgerStore) GetStatEntry(ino uint64) (*fnode.StatEntry, error) {
+	var entry *fnode.StatEntry
+	if err := s.db.View(func(txn *badger.Txn) error {
+		bdgEntry, err := getStatEntry(txn, ino)
+		if bdgEntry == nil || err != nil {
+			return err
+		}
+		converted := fnode.StatEntry(*bdgEntry)
+		entry = &converted
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (s *BadgerStore) PutStatEntry(entry *fnode.StatEntry) error {
+	bdgEntry := BadgerStatEntry(*entry)
+	return putStatEntry(s, &bdgEntry)
+}
+
+func (s *BadgerStore) RemoveDir(dirID scry.ID) error {
+	bdgID, err := toBadgerID(dirID.Encode())
+	if err != nil {
+		return err
+	}
+	return removeDir(s, bdgID)
+}
+
+func (s *BadgerStore) PruneChain(chainID scry.ID, before time.Time) (int, error) {
+	bdgID, err := toBadgerID(chainID.Encode())
+	if err != nil {
+		return 0, err
+	}
+	return pruneChain(s, bdgID, before)
 }
 
 func (s *BadgerStore) GetDirs() ([]scry.Dir, error) {
@@ -272,31 +433,34 @@ func (s *BadgerStore) GetEventsInChain(chainID scry.ID) ([]scry.Event, error) {
 	}
 
 	if err := s.db.View(func(txn *badger.Txn) error {
-		bdgEvent, err := getChainHead(txn, bdgID)
-		if err != nil {
+		bdgEvents, err = getEventsInChain(txn, bdgID)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	return badgerEventsToEvents(bdgEvents), nil
+}
+
+// getEvent gets a single event w/ the given getter
+func (s *BadgerStore) getEvent(id scry.ID, get func(*badger.Txn, BadgerID) (*BadgerEvent, error)) (*scry.Event, error) {
+	var event *scry.Event
+	bdgID, err := toBadgerID(id.Encode())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.View(func(txn *badger.Txn) error {
+		bdgEvent, err := get(txn, bdgID)
+		if bdgEvent == nil || err != nil {
 			return err
 		}
-		for {
-			if bdgEvent == nil {
-				break
-			}
-			bdgEvents = append(bdgEvents, *bdgEvent)
-			bdgEvent, err = getEventNext(txn, bdgEvent.ID)
-			if err != nil {
-				return err
-			}
-		}
+		converted := badgerEventToEvent(*bdgEvent)
+		event = &converted
 		return nil
 	}); err != nil {
 		return nil, err
 	}
-
-	events := make([]scry.Event, len(bdgEvents))
-	for i, bdgEvent := range bdgEvents {
-		events[i] = badgerEventToEvent(bdgEvent)
-	}
-
-	return events, nil
+	return event, nil
 }
 
 func (s *BadgerStore) Close() error {
@@ -331,9 +495,13 @@ func badgerEventToEvent(bdgEvent BadgerEvent) scry.Event {
 	if bdgEvent.OldPath != "" {
 		oldPath = &bdgEvent.OldPath
 	}
-	id := *&bdgEvent.ID
+	var id scry.ID
+	if bdgEvent.ID != nil {
+		bdgID := bdgEvent.ID
+		id = &bdgID
+	}
 	return scry.Event{
-		ID:        &id,
+		ID:        id,
 		Timestamp: bdgEvent.Timestamp,
 		Path:      bdgEvent.Path,
 		OldPath:   oldPath,
@@ -341,5 +509,57 @@ func badgerEventToEvent(bdgEvent BadgerEvent) scry.Event {
 		Size:      bdgEvent.Size,
 		Hash:      hash,
 		ModTime:   bdgEvent.ModTime,
+		Mode:      os.FileMode(bdgEvent.Mode),
+		Uid:       bdgEvent.Uid,
+		Gid:       bdgEvent.Gid,
+		Target:    bdgEvent.Target,
+		Chunks:    bdgEvent.Chunks,
+		Origin:    bdgEvent.Origin,
+		Version:   scry.VersionVector(bdgEvent.Version),
+	}
+}
+
+func badgerEventsToEvents(bdgEvents []BadgerEvent) []scry.Event {
+	events := make([]scry.Event, len(bdgEvents))
+	for i, bdgEvent := range bdgEvents {
+		events[i] = badgerEventToEvent(bdgEvent)
+	}
+	return events
+}
+
+// eventToBadgerEvent converts an event w/o touching IDs
+func eventToBadgerEvent(event scry.Event) BadgerEvent {
+	bdgEvent := BadgerEvent{
+		Type:      event.Type,
+		Timestamp: event.Timestamp,
+		Path:      event.Path,
+		ModTime:   event.ModTime,
+		Size:      event.Size,
+		Mode:      uint32(event.Mode),
+		Uid:       event.Uid,
+		Gid:       event.Gid,
+		Target:    event.Target,
+		Chunks:    event.Chunks,
+		Origin:    event.Origin,
+		Version:   event.Version,
+	}
+	if event.Hash != nil {
+		bdgEvent.Hash = *event.Hash
+	}
+	if event.OldPath != nil {
+		bdgEvent.OldPath = *event.OldPath
+	}
+	return bdgEvent
+}
+
+func badgerConflictToConflict(bdgConflict BadgerConflict) scry.Conflict {
+	chainID := bdgConflict.ChainID
+	return scry.Conflict{
+		ID:       &bdgConflict.ID,
+		ChainID:  &chainID,
+		Path:     bdgConflict.Path,
+		Local:    badgerEventToEvent(bdgConflict.Local),
+		Remote:   badgerEventToEvent(bdgConflict.Remote),
+		Detected: bdgConflict.Detected,
 	}
 }
@@ -1,10 +1,12 @@
 package badgerstore
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"time"
 
+	"github.com/ceejimus/kusari/fnode"
 	"github.com/ceejimus/kusari/scry"
 	badger "github.com/dgraph-io/badger/v4"
 )
@@ -22,8 +24,17 @@ const LKP_CHAIN_TAIL = "lkp:event:tail"
 const LKP_EVENT_PREV = "lkp:event:prev"
 const LKP_EVENT_NEXT = "lkp:event:next"
 const LKP_CHAIN_PATH_PREFIX = "lkp:chain:path"
+const LKP_CHAIN_REMOVED = "lkp:chain:removed"
 
-var SEQ_KEYS = []string{PFX_DIR, PFX_CHAIN, PFX_EVENT, LKP_CHAIN_DIR}
+const PFX_CONFLICT = "conflict"
+const LKP_CONFLICT_DIR = "lkp:conflict:dir"
+
+const PFX_STAT = "stat"
+
+var SEQ_KEYS = []string{PFX_DIR, PFX_CHAIN, PFX_EVENT, LKP_CHAIN_DIR, PFX_CONFLICT}
+
+// how many times a txn is run again when what it read changed before it committed
+const MAX_TXN_RETRIES = 5
 
 type BadgerID []byte
 
@@ -39,15 +50,41 @@ type BadgerChain struct {
 }
 
 type BadgerEvent struct {
-	ID        BadgerID        // eventID
-	ChainID   BadgerID        // the chain ID to which this event belongs
-	Type      scry.EventType // "create", "modify", "delete", "rename", etc.
-	Timestamp time.Time       // time event processed
-	Path      string          // relative path of file
-	OldPath   string          // the old path (for create events after rename)
-	ModTime   time.Time       // modification time
-	Hash      string          // file hash (if file)
-	Size      uint64          // file size
+	ID        BadgerID          // eventID
+	ChainID   BadgerID          // the chain ID to which this event belongs
+	Type      scry.EventType    // "create", "modify", "delete", "rename", etc.
+	Timestamp time.Time         // time event processed
+	Path      string            // relative path of file
+	OldPath   string            // the old path (for create events after rename)
+	ModTime   time.Time         // modification time
+	Hash      string            // file hash (if file)
+	Size      uint64            // file size
+	Mode      uint32            // permission bits
+	Uid       uint32            // owner
+	Gid       uint32            // group
+	Target    string            // symlink target
+	Chunks    []fnode.Chunk     // file chunks (if chunked)
+	Origin    string            // ID of the node the event happened on
+	Version   map[string]uint64 // the version of the chain as of this event
+}
+
+type BadgerConflict struct {
+	ID       BadgerID    // conflictID
+	DirID    BadgerID    // the dir ID to which this conflict belongs
+	ChainID  BadgerID    // the local chain
+	Path     string      // local path of the node
+	Local    BadgerEvent // the most recent local event
+	Remote   BadgerEvent // the most recent remote event
+	Detected time.Time   // when the conflict was detected
+}
+
+type BadgerStatEntry struct {
+	Ino     uint64        // the ino of the file
+	Size    uint64        // file size when hashed
+	ModTime time.Time     // modification time when hashed
+	Ctime   time.Time     // inode change time when hashed
+	Hash    string        // file hash
+	Chunks  []fnode.Chunk // file chunks (if chunked)
 }
 
 type SeqMap map[string]*badger.Sequence
@@ -137,8 +174,45 @@ func addChain(s *BadgerStore, bdgChain *BadgerChain, dirID BadgerID) error {
 	})
 }
 
-func addEvent(s *BadgerStore, bdgEvent *BadgerEvent, chainID BadgerID) error {
+func setChainIno(s *BadgerStore, chainID BadgerID, ino uint64) error {
 	return s.db.Update(func(txn *badger.Txn) error {
+		chain, err := getChainByID(txn, chainID)
+		if chain == nil || err != nil {
+			return errors.New(fmt.Sprintf("Cannot set ino, nonexistent chain w/ id: %v", chainID))
+		}
+		// only drop the old ino lkp if it's still ours
+		oldKey := makeKey([]byte(LKP_CHAIN_INO), uint64ToBytes(chain.Ino))
+		oldID, err := getID(txn, oldKey)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(oldID, chainID) {
+			if err = txn.Delete(oldKey); err != nil {
+				return err
+			}
+		}
+		chain.Ino = ino
+		if err = addObject(txn, makeKey([]byte(PFX_CHAIN), chainID.Encode()), *chain); err != nil {
+			return err
+		}
+		return txn.Set(makeKey([]byte(LKP_CHAIN_INO), uint64ToBytes(ino)), chainID.Encode())
+	})
+}
+
+// updateRetrying runs fn in an update txn, running it again if the txn conflicts w/ another one
+func (s *BadgerStore) updateRetrying(fn func(txn *badger.Txn) error) error {
+	var err error
+	for try := 0; try <= MAX_TXN_RETRIES; try++ {
+		if err = s.db.Update(fn); err != badger.ErrConflict {
+			return err
+		}
+	}
+	return err
+}
+
+func addEvent(s *BadgerStore, bdgEvent *BadgerEvent, chainID BadgerID) error {
+	// the compactor may be pruning the chain
+	return s.updateRetrying(func(txn *badger.Txn) error {
 		// check chain exists
 		chain, err := getChainByID(txn, chainID)
 		if chain == nil || err != nil {
@@ -149,19 +223,16 @@ func addEvent(s *BadgerStore, bdgEvent *BadgerEvent, chainID BadgerID) error {
 		if toAdd.ID, err = s.nextIDFor(PFX_EVENT); err != nil {
 			return err
 		}
-		if err = addObject(txn, makeKey([]byte(PFX_EVENT), toAdd.ID.Encode()), toAdd); err != nil {
-			return err
-		}
 		// get current head and tail for chain
 		head, err := getChainHead(txn, chainID)
 		if err != nil {
-			return nil
+			return err
 		}
 		tail, err := getChainTail(txn, chainID)
 		if err != nil {
-			return nil
+			return err
 		}
-		// set head if first event, set prevEvent.Next otherwise
+		// set head if first event, link to the previous event otherwise
 		if head == nil { // this is the first event in chain
 			if err = setChainHead(txn, chainID, toAdd.ID); err != nil {
 				return err
@@ -170,11 +241,18 @@ func addEvent(s *BadgerStore, bdgEvent *BadgerEvent, chainID BadgerID) error {
 			if err = setEventNext(txn, tail.ID, toAdd.ID); err != nil {
 				return err
 			}
+			if err = setEventPrev(txn, toAdd.ID, tail.ID); err != nil {
+				return err
+			}
 		}
 		// update the path lookup depending on event type
 		if err = updateChainLkps(txn, chainID, &toAdd, tail); err != nil {
 			return err
 		}
+		// store the event once the lkps have set its old path
+		if err = addObject(txn, makeKey([]byte(PFX_EVENT), toAdd.ID.Encode()), toAdd); err != nil {
+			return err
+		}
 		// set the tail
 		if err = setChainTail(txn, chainID, toAdd.ID); err != nil {
 			return err
@@ -186,6 +264,235 @@ func addEvent(s *BadgerStore, bdgEvent *BadgerEvent, chainID BadgerID) error {
 	})
 }
 
+func addConflict(s *BadgerStore, bdgConflict *BadgerConflict, dirID BadgerID) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		// check dir exists
+		dir, err := getDirByID(txn, dirID)
+		if dir == nil || err != nil {
+			return errors.New(fmt.Sprintf("Cannot add new conflict, nonexistent dir w/ id: %v", dirID))
+		}
+		// add new conflict
+		toAdd := *bdgConflict
+		toAdd.DirID = dirID
+		if toAdd.ID, err = s.nextIDFor(PFX_CONFLICT); err != nil {
+			return err
+		}
+		if err = addObject(txn, makeKey([]byte(PFX_CONFLICT), toAdd.ID.Encode()), toAdd); err != nil {
+			return err
+		}
+		// set lookup for conflict in dir
+		if err = txn.Set(makeKey([]byte(LKP_CONFLICT_DIR), dirID.Encode(), toAdd.ID.Encode()), toAdd.ID.Encode()); err != nil {
+			return err
+		}
+		// set new ID
+		bdgConflict.ID = toAdd.ID
+		bdgConflict.DirID = dirID
+		return nil
+	})
+}
+
+func removeConflict(s *BadgerStore, conflictID BadgerID) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		conflict, err := getConflictByID(txn, conflictID)
+		if conflict == nil || err != nil {
+			return errors.New(fmt.Sprintf("Cannot remove conflict, nonexistent conflict w/ id: %v", conflictID))
+		}
+		if err = txn.Delete(makeKey([]byte(LKP_CONFLICT_DIR), conflict.DirID.Encode(), conflictID.Encode())); err != nil {
+			return err
+		}
+		return txn.Delete(makeKey([]byte(PFX_CONFLICT), conflictID.Encode()))
+	})
+}
+
+// removeDir removes a dir w/ its chains, conflicts and lkps
+// chains are removed one txn at a time so big dirs don't hit badger's txn limits
+// the dir goes last, if we're interrupted it's still there to be removed again
+func removeDir(s *BadgerStore, dirID BadgerID) error {
+	var chainLkpKeys [][]byte
+	if err := s.db.View(func(txn *badger.Txn) error {
+		dir, err := getDirByID(txn, dirID)
+		if dir == nil || err != nil {
+			return errors.New(fmt.Sprintf("Cannot remove dir, nonexistent dir w/ id: %v", dirID))
+		}
+		chainLkpKeys, err = iterKeys(txn, append(makeKey([]byte(LKP_CHAIN_DIR), dirID.Encode()), []byte(":")...))
+		return err
+	}); err != nil {
+		return err
+	}
+	for _, key := range chainLkpKeys {
+		if err := s.db.Update(func(txn *badger.Txn) error {
+			return removeChain(txn, key)
+		}); err != nil {
+			return err
+		}
+	}
+	// the path lkps of every chain in the dir are under its prefix
+	for _, prefix := range []string{LKP_CHAIN_PATH_PREFIX, LKP_CHAIN_REMOVED} {
+		if err := deletePrefix(s, append(makeKey([]byte(prefix), dirID.Encode()), []byte(":")...)); err != nil {
+			return err
+		}
+	}
+	return s.db.Update(func(txn *badger.Txn) error {
+		dir, err := getDirByID(txn, dirID)
+		if dir == nil || err != nil {
+			return err
+		}
+		conflictLkpKeys, err := iterKeys(txn, append(makeKey([]byte(LKP_CONFLICT_DIR), dirID.Encode()), []byte(":")...))
+		if err != nil {
+			return err
+		}
+		for _, key := range conflictLkpKeys {
+			conflictID, err := getID(txn, key)
+			if err != nil {
+				return err
+			}
+			if conflictID != nil {
+				if err = txn.Delete(makeKey([]byte(PFX_CONFLICT), conflictID.Encode())); err != nil {
+					return err
+				}
+			}
+			if err = txn.Delete(key); err != nil {
+				return err
+			}
+		}
+		if err = txn.Delete(makeKey([]byte(LKP_DIR_PATH), []byte(dir.Path))); err != nil {
+			return err
+		}
+		return txn.Delete(makeKey([]byte(PFX_DIR), dirID.Encode()))
+	})
+}
+
+// removeChain removes the chain in a dir's chain lkp w/ its events and lkps (but not its path lkps)
+func removeChain(txn *badger.Txn, chainLkpKey []byte) error {
+	chainID, err := getID(txn, chainLkpKey)
+	if err != nil {
+		return err
+	}
+	if chainID != nil {
+		chain, err := getChainByID(txn, chainID)
+		if err != nil {
+			return err
+		}
+		events, err := getEventsInChain(txn, chainID)
+		if err != nil {
+			return err
+		}
+		if _, err = removeEvents(txn, chainID, events, make([]bool, len(events))); err != nil {
+			return err
+		}
+		if chain != nil {
+			// the ino may belong to a newer chain (in another dir)
+			inoKey := makeKey([]byte(LKP_CHAIN_INO), uint64ToBytes(chain.Ino))
+			inoChainID, err := getID(txn, inoKey)
+			if err != nil {
+				return err
+			}
+			if bytes.Equal(inoChainID, chainID) {
+				if err = txn.Delete(inoKey); err != nil {
+					return err
+				}
+			}
+		}
+		if err = txn.Delete(makeKey([]byte(PFX_CHAIN), chainID.Encode())); err != nil {
+			return err
+		}
+	}
+	return txn.Delete(chainLkpKey)
+}
+
+func pruneChain(s *BadgerStore, chainID BadgerID, before time.Time) (int, error) {
+	dropped := 0
+	err := s.db.Update(func(txn *badger.Txn) error {
+		chain, err := getChainByID(txn, chainID)
+		if chain == nil || err != nil {
+			return errors.New(fmt.Sprintf("Cannot prune chain, nonexistent chain w/ id: %v", chainID))
+		}
+		events, err := getEventsInChain(txn, chainID)
+		if err != nil {
+			return err
+		}
+		dropped, err = removeEvents(txn, chainID, events, scry.KeepAfter(badgerEventsToEvents(events), before))
+		return err
+	})
+	return dropped, err
+}
+
+// removeEvents drops the events of a chain (in order) that aren't kept and relinks the rest
+func removeEvents(txn *badger.Txn, chainID BadgerID, events []BadgerEvent, keep []bool) (int, error) {
+	dropped := 0
+	var prev *BadgerEvent // the last event kept
+	for i := range events {
+		event := &events[i]
+		if !keep[i] {
+			for _, key := range [][]byte{
+				makeKey([]byte(PFX_EVENT), event.ID.Encode()),
+				makeKey([]byte(LKP_EVENT_PREV), event.ID.Encode()),
+				makeKey([]byte(LKP_EVENT_NEXT), event.ID.Encode()),
+			} {
+				if err := txn.Delete(key); err != nil {
+					return 0, err
+				}
+			}
+			dropped++
+			continue
+		}
+		// only relink across the gaps
+		if i > 0 && !keep[i-1] {
+			if prev == nil {
+				if err := setChainHead(txn, chainID, event.ID); err != nil {
+					return 0, err
+				}
+				if err := txn.Delete(makeKey([]byte(LKP_EVENT_PREV), event.ID.Encode())); err != nil {
+					return 0, err
+				}
+			} else {
+				if err := setEventNext(txn, prev.ID, event.ID); err != nil {
+					return 0, err
+				}
+				if err := setEventPrev(txn, event.ID, prev.ID); err != nil {
+					return 0, err
+				}
+			}
+		}
+		prev = event
+	}
+	if dropped == 0 || keep[len(keep)-1] {
+		return dropped, nil
+	}
+	// the tail was dropped
+	if prev == nil {
+		for _, key := range [][]byte{
+			makeKey([]byte(LKP_CHAIN_HEAD), chainID.Encode()),
+			makeKey([]byte(LKP_CHAIN_TAIL), chainID.Encode()),
+		} {
+			if err := txn.Delete(key); err != nil {
+				return 0, err
+			}
+		}
+		return dropped, nil
+	}
+	if err := txn.Delete(makeKey([]byte(LKP_EVENT_NEXT), prev.ID.Encode())); err != nil {
+		return 0, err
+	}
+	return dropped, setChainTail(txn, chainID, prev.ID)
+}
+
+func getEventsInChain(txn *badger.Txn, chainID BadgerID) ([]BadgerEvent, error) {
+	events := make([]BadgerEvent, 0)
+	event, err := getChainHead(txn, chainID)
+	for ; event != nil && err == nil; event, err = getEventNext(txn, event.ID) {
+		events = append(events, *event)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func getConflictByID(txn *badger.Txn, conflictID BadgerID) (*BadgerConflict, error) {
+	return getObject[BadgerConflict](txn, makeKey([]byte(PFX_CONFLICT), conflictID.Encode()))
+}
+
 func getChainHead(txn *badger.Txn, chainID BadgerID) (*BadgerEvent, error) {
 	headID, err := getID(txn, makeKey([]byte(LKP_CHAIN_HEAD), chainID.Encode()))
 	if err != nil {
@@ -243,6 +550,41 @@ func setEventNext(txn *badger.Txn, eventID BadgerID, nextID BadgerID) error {
 	return txn.Set(makeKey([]byte(LKP_EVENT_NEXT), eventID.Encode()), nextID.Encode())
 }
 
+func getEventPrev(txn *badger.Txn, eventID BadgerID) (*BadgerEvent, error) {
+	prevID, err := getID(txn, makeKey([]byte(LKP_EVENT_PREV), eventID.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	if prevID != nil {
+		return getEventByID(txn, prevID)
+	}
+	// events added before we kept prev lkps only have next lkps
+	// so walk the chain from the head unless this is the head
+	event, err := getEventByID(txn, eventID)
+	if event == nil || err != nil {
+		return nil, err
+	}
+	prev, err := getChainHead(txn, event.ChainID)
+	if prev == nil || err != nil {
+		return nil, err
+	}
+	for !bytes.Equal(prev.ID, eventID) {
+		next, err := getEventNext(txn, prev.ID)
+		if next == nil || err != nil {
+			return nil, err
+		}
+		if bytes.Equal(next.ID, eventID) {
+			return prev, nil
+		}
+		prev = next
+	}
+	return nil, nil // it's the head
+}
+
+func setEventPrev(txn *badger.Txn, eventID BadgerID, prevID BadgerID) error {
+	return txn.Set(makeKey([]byte(LKP_EVENT_PREV), eventID.Encode()), prevID.Encode())
+}
+
 func updateChainLkps(txn *badger.Txn, chainID BadgerID, event *BadgerEvent, tail *BadgerEvent) error {
 	chain, err := getObject[BadgerChain](txn, makeKey([]byte(PFX_CHAIN), chainID.Encode()))
 	if err != nil {
@@ -261,6 +603,9 @@ func updateChainLkps(txn *badger.Txn, chainID BadgerID, event *BadgerEvent, tail
 		if err := txn.Delete(makeKey([]byte(LKP_CHAIN_INO), uint64ToBytes(chain.Ino))); err != nil {
 			return err
 		}
+		if err := txn.Set(makeRemovedChainKey(chain.DirID, event.Path), chainID.Encode()); err != nil {
+			return err
+		}
 	} else {
 		existingChainID, err := getChainIDByPath(txn, chain.DirID, event.Path)
 		if err != nil {
@@ -300,14 +645,6 @@ func getEventByID(txn *badger.Txn, eventID BadgerID) (*BadgerEvent, error) {
 	return event, nil
 }
 
-func getPrevEvent(txn *badger.Txn, eventID BadgerID) (*BadgerEvent, error) {
-	event, err := getObject[BadgerEvent](txn, makeKey([]byte(PFX_EVENT), eventID.Encode()))
-	if err != nil {
-		return nil, err
-	}
-	return event, nil
-}
-
 func getDirByPath(txn *badger.Txn, path string) (*BadgerDir, error) {
 	dirID, err := getID(txn, makeKey([]byte(LKP_DIR_PATH), []byte(path)))
 	if dirID == nil || err != nil {
@@ -316,6 +653,18 @@ func getDirByPath(txn *badger.Txn, path string) (*BadgerDir, error) {
 	return getDirByID(txn, dirID)
 }
 
+func getRemovedChainByPath(txn *badger.Txn, dirID BadgerID, path string) (*BadgerChain, error) {
+	chainID, err := getID(txn, makeRemovedChainKey(dirID, path))
+	if chainID == nil || err != nil {
+		return nil, err
+	}
+	return getChainByID(txn, chainID)
+}
+
+func makeRemovedChainKey(dirID BadgerID, path string) []byte {
+	return makeKey([]byte(LKP_CHAIN_REMOVED), dirID.Encode(), []byte(path))
+}
+
 func getChainByIno(txn *badger.Txn, ino uint64) (*BadgerChain, error) {
 	chainID, err := getID(txn, makeKey([]byte(LKP_CHAIN_INO), uint64ToBytes(ino)))
 	if chainID == nil || err != nil {
@@ -323,3 +672,13 @@ func getChainByIno(txn *badger.Txn, ino uint64) (*BadgerChain, error) {
 	}
 	return getChainByID(txn, chainID)
 }
+
+func getStatEntry(txn *badger.Txn, ino uint64) (*BadgerStatEntry, error) {
+	return getObject[BadgerStatEntry](txn, makeKey([]byte(PFX_STAT), uint64ToBytes(ino)))
+}
+
+func putStatEntry(s *BadgerStore, bdgEntry *BadgerStatEntry) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return addObject(txn, makeKey([]byte(PFX_STAT), uint64ToBytes(bdgEntry.Ino)), *bdgEntry)
+	})
+}
@@ -0,0 +1,244 @@
+package badgerstore
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/ceejimus/kusari/logger"
+	"github.com/ceejimus/kusari/scry"
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// the share of a value log file that has to be stale before it's rewritten
+const VLOG_GC_RATIO = 0.5
+
+// blobs newer than this aren't collected, the events that refer to them may not be stored yet
+const BLOB_GC_GRACE = time.Minute
+
+// Compactor prunes the chains in a store to a retention policy on an interval
+type Compactor struct {
+	store     *BadgerStore
+	retention scry.Retention
+	interval  time.Duration
+	blobs     BlobCollector
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// BlobCollector removes the blobs no event refers to (see blobstore.BlobStore)
+type BlobCollector interface {
+	GCBefore(store scry.EventStore, before time.Time) (int, error)
+}
+
+// a lkp is stale if what its key or value refers to is gone
+type lkpCheck struct {
+	prefix string
+	keyPfx string // the object the ID in the key is of ("" if the key has none)
+	valPfx string // the object the ID in the value is of
+}
+
+var LKP_CHECKS = []lkpCheck{
+	{LKP_DIR_PATH, "", PFX_DIR},
+	{LKP_CHAIN_INO, "", PFX_CHAIN},
+	{LKP_CHAIN_DIR, PFX_DIR, PFX_CHAIN},
+	{LKP_CHAIN_PATH_PREFIX, PFX_DIR, PFX_CHAIN},
+	{LKP_CHAIN_REMOVED, PFX_DIR, PFX_CHAIN},
+	{LKP_CHAIN_HEAD, PFX_CHAIN, PFX_EVENT},
+	{LKP_CHAIN_TAIL, PFX_CHAIN, PFX_EVENT},
+	{LKP_EVENT_PREV, PFX_EVENT, PFX_EVENT},
+	{LKP_EVENT_NEXT, PFX_EVENT, PFX_EVENT},
+	{LKP_CONFLICT_DIR, PFX_DIR, PFX_CONFLICT},
+}
+
+// StartCompactor compacts the store now and then every interval until it's stopped
+// blobs only dropped events referred to are collected after each compaction (unless blobs is nil)
+func (s *BadgerStore) StartCompactor(retention scry.Retention, interval time.Duration, blobs BlobCollector) *Compactor {
+	c := &Compactor{
+		store:     s,
+		retention: retention,
+		interval:  interval,
+		blobs:     blobs,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+// Stop stops compacting and waits for a compaction in progress to finish
+func (c *Compactor) Stop() {
+	close(c.stop)
+	<-c.done
+}
+
+func (c *Compactor) run() {
+	defer close(c.done)
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		dropped, err := c.store.Compact(c.retention, time.Now())
+		if err != nil {
+			logger.Error(fmt.Sprintf("Failed to compact store\n%s", err))
+		} else if dropped > 0 {
+			logger.Info(fmt.Sprintf("Compacted store, dropped %d events", dropped))
+		}
+		if dropped > 0 && c.blobs != nil {
+			if removed, err := c.blobs.GCBefore(c.store, time.Now().Add(-BLOB_GC_GRACE)); err != nil {
+				logger.Error(fmt.Sprintf("Failed to collect blobs\n%s", err))
+			} else if removed > 0 {
+				logger.Info(fmt.Sprintf("Removed %d unreferenced blobs", removed))
+			}
+		}
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Compact drops the events the retention policy doesn't keep (as of now) and any stale lkps
+// then rewrites the value log so the space they took is reclaimed
+// returns the number of events dropped
+func (s *BadgerStore) Compact(retention scry.Retention, now time.Time) (int, error) {
+	var chainIDs []BadgerID
+	if err := s.db.View(func(txn *badger.Txn) error {
+		chains, err := iterObjects[BadgerChain](txn, []byte(PFX_CHAIN+":"))
+		for _, chain := range chains {
+			chainIDs = append(chainIDs, chain.ID)
+		}
+		return err
+	}); err != nil {
+		return 0, err
+	}
+
+	dropped := 0
+	if !retention.IsZero() {
+		// one txn per chain so we don't hit badger's txn limits
+		for _, chainID := range chainIDs {
+			n, err := s.pruneToRetention(chainID, retention, now)
+			if err != nil {
+				return dropped, err
+			}
+			dropped += n
+		}
+	}
+
+	if err := s.sweepLkps(); err != nil {
+		return dropped, err
+	}
+	// rewrite value log files until there's none worth rewriting
+	for s.db.RunValueLogGC(VLOG_GC_RATIO) == nil {
+	}
+	return dropped, nil
+}
+
+// pruneToRetention drops the events in a chain the retention policy doesn't keep
+// a chain that's changed while it's pruned (e.g. the scryer added an event) is pruned again
+func (s *BadgerStore) pruneToRetention(chainID BadgerID, retention scry.Retention, now time.Time) (int, error) {
+	dropped := 0
+	err := s.updateRetrying(func(txn *badger.Txn) error {
+		events, err := getEventsInChain(txn, chainID)
+		if err != nil {
+			return err
+		}
+		dropped, err = removeEvents(txn, chainID, events, retention.Keep(badgerEventsToEvents(events), now))
+		return err
+	})
+	return dropped, err
+}
+
+// sweepLkps removes what's left of dirs whose removal was interrupted and lkps that refer to nothing
+func (s *BadgerStore) sweepLkps() error {
+	// chains in dirs that are gone
+	var orphanKeys [][]byte
+	if err := s.db.View(func(txn *badger.Txn) error {
+		keys, err := iterKeys(txn, []byte(LKP_CHAIN_DIR+":"))
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			dir, err := getObject[BadgerDir](txn, makeKey([]byte(PFX_DIR), idInKey(key, LKP_CHAIN_DIR)))
+			if err != nil {
+				return err
+			}
+			if dir == nil {
+				orphanKeys = append(orphanKeys, key)
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, key := range orphanKeys {
+		if err := s.db.Update(func(txn *badger.Txn) error {
+			return removeChain(txn, key)
+		}); err != nil {
+			return err
+		}
+	}
+
+	type staleKey struct {
+		check lkpCheck
+		key   []byte
+	}
+	var staleKeys []staleKey
+	if err := s.db.View(func(txn *badger.Txn) error {
+		for _, check := range LKP_CHECKS {
+			keys, err := iterKeys(txn, []byte(check.prefix+":"))
+			if err != nil {
+				return err
+			}
+			for _, key := range keys {
+				stale, err := isStaleLkp(txn, check, key)
+				if err != nil {
+					return err
+				}
+				if stale {
+					staleKeys = append(staleKeys, staleKey{check, key})
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	// check again when deleting, keys by ino or name may have been reused since
+	for _, stale := range staleKeys {
+		err := s.db.Update(func(txn *badger.Txn) error {
+			if isStale, err := isStaleLkp(txn, stale.check, stale.key); !isStale || err != nil {
+				return err
+			}
+			return txn.Delete(stale.key)
+		})
+		if err != nil && err != badger.ErrConflict { // it'll be swept next time
+			return err
+		}
+	}
+	return nil
+}
+
+func isStaleLkp(txn *badger.Txn, check lkpCheck, key []byte) (bool, error) {
+	if check.keyPfx != "" {
+		item, err := getItem(txn, makeKey([]byte(check.keyPfx), idInKey(key, check.prefix)))
+		if item == nil || err != nil {
+			return err == nil, err
+		}
+	}
+	id, err := getValue(txn, key)
+	if err != nil {
+		return false, err
+	}
+	item, err := getItem(txn, makeKey([]byte(check.valPfx), id))
+	return item == nil && err == nil, err
+}
+
+// idInKey gets the ID that follows the prefix of a lkp key
+func idInKey(key []byte, prefix string) []byte {
+	start := len(prefix) + 1
+	if len(key) < start+8 || !bytes.HasPrefix(key, []byte(prefix+":")) {
+		return nil
+	}
+	return key[start : start+8]
+}
@@ -63,6 +63,49 @@ func iterVals(txn *badger.Txn, prefix []byte) ([][]byte, error) {
 	return vals, nil
 }
 
+func iterKeys(txn *badger.Txn, prefix []byte) ([][]byte, error) {
+	keys := make([][]byte, 0)
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		keys = append(keys, it.Item().KeyCopy(nil))
+	}
+	return keys, nil
+}
+
+// delete keys, committing along the way if there's too many for one txn
+func deleteKeys(s *BadgerStore, keys [][]byte) error {
+	txn := s.db.NewTransaction(true)
+	defer func() { txn.Discard() }()
+	for _, key := range keys {
+		err := txn.Delete(key)
+		if err == badger.ErrTxnTooBig {
+			if err = txn.Commit(); err != nil {
+				return err
+			}
+			txn = s.db.NewTransaction(true)
+			err = txn.Delete(key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return txn.Commit()
+}
+
+func deletePrefix(s *BadgerStore, prefix []byte) error {
+	var keys [][]byte
+	if err := s.db.View(func(txn *badger.Txn) (err error) {
+		keys, err = iterKeys(txn, prefix)
+		return err
+	}); err != nil {
+		return err
+	}
+	return deleteKeys(s, keys)
+}
+
 // get object from store by key
 func getObject[T any](txn *badger.Txn, key []byte) (*T, error) {
 	value, err := getValue(txn, key)
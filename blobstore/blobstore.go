@@ -0,0 +1,391 @@
+// Content-addressed blob store
+//
+// Every version of a file the scryer sees is copied into the store keyed by its hash.
+// Blobs live at <path>/<first 2 chars of the hash's digest>/<hash> so identical content is stored once.
+// Large files are stored as their chunks (see fnode.Chunk) w/ a manifest at <hash>.chunks listing them,
+// chunks are blobs themselves so near-identical versions share most of their content.
+
+package blobstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ceejimus/kusari/fnode"
+	"github.com/ceejimus/kusari/logger"
+	"github.com/ceejimus/kusari/scry"
+)
+
+const TMP_PREFIX = ".tmp-"
+
+// the suffix of a chunked blob's manifest
+const MANIFEST_SUFFIX = ".chunks"
+
+type BlobStore struct {
+	path string
+}
+
+func NewBlobStore(path string) (*BlobStore, error) {
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to create blob store @ %q:\n%s", path, err.Error()))
+	}
+	return &BlobStore{path: path}, nil
+}
+
+// AddFile copies the file at path into the store if it has the given hash
+// it errors if the file's content doesn't match the hash (e.g. it changed since it was hashed)
+func (b *BlobStore) AddFile(hash string, path string) error {
+	if has, err := b.have(hash); has || err != nil {
+		return err
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err = b.Add(hash, src); err != nil {
+		return errors.New(fmt.Sprintf("Failed to add %q:\n%s", path, err.Error()))
+	}
+	return nil
+}
+
+// Add copies content into the store if it has the given hash
+func (b *BlobStore) Add(hash string, r io.Reader) error {
+	if has, err := b.have(hash); has || err != nil {
+		return err
+	}
+	blobPath, err := b.blobPath(hash)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(blobPath), 0700); err != nil {
+		return err
+	}
+	// copy to a temp file and check the hash as we go
+	tmp, err := os.CreateTemp(filepath.Dir(blobPath), TMP_PREFIX)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	ok, err := fnode.CheckHash(hash, io.TeeReader(r, tmp))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New(fmt.Sprintf("Content doesn't match hash %q", hash))
+	}
+	return os.Rename(tmp.Name(), blobPath)
+}
+
+// AddFileChunks copies the file at path into the store as its chunks
+// it errors if the file's content doesn't match the hash and chunks
+func (b *BlobStore) AddFileChunks(hash string, chunks []fnode.Chunk, path string) error {
+	if has, err := b.have(hash); has || err != nil {
+		return err
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err = b.AddChunks(hash, chunks, src); err != nil {
+		return errors.New(fmt.Sprintf("Failed to add %q:\n%s", path, err.Error()))
+	}
+	return nil
+}
+
+// AddChunks copies content into the store as the given chunks if it has the given hash
+// chunks already in the store (e.g. from an earlier version of the file) aren't copied again
+func (b *BlobStore) AddChunks(hash string, chunks []fnode.Chunk, r io.Reader) error {
+	if has, err := b.have(hash); has || err != nil {
+		return err
+	}
+	algorithm, _, err := fnode.ParseHash(hash)
+	if err != nil {
+		return err
+	}
+	whole, err := fnode.NewHashWriter(algorithm)
+	if err != nil {
+		return err
+	}
+	i := 0
+	err = fnode.SplitChunks(io.TeeReader(r, whole), func(data []byte) error {
+		if i >= len(chunks) || chunks[i].Size != uint64(len(data)) {
+			return errors.New(fmt.Sprintf("Content doesn't match the chunks of %q", hash))
+		}
+		if err := b.Add(chunks[i].Hash, bytes.NewReader(data)); err != nil {
+			return err
+		}
+		i++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if i != len(chunks) || !fnode.SameHash(whole.Sum(), hash) {
+		return errors.New(fmt.Sprintf("Content doesn't match hash %q", hash))
+	}
+	return b.writeManifest(hash, chunks)
+}
+
+// Has checks for a blob w/ the given hash
+func (b *BlobStore) Has(hash string) (bool, error) {
+	blobPath, err := b.blobPath(hash)
+	if err != nil {
+		return false, err
+	}
+	for _, path := range []string{blobPath, blobPath + MANIFEST_SUFFIX} {
+		if _, err = os.Stat(path); err == nil {
+			return true, nil
+		} else if !os.IsNotExist(err) {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// have checks for a blob w/ the given hash like Has
+// a blob that's found is touched so it's as new as one that's added (see GCBefore)
+func (b *BlobStore) have(hash string) (bool, error) {
+	blobPath, err := b.blobPath(hash)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	for _, path := range []string{blobPath, blobPath + MANIFEST_SUFFIX} {
+		if err = os.Chtimes(path, now, now); err == nil {
+			return true, nil
+		} else if !os.IsNotExist(err) {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// Open opens the blob w/ the given hash, it returns nil if there's no such blob
+// chunked blobs are read from their chunks
+func (b *BlobStore) Open(hash string) (io.ReadCloser, error) {
+	blobPath, err := b.blobPath(hash)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(blobPath)
+	if err == nil {
+		return file, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	chunks, err := b.Chunks(hash)
+	if chunks == nil || err != nil {
+		return nil, err
+	}
+	return &chunkReader{blobs: b, chunks: chunks}, nil
+}
+
+// Chunks returns the chunks of a chunked blob, it returns nil if the blob isn't chunked (or there's no such blob)
+func (b *BlobStore) Chunks(hash string) ([]fnode.Chunk, error) {
+	blobPath, err := b.blobPath(hash)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(blobPath + MANIFEST_SUFFIX)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	chunks := make([]fnode.Chunk, 0)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var chunk fnode.Chunk
+		if _, err = fmt.Sscanf(line, "%s %d", &chunk.Hash, &chunk.Size); err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid manifest for %q:\n%s", hash, err.Error()))
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks, nil
+}
+
+// writeManifest lists the chunks of a chunked blob, one "<hash> <size>" per line
+func (b *BlobStore) writeManifest(hash string, chunks []fnode.Chunk) error {
+	blobPath, err := b.blobPath(hash)
+	if err != nil {
+		return err
+	}
+	var manifest strings.Builder
+	for _, chunk := range chunks {
+		manifest.WriteString(fmt.Sprintf("%s %d\n", chunk.Hash, chunk.Size))
+	}
+	if err = os.MkdirAll(filepath.Dir(blobPath), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(blobPath), TMP_PREFIX)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.WriteString(manifest.String())
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), blobPath+MANIFEST_SUFFIX)
+}
+
+// chunkReader reads a chunked blob one chunk at a time
+type chunkReader struct {
+	blobs   *BlobStore
+	chunks  []fnode.Chunk
+	current io.ReadCloser
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.chunks) == 0 {
+				return 0, io.EOF
+			}
+			chunk, err := r.blobs.Open(r.chunks[0].Hash)
+			if err != nil {
+				return 0, err
+			}
+			if chunk == nil {
+				return 0, errors.New(fmt.Sprintf("Missing chunk %q", r.chunks[0].Hash))
+			}
+			r.current = chunk
+			r.chunks = r.chunks[1:]
+		}
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (r *chunkReader) Close() error {
+	if r.current == nil {
+		return nil
+	}
+	return r.current.Close()
+}
+
+// GC removes every blob not referenced by an event in the event store (or kept for a conflict)
+// it returns the number of blobs removed
+func (b *BlobStore) GC(store scry.EventStore) (int, error) {
+	return b.GCBefore(store, time.Now())
+}
+
+// GCBefore is GC for blobs added before the given time
+// newer blobs are kept, the events that refer to them may not be stored yet (e.g. while the scryer's running)
+// adding a blob that's already stored counts as adding it again
+func (b *BlobStore) GCBefore(store scry.EventStore, before time.Time) (int, error) {
+	referenced, err := getReferencedHashes(store)
+	if err != nil {
+		return 0, err
+	}
+	// keep the chunks of every chunked blob we keep
+	for hash := range referenced {
+		chunks, err := b.Chunks(hash)
+		if err != nil {
+			return 0, err
+		}
+		for _, chunk := range chunks {
+			referenced[chunk.Hash] = struct{}{}
+		}
+	}
+	removed := 0
+	err = filepath.WalkDir(b.path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// skip dirs and blobs still being added
+		if d.IsDir() || strings.HasPrefix(d.Name(), TMP_PREFIX) {
+			return nil
+		}
+		if _, ok := referenced[strings.TrimSuffix(d.Name(), MANIFEST_SUFFIX)]; ok {
+			return nil
+		}
+		if info, err := d.Info(); err != nil || !info.ModTime().Before(before) {
+			return err
+		}
+		logger.Trace(fmt.Sprintf("Removing unreferenced blob %q", d.Name()))
+		if err = os.Remove(path); err != nil {
+			return err
+		}
+		removed++
+		return nil
+	})
+	if err != nil {
+		return removed, errors.New(fmt.Sprintf("Failed to collect blobs:\n%s", err.Error()))
+	}
+	return removed, nil
+}
+
+func (b *BlobStore) blobPath(hash string) (string, error) {
+	_, digest, err := fnode.ParseHash(hash)
+	if err != nil || filepath.Base(hash) != hash {
+		return "", errors.New(fmt.Sprintf("Invalid blob hash %q", hash))
+	}
+	return filepath.Join(b.path, digest[:2], hash), nil
+}
+
+// getReferencedHashes returns the hash (and chunk hashes) of every event in every chain of every dir
+// and of the remote side of every conflict (its content is kept until it's resolved)
+func getReferencedHashes(store scry.EventStore) (map[string]struct{}, error) {
+	referenced := make(map[string]struct{})
+	dirs, err := store.GetDirs()
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		chains, err := store.GetChainsInDir(dir.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, chain := range chains {
+			events, err := store.GetEventsInChain(chain.ID)
+			if err != nil {
+				return nil, err
+			}
+			for _, event := range events {
+				addReferences(referenced, &event)
+			}
+		}
+		conflicts, err := store.GetConflictsInDir(dir.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, conflict := range conflicts {
+			addReferences(referenced, &conflict.Remote)
+		}
+	}
+	return referenced, nil
+}
+
+func addReferences(referenced map[string]struct{}, event *scry.Event) {
+	if event.Hash != nil {
+		referenced[*event.Hash] = struct{}{}
+	}
+	for _, chunk := range event.Chunks {
+		referenced[chunk.Hash] = struct{}{}
+	}
+}
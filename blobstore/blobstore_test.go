@@ -0,0 +1,203 @@
+package blobstore
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ceejimus/kusari/fnode"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTmpFile(t *testing.T, content string) (string, string) {
+	path := filepath.Join(t.TempDir(), "f")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := fnode.GetHash(bytes.NewBufferString(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, hash
+}
+
+func TestAddFileThenOpen(t *testing.T) {
+	blobs, err := NewBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, hash := writeTmpFile(t, "i am f")
+
+	has, err := blobs.Has(hash)
+	assert.NoError(t, err)
+	assert.False(t, has)
+
+	if err = blobs.AddFile(hash, path); err != nil {
+		t.Fatal(err)
+	}
+	// adding twice is fine
+	if err = blobs.AddFile(hash, path); err != nil {
+		t.Fatal(err)
+	}
+
+	has, err = blobs.Has(hash)
+	assert.NoError(t, err)
+	assert.True(t, has)
+
+	blob, err := blobs.Open(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.NotNil(t, blob) {
+		defer blob.Close()
+		content, err := io.ReadAll(blob)
+		assert.NoError(t, err)
+		assert.Equal(t, "i am f", string(content))
+	}
+}
+
+func TestAddFileHashMismatch(t *testing.T) {
+	blobs, err := NewBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, hash := writeTmpFile(t, "i am f")
+	// the file changed after it was hashed
+	if err = os.WriteFile(path, []byte("i am changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Error(t, blobs.AddFile(hash, path))
+	has, err := blobs.Has(hash)
+	assert.NoError(t, err)
+	assert.False(t, has)
+	blob, err := blobs.Open(hash)
+	assert.NoError(t, err)
+	assert.Nil(t, blob)
+}
+
+func TestInvalidHash(t *testing.T) {
+	blobs, err := NewBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, _ := writeTmpFile(t, "i am f")
+	assert.Error(t, blobs.AddFile("../../etc", path))
+	_, err = blobs.Open("a/b")
+	assert.Error(t, err)
+}
+
+// test blobs kept under md5 hashes (from before hashes said what they were) are still found and checked
+func TestLegacyHash(t *testing.T) {
+	blobs, err := NewBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, _ := writeTmpFile(t, "i am f")
+	hash, err := fnode.FileHashWith(fnode.HASH_MD5, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, legacy, _ := strings.Cut(hash, fnode.HASH_SEP)
+
+	assert.Error(t, blobs.Add(legacy, bytes.NewBufferString("i am changed")))
+	if err = blobs.AddFile(legacy, path); err != nil {
+		t.Fatal(err)
+	}
+	assert.FileExists(t, filepath.Join(blobs.path, legacy[:2], legacy))
+}
+
+func randomContent(t *testing.T, seed int64, size int) []byte {
+	content := make([]byte, size)
+	if _, err := rand.New(rand.NewSource(seed)).Read(content); err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+// test large files are stored as chunks shared between versions
+func TestChunkedBlobs(t *testing.T) {
+	blobs, err := NewBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	v1 := randomContent(t, 1, 3*fnode.CHUNKED_SIZE)
+	// a few bytes changed in the middle
+	v2 := append([]byte{}, v1...)
+	copy(v2[len(v2)/2:], "i am changed")
+
+	countBlobs := func() int {
+		count := 0
+		filepath.WalkDir(blobs.path, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() && !strings.HasSuffix(d.Name(), MANIFEST_SUFFIX) {
+				count++
+			}
+			return err
+		})
+		return count
+	}
+
+	var v1Chunks int
+	for i, content := range [][]byte{v1, v2} {
+		path := filepath.Join(dir, fmt.Sprintf("v%d", i+1))
+		if err = os.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		hash, chunks, err := fnode.FileChunks(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = blobs.AddFileChunks(hash, chunks, path); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			v1Chunks = len(chunks)
+			assert.Equal(t, v1Chunks, countBlobs())
+		} else {
+			// only the changed chunk (or two if the change moved a cut point) is new
+			assert.LessOrEqual(t, countBlobs(), v1Chunks+2)
+		}
+
+		has, err := blobs.Has(hash)
+		assert.NoError(t, err)
+		assert.True(t, has)
+		stored, err := blobs.Chunks(hash)
+		assert.NoError(t, err)
+		assert.Equal(t, chunks, stored)
+		blob, err := blobs.Open(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if assert.NotNil(t, blob) {
+			got, err := io.ReadAll(blob)
+			blob.Close()
+			assert.NoError(t, err)
+			assert.True(t, bytes.Equal(content, got))
+		}
+	}
+
+	// chunks that don't match the content aren't kept
+	path := filepath.Join(dir, "v3")
+	if err = os.WriteFile(path, v1, 0644); err != nil {
+		t.Fatal(err)
+	}
+	hash, chunks, err := fnode.FileChunks(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(path, v2, 0644); err != nil {
+		t.Fatal(err)
+	}
+	blobs, _ = NewBlobStore(t.TempDir())
+	assert.Error(t, blobs.AddFileChunks(hash, chunks, path))
+	has, err := blobs.Has(hash)
+	assert.NoError(t, err)
+	assert.False(t, has)
+}
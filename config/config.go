@@ -5,16 +5,49 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/ceejimus/kusari/identity"
 	"github.com/ceejimus/kusari/scry"
 	"gopkg.in/yaml.v3"
 )
 
+const DEFAULT_DATA_DIR = "./.data/db"
+const DEFAULT_BLOB_DIR = "./.data/blobs"
+const DEFAULT_SYNC_INTERVAL = 30 * time.Second
+const DEFAULT_DEBOUNCE = 250 * time.Millisecond
+const DEFAULT_COMPACT_INTERVAL = time.Hour
+
+// the identity is kept in DataDir unless IdentityFile is set
+const IDENTITY_FILE_NAME = "identity.pem"
+
+// DSN schemes for the event store, w/o a DSN events are kept in badger @ DataDir
+const (
+	DSN_SQLITE = "sqlite"
+	DSN_MEMORY = "mem" // nothing's kept once kusari stops (no path)
+)
+
 type NodeConfig struct {
-	DSN                string                 `yaml:"dsn"`
+	DSN                string                 `yaml:"dsn"`          // where events are kept instead of DataDir ("sqlite:<path>" or "mem:")
+	NodeID             string                 `yaml:"-"`            // derived from the identity's public key
+	Identity           *identity.Identity     `yaml:"-"`            // loaded from IdentityFile by LoadIdentity
+	IdentityFile       string                 `yaml:"identityFile"` // where this node's private key is kept (in DataDir by default)
+	TrustedKeys        []string               `yaml:"trustedKeys"`  // public keys of the peers we trust (base64)
 	LogLevel           string                 `yaml:"logLevel"`
+	DataDir            string                 `yaml:"dataDir"`
+	BlobDir            string                 `yaml:"blobDir"` // where file content is kept (next to a sqlite store by default)
 	TopDir             string                 `yaml:"topDir"`
 	SrcriedDirectories []scry.ScriedDirectory `yaml:"dirs"`
+	Listen             string                 `yaml:"listen"`           // address to serve peers on (empty to not serve)
+	Peers              []string               `yaml:"peers"`            // addresses of peers to sync from
+	SyncInterval       time.Duration          `yaml:"syncInterval"`     // how often to sync w/ peers
+	Debounce           time.Duration          `yaml:"debounce"`         // how long changes settle before they're stored (negative to store each change right away)
+	RawEvents          bool                   `yaml:"rawEvents"`        // log every filesystem event (before debouncing) for debugging
+	Paranoid           bool                   `yaml:"paranoid"`         // always re-hash files instead of trusting unchanged size/mtime/ctime
+	Retention          scry.Retention         `yaml:"retention"`        // which events are kept when the store is compacted (everything w/o any rules)
+	CompactInterval    time.Duration          `yaml:"compactInterval"`  // how often the store is compacted (badger only)
+	PurgeRemovedDirs   bool                   `yaml:"purgeRemovedDirs"` // remove the history of dirs that aren't in Dirs anymore (it's kept otherwise)
 }
 
 func LoadConfig(filename string) (*NodeConfig, error) {
@@ -29,6 +62,29 @@ func LoadConfig(filename string) (*NodeConfig, error) {
 		return nil, err
 	}
 
+	if config.DataDir == "" {
+		config.DataDir = DEFAULT_DATA_DIR
+	}
+	config.DataDir = filepath.Clean(config.DataDir)
+	if config.BlobDir == "" {
+		config.BlobDir = defaultBlobDir(config.DSN)
+	}
+	config.BlobDir = filepath.Clean(config.BlobDir)
+	if config.IdentityFile == "" {
+		config.IdentityFile = filepath.Join(config.DataDir, IDENTITY_FILE_NAME)
+	}
+	config.IdentityFile = filepath.Clean(config.IdentityFile)
+	if config.SyncInterval == 0 {
+		config.SyncInterval = DEFAULT_SYNC_INTERVAL
+	}
+	if config.CompactInterval == 0 {
+		config.CompactInterval = DEFAULT_COMPACT_INTERVAL
+	}
+	if config.Debounce == 0 {
+		config.Debounce = DEFAULT_DEBOUNCE
+	} else if config.Debounce < 0 {
+		config.Debounce = 0
+	}
 	config.TopDir = filepath.Clean(config.TopDir)
 	for i := range config.SrcriedDirectories {
 		config.SrcriedDirectories[i].Path = filepath.Clean(config.SrcriedDirectories[i].Path)
@@ -37,6 +93,22 @@ func LoadConfig(filename string) (*NodeConfig, error) {
 	return &config, nil
 }
 
+// LoadIdentity loads the node's identity from IdentityFile, w/ create a new one is made if there isn't one
+// only the daemon (and `kusari id`) should create it, other commands just need to know who they are
+func (cnf *NodeConfig) LoadIdentity(create bool) error {
+	var err error
+	if create {
+		cnf.Identity, err = identity.LoadOrCreate(cnf.IdentityFile)
+	} else {
+		cnf.Identity, err = identity.Load(cnf.IdentityFile)
+	}
+	if err != nil {
+		return err
+	}
+	cnf.NodeID = cnf.Identity.NodeID
+	return nil
+}
+
 func (cnf *NodeConfig) Validate() error {
 	err := checkDir(cnf.TopDir)
 	if err != nil {
@@ -49,11 +121,63 @@ func (cnf *NodeConfig) Validate() error {
 		if err != nil {
 			return errors.New(fmt.Sprintf("Invalid ScriedDirectory path - %s", err.Error()))
 		}
+		if _, err = scry.ParseSymlinkPolicy(string(dir.Symlinks)); err != nil {
+			return errors.New(fmt.Sprintf("Invalid ScriedDirectory symlinks - %s", err.Error()))
+		}
+	}
+
+	if _, err = identity.ParseTrustedKeys(cnf.TrustedKeys); err != nil {
+		return errors.New(fmt.Sprintf("Invalid TrustedKeys - %s", err.Error()))
+	}
+
+	if cnf.DSN != "" {
+		if _, _, err = ParseDSN(cnf.DSN); err != nil {
+			return errors.New(fmt.Sprintf("Invalid DSN - %s", err.Error()))
+		}
+	}
+
+	if cnf.SyncInterval < 0 {
+		return errors.New(fmt.Sprintf("Invalid SyncInterval - %s", cnf.SyncInterval))
+	}
+
+	if cnf.CompactInterval < 0 {
+		return errors.New(fmt.Sprintf("Invalid CompactInterval - %s", cnf.CompactInterval))
+	}
+
+	if cnf.Retention.KeepLast < 0 || cnf.Retention.KeepWithin < 0 || cnf.Retention.KeepDaily < 0 {
+		return errors.New(fmt.Sprintf("Invalid Retention - rules can't be negative: %+v", cnf.Retention))
 	}
 
 	return nil
 }
 
+// defaultBlobDir is where content is kept if BlobDir isn't set
+// a sqlite store gets its own so it doesn't collect the content another store refers to
+func defaultBlobDir(dsn string) string {
+	if scheme, path, err := ParseDSN(dsn); err == nil && scheme == DSN_SQLITE {
+		return path + ".blobs"
+	}
+	return DEFAULT_BLOB_DIR
+}
+
+// ParseDSN splits a DSN ("<scheme>:<path>") into its scheme and path
+func ParseDSN(dsn string) (string, string, error) {
+	scheme, path, found := strings.Cut(dsn, ":")
+	if !found {
+		return "", "", errors.New(fmt.Sprintf("DSN must be <scheme>:<path>: %q", dsn))
+	}
+	switch scheme {
+	case DSN_SQLITE:
+		if path == "" {
+			return "", "", errors.New(fmt.Sprintf("DSN must be <scheme>:<path>: %q", dsn))
+		}
+	case DSN_MEMORY:
+	default:
+		return "", "", errors.New(fmt.Sprintf("Unknown DSN scheme %q", scheme))
+	}
+	return scheme, path, nil
+}
+
 func checkDir(path string) error {
 	if path == "" {
 		return errors.New("Empty path")
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ceejimus/kusari/blobstore"
+	"github.com/ceejimus/kusari/resolve"
+	"github.com/ceejimus/kusari/restore"
+	"github.com/ceejimus/kusari/scry"
+)
+
+// kusari conflicts
+func runConflicts(args []string) {
+	flags := flag.NewFlagSet("conflicts", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: kusari conflicts\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	if err := listConflicts(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+// kusari resolve --keep <local|remote|both> <conflictID>
+func runResolve(args []string) {
+	flags := flag.NewFlagSet("resolve", flag.ExitOnError)
+	keep := flags.String("keep", "", "which side to keep - local, remote or both (the remote node is written next to the local one)")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: kusari resolve --keep <local|remote|both> <conflictID>\n")
+		flags.PrintDefaults()
+	}
+	// allow flags on either side of the ID
+	flags.Parse(args)
+	conflictID := flags.Arg(0)
+	if flags.NArg() > 0 {
+		flags.Parse(flags.Args()[1:])
+	}
+	if conflictID == "" || flags.NArg() > 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	resolution, err := resolve.ParseResolution(*keep)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		os.Exit(2)
+	}
+
+	if err := resolveConflict(conflictID, resolution); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+func listConflicts() error {
+	config := loadConfig()
+
+	store, err := openStore(config)
+	if err != nil {
+		return errors.New(fmt.Sprintf("%s\n(is kusari running?)", err.Error()))
+	}
+	defer store.Close()
+
+	dirs, err := store.GetDirs()
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		conflicts, err := store.GetConflictsInDir(dir.ID)
+		if err != nil {
+			return err
+		}
+		for _, conflict := range conflicts {
+			fmt.Printf("%s %s %s\n  local:  %s %s %s\n  remote: %s %s %s\n",
+				restore.FormatID(conflict.ID),
+				conflict.Detected.Format(time.RFC3339),
+				filepath.Join(config.TopDir, dir.Path, conflict.Path),
+				conflict.Local.Origin, conflict.Local.Version, conflict.Local,
+				conflict.Remote.Origin, conflict.Remote.Version, conflict.Remote,
+			)
+		}
+	}
+	return nil
+}
+
+func resolveConflict(conflictID string, resolution resolve.Resolution) error {
+	config := loadConfig()
+	// the resolution is made as this node, but it's not the place to create it
+	loadIdentity(config, false)
+
+	store, err := openStore(config)
+	if err != nil {
+		return errors.New(fmt.Sprintf("%s\n(is kusari running?)", err.Error()))
+	}
+	defer store.Close()
+	blobs, err := blobstore.NewBlobStore(config.BlobDir)
+	if err != nil {
+		return err
+	}
+
+	dir, conflict, err := lkpConflict(store, conflictID)
+	if err != nil {
+		return err
+	}
+	resolver := resolve.NewResolver(config.TopDir, dir, store, scry.Options{NodeID: config.NodeID, Blobs: blobs})
+	changed, err := resolver.Resolve(conflict, resolution)
+	for _, p := range changed {
+		fmt.Printf("Wrote %s\n", filepath.Join(config.TopDir, dir.Path, p))
+	}
+	return err
+}
+
+// lkpConflict finds a conflict (and its Dir) by its ID (as listed by kusari conflicts)
+func lkpConflict(store scry.EventStore, conflictID string) (*scry.Dir, *scry.Conflict, error) {
+	dirs, err := store.GetDirs()
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range dirs {
+		conflicts, err := store.GetConflictsInDir(dirs[i].ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		for j := range conflicts {
+			if restore.FormatID(conflicts[j].ID) == conflictID {
+				return &dirs[i], &conflicts[j], nil
+			}
+		}
+	}
+	return nil, nil, errors.New(fmt.Sprintf("No conflict w/ ID %q", conflictID))
+}
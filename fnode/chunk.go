@@ -0,0 +1,142 @@
+package fnode
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// Large files are split into content-defined chunks (FastCDC w/ normalized chunking).
+// Cut points depend on the content around them, not on offsets, so an edit only changes the chunks it touches
+// and near-identical versions share most of their chunks.
+
+// files at least this big are chunked
+const CHUNKED_SIZE = 1 << 20
+
+// chunk sizes, every chunk but a file's last is at least CHUNK_MIN and at most CHUNK_MAX
+const (
+	CHUNK_MIN = 16 << 10
+	CHUNK_AVG = 64 << 10
+	CHUNK_MAX = 256 << 10
+)
+
+// cut masks, harder to hit before CHUNK_AVG and easier after so chunk sizes bunch up around it
+// they test the high bits of the fingerprint, which depend on the last 64 bytes
+const (
+	CHUNK_MASK_SMALL = uint64(1<<18-1) << (64 - 18)
+	CHUNK_MASK_LARGE = uint64(1<<14-1) << (64 - 14)
+)
+
+// the seed for the gear table, changing it moves every cut point (and breaks dedup w/ older chunks)
+const GEAR_SEED = 0x6b75736172690001
+
+// a chunk of a file's content, chunks are in file order
+type Chunk struct {
+	Hash string
+	Size uint64
+}
+
+var gear [256]uint64
+
+func init() {
+	// splitmix64
+	x := uint64(GEAR_SEED)
+	for i := range gear {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gear[i] = z ^ (z >> 31)
+	}
+}
+
+// cutPoint returns the size of the chunk at the start of data
+// data must hold CHUNK_MAX bytes unless it's the end of the content
+func cutPoint(data []byte) int {
+	n := len(data)
+	if n <= CHUNK_MIN {
+		return n
+	}
+	if n > CHUNK_MAX {
+		n = CHUNK_MAX
+	}
+	normal := CHUNK_AVG
+	if n < normal {
+		normal = n
+	}
+	var fp uint64
+	i := CHUNK_MIN
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&CHUNK_MASK_SMALL == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&CHUNK_MASK_LARGE == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// GetChunks hashes the content of r (like GetHash) and splits it into chunks in one pass
+func GetChunks(r io.Reader) (string, []Chunk, error) {
+	whole, err := NewHashWriter(DEFAULT_HASH)
+	if err != nil {
+		return "", nil, err
+	}
+	chunks := make([]Chunk, 0)
+	err = SplitChunks(io.TeeReader(r, whole), func(data []byte) error {
+		hash, err := GetHash(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		chunks = append(chunks, Chunk{Hash: hash, Size: uint64(len(data))})
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return whole.Sum(), chunks, nil
+}
+
+// FileChunks is GetChunks for the file at path
+func FileChunks(path string) (string, []Chunk, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer file.Close()
+
+	return GetChunks(file)
+}
+
+// SplitChunks calls fn w/ each chunk of the content of r in order
+// the data passed to fn is only valid until it returns
+func SplitChunks(r io.Reader, fn func(data []byte) error) error {
+	buf := make([]byte, CHUNK_MAX)
+	filled := 0
+	eof := false
+	for {
+		// keep a full window unless we're at the end
+		for !eof && filled < len(buf) {
+			n, err := r.Read(buf[filled:])
+			filled += n
+			if err == io.EOF {
+				eof = true
+			} else if err != nil {
+				return err
+			}
+		}
+		if filled == 0 {
+			return nil
+		}
+		cut := cutPoint(buf[:filled])
+		if err := fn(buf[:cut]); err != nil {
+			return err
+		}
+		filled = copy(buf, buf[cut:filled])
+	}
+}
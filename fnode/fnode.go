@@ -17,18 +17,29 @@ type NodeType int
 const (
 	FILE NodeType = iota + 1
 	DIR
+	SYMLINK
 )
 
+// the mode bits we track, permissions plus setuid/setgid/sticky
+const MODE_MASK = fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+
 type NodeState struct {
 	Path    string
 	ModTime time.Time
 	Hash    *string
 	Size    uint64
+	Mode    fs.FileMode // permission bits (see MODE_MASK)
+	Uid     uint32
+	Gid     uint32
+	Target  string  // where a symlink points ("" for other nodes)
+	Chunks  []Chunk // the chunks of a file (nil unless it's at least CHUNKED_SIZE)
 }
 
 type Node struct {
 	Info fs.FileInfo
 	Ino  uint64
+	Uid  uint32
+	Gid  uint32
 	Path string
 }
 
@@ -39,6 +50,8 @@ func (n *Node) Type() NodeType {
 		return FILE
 	} else if mode.IsDir() {
 		return DIR
+	} else if mode&fs.ModeSymlink != 0 {
+		return SYMLINK
 	}
 	return -1
 }
@@ -51,6 +64,18 @@ func (n *Node) ModTime() time.Time {
 	return n.Info.ModTime()
 }
 
+func (n *Node) Mode() fs.FileMode {
+	return n.Info.Mode() & MODE_MASK
+}
+
+// Target returns where a symlink points (as it was given when the link was made)
+func (n *Node) Target() (string, error) {
+	if n.Type() != SYMLINK {
+		return "", errors.ErrUnsupported
+	}
+	return os.Readlink(n.Path)
+}
+
 func (n *Node) Hash() (*string, error) {
 	if n.Type() != FILE {
 		return nil, errors.ErrUnsupported
@@ -64,13 +89,37 @@ func (n *Node) Hash() (*string, error) {
 	return &hash, nil
 }
 
+// Content returns the hash of a file and its chunks if it's big enough to be chunked (see CHUNKED_SIZE)
+func (n *Node) Content() (*string, []Chunk, error) {
+	if n.Type() != FILE || n.Size() < CHUNKED_SIZE {
+		hash, err := n.Hash()
+		return hash, nil, err
+	}
+	hash, chunks, err := FileChunks(n.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &hash, chunks, nil
+}
+
 func (n *Node) State() NodeState {
-	hash, _ := n.Hash()
+	return n.CachedState(nil)
+}
+
+// CachedState is State but w/ the hash from CachedHash
+func (n *Node) CachedState(cache StatCache) NodeState {
+	hash, chunks, _ := n.CachedContent(cache)
+	target, _ := n.Target()
 	return NodeState{
 		Path:    n.Path,
 		ModTime: n.ModTime(),
 		Hash:    hash,
 		Size:    n.Size(),
+		Mode:    n.Mode(),
+		Uid:     n.Uid,
+		Gid:     n.Gid,
+		Target:  target,
+		Chunks:  chunks,
 	}
 }
 
@@ -81,23 +130,26 @@ func (n Node) String() string {
 		nodeTypeStr = "-"
 	} else if n.Type() == DIR {
 		nodeTypeStr = "d"
+	} else if n.Type() == SYMLINK {
+		nodeTypeStr = "l"
 	}
 	b.WriteString(fmt.Sprintf("(%.8d) ", n.Ino))
 	b.WriteString(fmt.Sprintf("%v ", nodeTypeStr))
+	b.WriteString(fmt.Sprintf("%04o %d:%d ", n.Mode(), n.Uid, n.Gid))
 	b.WriteString(fmt.Sprintf("%.8d", n.Size()))
 	//	01/02 03:04:05PM '06 -0700
 	//	Mon Jan 2 15:04:05 MST 2006
 	b.WriteString(fmt.Sprintf(" %s", n.ModTime().Format("2006-01-02 15:04:05 MST")))
 	b.WriteString(fmt.Sprintf(" %s", n.Info.Name()))
-	hash, err := n.Hash()
-	if err == nil {
-		b.WriteString(fmt.Sprintf(" |%s|", *hash))
+	if target, err := n.Target(); err == nil {
+		b.WriteString(fmt.Sprintf(" -> %s", target))
 	}
 	return b.String()
 }
 
 func (n NodeState) String() string {
 	var b strings.Builder
+	b.WriteString(fmt.Sprintf("%04o %d:%d ", n.Mode, n.Uid, n.Gid))
 	b.WriteString(fmt.Sprintf("%.8d", n.Size))
 	//	01/02 03:04:05PM '06 -0700
 	//	Mon Jan 2 15:04:05 MST 2006
@@ -106,6 +158,9 @@ func (n NodeState) String() string {
 	if n.Hash != nil {
 		b.WriteString(fmt.Sprintf(" |%s|", *n.Hash))
 	}
+	if n.Target != "" {
+		b.WriteString(fmt.Sprintf(" -> %s", n.Target))
+	}
 	return b.String()
 }
 
@@ -124,6 +179,30 @@ func NewNodeFromInfo(path string, info os.FileInfo) (*Node, error) {
 		return nil, errors.New(fmt.Sprintf("Failed to extract Ino from node: %q", path))
 	}
 
-	return &Node{Info: info, Ino: stat.Ino, Path: path}, nil
+	return &Node{Info: info, Ino: stat.Ino, Uid: stat.Uid, Gid: stat.Gid, Path: path}, nil
+
+}
 
+// Follow returns the node a symlink points to, in place of the link
+// it keeps the link's path and inode (so it's still tracked as the link) but has the state of the target
+// links to anything but a regular file (dirs, dangling links) are returned as is
+func Follow(node *Node) (*Node, error) {
+	if node.Type() != SYMLINK {
+		return node, nil
+	}
+	info, err := os.Stat(node.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return node, nil
+		}
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return node, nil
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Failed to extract owner from node: %q", node.Path))
+	}
+	return &Node{Info: info, Ino: node.Ino, Uid: stat.Uid, Gid: stat.Gid, Path: node.Path}, nil
 }
@@ -2,9 +2,11 @@ package fnode
 
 import (
 	"bytes"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/ceejimus/kusari/logger"
 	"github.com/stretchr/testify/assert"
@@ -74,3 +76,240 @@ func TestFileHashWorks(t *testing.T) {
 
 	assert.Equal(t, got, wanted)
 }
+
+func TestSymlinkNode(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "f")
+	if err := os.WriteFile(path, []byte("i am f"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "l")
+	if err := os.Symlink("f", link); err != nil {
+		t.Fatal(err)
+	}
+
+	node, err := NewNode(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, SYMLINK, node.Type())
+	state := node.State()
+	assert.Equal(t, "f", state.Target)
+	assert.Nil(t, state.Hash)
+
+	// following it gets the file, but still tracked as the link
+	followed, err := Follow(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, FILE, followed.Type())
+	assert.Equal(t, node.Ino, followed.Ino)
+	assert.Equal(t, link, followed.Path)
+	wanted, _ := FileHash(path)
+	state = followed.State()
+	assert.Equal(t, wanted, *state.Hash)
+	assert.Equal(t, "", state.Target)
+	assert.Equal(t, os.FileMode(0600), state.Mode)
+
+	// dangling links stay links
+	if err = os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	followed, err = Follow(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, SYMLINK, followed.Type())
+
+	// links are replaced in place
+	if err = WriteLink(link, "g"); err != nil {
+		t.Fatal(err)
+	}
+	target, err := os.Readlink(link)
+	assert.NoError(t, err)
+	assert.Equal(t, "g", target)
+}
+
+func TestCheckNoLinks(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "d/s"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(t.TempDir(), filepath.Join(root, "d/l")); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NoError(t, CheckNoLinks(root, filepath.Join(root, "d/s")))
+	assert.NoError(t, CheckNoLinks(root, filepath.Join(root, "d/s/new/f")), "missing nodes are fine")
+	assert.Error(t, CheckNoLinks(root, filepath.Join(root, "d/l")))
+	assert.Error(t, CheckNoLinks(root, filepath.Join(root, "d/l/f")))
+	assert.Error(t, CheckNoLinks(root, filepath.Join(root, "../f")), "nodes outside root aren't under it")
+	assert.NoError(t, CheckNoLinks(filepath.Join(root, "d/l"), filepath.Join(root, "d/l")), "root itself may be a link")
+}
+
+func TestParseHash(t *testing.T) {
+	sha, err := GetHash(bytes.NewBufferString("i am test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	algorithm, digest, err := ParseHash(sha)
+	assert.NoError(t, err)
+	assert.Equal(t, HASH_SHA256, algorithm)
+	assert.Len(t, digest, 64)
+
+	// bare digests are md5
+	algorithm, _, err = ParseHash("b1946ac92492d2347c6235b4d2611184")
+	assert.NoError(t, err)
+	assert.Equal(t, HASH_MD5, algorithm)
+
+	for _, invalid := range []string{"", "nope:b1946ac92492d2347c6235b4d2611184", "sha256:b1946ac92492d2347c6235b4d2611184", "md5:xyz"} {
+		_, _, err = ParseHash(invalid)
+		assert.Error(t, err, "%q", invalid)
+	}
+}
+
+func TestCheckHash(t *testing.T) {
+	content := "hello\n"
+	for _, hash := range []string{
+		"b1946ac92492d2347c6235b4d2611184",
+		"md5:b1946ac92492d2347c6235b4d2611184",
+		"sha256:5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03",
+	} {
+		ok, err := CheckHash(hash, bytes.NewBufferString(content))
+		assert.NoError(t, err)
+		assert.True(t, ok, "%q", hash)
+		ok, err = CheckHash(hash, bytes.NewBufferString("goodbye\n"))
+		assert.NoError(t, err)
+		assert.False(t, ok, "%q", hash)
+	}
+}
+
+// a StatCache that counts the entries put in it
+type mapStatCache struct {
+	entries map[uint64]StatEntry
+	puts    int
+}
+
+func (c *mapStatCache) GetStatEntry(ino uint64) (*StatEntry, error) {
+	entry, ok := c.entries[ino]
+	if !ok {
+		return nil, nil
+	}
+	return &entry, nil
+}
+
+func (c *mapStatCache) PutStatEntry(entry *StatEntry) error {
+	c.entries[entry.Ino] = *entry
+	c.puts++
+	return nil
+}
+
+func TestStatCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "f")
+	if err := os.WriteFile(path, []byte("i am f"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	wanted, _ := FileHash(path)
+	cache := &mapStatCache{entries: make(map[uint64]StatEntry)}
+
+	// files that just changed are hashed but not cached
+	node, err := NewNode(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := node.CachedHash(cache)
+	assert.NoError(t, err)
+	assert.Equal(t, wanted, *hash)
+	assert.Equal(t, 0, cache.puts)
+
+	// once they've settled they are
+	time.Sleep(RACY_WINDOW)
+	hash, err = node.CachedHash(cache)
+	assert.NoError(t, err)
+	assert.Equal(t, wanted, *hash)
+	assert.Equal(t, 1, cache.puts)
+
+	// an unchanged stat trusts the cache
+	entry := cache.entries[node.Ino]
+	entry.Hash = "sha256:cached"
+	cache.entries[node.Ino] = entry
+	hash, err = node.CachedHash(cache)
+	assert.NoError(t, err)
+	assert.Equal(t, "sha256:cached", *hash)
+	assert.Equal(t, "sha256:cached", *node.CachedState(cache).Hash)
+
+	// w/o a cache (paranoid) the file is always read
+	hash, err = node.CachedHash(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, wanted, *hash)
+
+	// a write w/ the same size changes the times
+	if err = os.WriteFile(path, []byte("i am g"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if node, err = NewNode(path); err != nil {
+		t.Fatal(err)
+	}
+	wanted, _ = FileHash(path)
+	hash, err = node.CachedHash(cache)
+	assert.NoError(t, err)
+	assert.Equal(t, wanted, *hash)
+}
+
+func TestChunks(t *testing.T) {
+	content := make([]byte, 4*CHUNKED_SIZE)
+	rand.New(rand.NewSource(1)).Read(content)
+
+	hash, chunks, err := GetChunks(bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wanted, _ := GetHash(bytes.NewReader(content))
+	assert.Equal(t, wanted, hash)
+	total := uint64(0)
+	for i, chunk := range chunks {
+		if i < len(chunks)-1 {
+			assert.GreaterOrEqual(t, chunk.Size, uint64(CHUNK_MIN))
+		}
+		assert.LessOrEqual(t, chunk.Size, uint64(CHUNK_MAX))
+		chunkHash, _ := GetHash(bytes.NewReader(content[total : total+chunk.Size]))
+		assert.Equal(t, chunkHash, chunk.Hash)
+		total += chunk.Size
+	}
+	assert.Equal(t, uint64(len(content)), total)
+
+	// cut points follow the content, so inserting bytes at the start only changes the first chunk
+	shifted := append([]byte("i am inserted"), content...)
+	_, shiftedChunks, err := GetChunks(bytes.NewReader(shifted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, chunk := range chunks {
+		seen[chunk.Hash] = true
+	}
+	shared := 0
+	for _, chunk := range shiftedChunks {
+		if seen[chunk.Hash] {
+			shared++
+		}
+	}
+	assert.GreaterOrEqual(t, shared, len(chunks)-2)
+
+	// only big files are chunked
+	dir := t.TempDir()
+	for _, size := range []int{CHUNKED_SIZE - 1, CHUNKED_SIZE} {
+		path := filepath.Join(dir, "f")
+		if err = os.WriteFile(path, content[:size], 0600); err != nil {
+			t.Fatal(err)
+		}
+		node, err := NewNode(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		state := node.State()
+		wanted, _ := GetHash(bytes.NewReader(content[:size]))
+		assert.Equal(t, wanted, *state.Hash)
+		assert.Equal(t, size >= CHUNKED_SIZE, state.Chunks != nil, "%d bytes", size)
+	}
+}
@@ -1,11 +1,12 @@
 package fnode
 
 import (
-	"crypto/md5"
-	"encoding/hex"
-	"io"
+	"errors"
+	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 func GetRelativePath(fullPath string, relDir string) string {
@@ -13,21 +14,69 @@ func GetRelativePath(fullPath string, relDir string) string {
 	return relPath
 }
 
-func FileHash(path string) (string, error) {
-	file, err := os.Open(path)
+// SetMeta sets the mode and ownership of the node at path
+// a zero mode means the state is unknown (e.g. events stored before modes were) and nothing is changed
+// ownership is only changed when we're root, other users can't give files away
+// symlinks don't have a mode of their own (chmod would change what they point to), only their owner is set
+func SetMeta(path string, mode fs.FileMode, uid uint32, gid uint32) error {
+	if mode == 0 {
+		return nil
+	}
+	info, err := os.Lstat(path)
 	if err != nil {
-		return "", err
+		return err
 	}
-	defer file.Close()
-
-	return GetHash(file)
+	if info.Mode()&fs.ModeSymlink == 0 {
+		if err = os.Chmod(path, mode&MODE_MASK); err != nil {
+			return errors.New(fmt.Sprintf("Failed to set mode of %q:\n%s", path, err.Error()))
+		}
+	}
+	if os.Geteuid() != 0 {
+		return nil
+	}
+	if err := os.Lchown(path, int(uid), int(gid)); err != nil {
+		return errors.New(fmt.Sprintf("Failed to set owner of %q:\n%s", path, err.Error()))
+	}
+	return nil
 }
 
-func GetHash(r io.Reader) (string, error) {
-	hash := md5.New()
-	if _, err := io.Copy(hash, r); err != nil {
-		return "", err
+// WriteLink makes the node at path a symlink to target, replacing whatever is there
+// the link is made next to it and moved into place so there's never nothing at path
+func WriteLink(path string, target string) error {
+	tmpPath := filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.link-%d", filepath.Base(path), os.Getpid()))
+	if err := os.Symlink(target, tmpPath); err != nil {
+		return errors.New(fmt.Sprintf("Failed to link %q -> %q:\n%s", path, target, err.Error()))
 	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return errors.New(fmt.Sprintf("Failed to link %q -> %q:\n%s", path, target, err.Error()))
+	}
+	return nil
+}
 
-	return hex.EncodeToString(hash.Sum(nil)), nil
+// CheckNoLinks makes sure none of the nodes under root on the way to path (path included) are symlinks
+// so writing at path can't end up outside of root, nodes that don't exist yet are fine
+func CheckNoLinks(root string, path string) error {
+	relPath, err := filepath.Rel(root, path)
+	if err != nil || !filepath.IsLocal(relPath) {
+		return errors.New(fmt.Sprintf("%q isn't under %q", path, root))
+	}
+	if relPath == "." { // root itself may be a link
+		return nil
+	}
+	currPath := root
+	for _, name := range strings.Split(relPath, string(filepath.Separator)) {
+		currPath = filepath.Join(currPath, name)
+		info, err := os.Lstat(currPath)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return errors.New(fmt.Sprintf("Won't write under %q, it's a symlink", currPath))
+		}
+	}
+	return nil
 }
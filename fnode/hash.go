@@ -0,0 +1,136 @@
+package fnode
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+// Hashes are self-describing: "<algorithm>:<hex digest>" (e.g. "sha256:9f86d0...").
+// Hashes stored before algorithms were pluggable are bare md5 hex digests and are still read as md5.
+
+const (
+	HASH_MD5    = "md5"
+	HASH_SHA256 = "sha256"
+)
+
+// the algorithm new hashes are made w/
+const DEFAULT_HASH = HASH_SHA256
+
+// separates the algorithm from the digest in an encoded hash
+const HASH_SEP = ":"
+
+var hashAlgorithms = map[string]func() hash.Hash{
+	HASH_MD5:    md5.New,
+	HASH_SHA256: sha256.New,
+}
+
+// ParseHash splits an encoded hash into its algorithm and hex digest
+func ParseHash(encoded string) (algorithm string, digest string, err error) {
+	algorithm, digest, found := strings.Cut(encoded, HASH_SEP)
+	if !found { // from before hashes said what they were
+		algorithm, digest = HASH_MD5, encoded
+	}
+	newHash, ok := hashAlgorithms[algorithm]
+	if !ok {
+		return "", "", errors.New(fmt.Sprintf("Unknown hash algorithm %q in %q", algorithm, encoded))
+	}
+	if raw, err := hex.DecodeString(digest); err != nil || len(raw) != newHash().Size() {
+		return "", "", errors.New(fmt.Sprintf("Invalid %s digest in %q", algorithm, encoded))
+	}
+	return algorithm, digest, nil
+}
+
+// GetHash hashes the content of r w/ the default algorithm
+func GetHash(r io.Reader) (string, error) {
+	return GetHashWith(DEFAULT_HASH, r)
+}
+
+// GetHashWith hashes the content of r w/ the given algorithm
+func GetHashWith(algorithm string, r io.Reader) (string, error) {
+	w, err := NewHashWriter(algorithm)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		return "", err
+	}
+	return w.Sum(), nil
+}
+
+// HashWriter hashes what's written to it
+type HashWriter struct {
+	hash.Hash
+	algorithm string
+}
+
+// NewHashWriter makes a HashWriter for the given algorithm
+func NewHashWriter(algorithm string) (*HashWriter, error) {
+	newHash, ok := hashAlgorithms[algorithm]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Unknown hash algorithm %q", algorithm))
+	}
+	return &HashWriter{Hash: newHash(), algorithm: algorithm}, nil
+}
+
+// Sum returns the encoded hash of what's been written
+func (w *HashWriter) Sum() string {
+	return w.algorithm + HASH_SEP + hex.EncodeToString(w.Hash.Sum(nil))
+}
+
+// SameHash tells if two encoded hashes are the same (a bare md5 digest is the same as the md5 hash)
+func SameHash(a string, b string) bool {
+	aAlgorithm, aDigest, err := ParseHash(a)
+	if err != nil {
+		return false
+	}
+	bAlgorithm, bDigest, err := ParseHash(b)
+	if err != nil {
+		return false
+	}
+	return aAlgorithm == bAlgorithm && aDigest == bDigest
+}
+
+func FileHash(path string) (string, error) {
+	return FileHashWith(DEFAULT_HASH, path)
+}
+
+func FileHashWith(algorithm string, path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	return GetHashWith(algorithm, file)
+}
+
+// CheckHash hashes the content of r w/ the algorithm of the expected hash and tells if they match
+func CheckHash(expected string, r io.Reader) (bool, error) {
+	algorithm, _, err := ParseHash(expected)
+	if err != nil {
+		return false, err
+	}
+	actual, err := GetHashWith(algorithm, r)
+	if err != nil {
+		return false, err
+	}
+	return SameHash(actual, expected), nil
+}
+
+// CheckFileHash tells if the file at path has the expected hash
+func CheckFileHash(expected string, path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	return CheckHash(expected, file)
+}
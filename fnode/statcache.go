@@ -0,0 +1,78 @@
+package fnode
+
+import (
+	"syscall"
+	"time"
+)
+
+// a file whose stat changed less than this long ago isn't cached
+// the file could still be written w/o its times changing (they're only as fine as the filesystem's clock)
+const RACY_WINDOW = time.Second
+
+// StatEntry is the hash a file had when it had this stat
+type StatEntry struct {
+	Ino     uint64
+	Size    uint64
+	ModTime time.Time
+	Ctime   time.Time
+	Hash    string
+	Chunks  []Chunk
+}
+
+// StatCache keeps the hashes of files by inode so unchanged files aren't hashed again
+type StatCache interface {
+	// get the entry for the file w/ ino, nil if there's none
+	GetStatEntry(ino uint64) (*StatEntry, error)
+	// set the entry for the file w/ entry.Ino
+	PutStatEntry(entry *StatEntry) error
+}
+
+// Ctime returns when the node's inode last changed
+func (n *Node) Ctime() time.Time {
+	stat, ok := n.Info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(stat.Ctim.Sec, stat.Ctim.Nsec)
+}
+
+// matches tells if the entry was made for a file w/ the node's stat
+func (e *StatEntry) matches(n *Node) bool {
+	return e.Ino == n.Ino && e.Size == n.Size() && e.ModTime.Equal(n.ModTime()) && e.Ctime.Equal(n.Ctime())
+}
+
+// CachedHash is Hash but only reads the file if the cache doesn't have a hash for its stat
+// a nil cache always reads the file
+func (n *Node) CachedHash(cache StatCache) (*string, error) {
+	hash, _, err := n.CachedContent(cache)
+	return hash, err
+}
+
+// CachedContent is Content but only reads the file if the cache doesn't have its content for its stat
+// a nil cache always reads the file
+func (n *Node) CachedContent(cache StatCache) (*string, []Chunk, error) {
+	if cache == nil || n.Type() != FILE {
+		return n.Content()
+	}
+	entry, err := cache.GetStatEntry(n.Ino)
+	if err != nil {
+		return nil, nil, err
+	}
+	// entries from before files were chunked don't have the chunks
+	if entry != nil && entry.matches(n) && (entry.Chunks != nil || n.Size() < CHUNKED_SIZE) {
+		return &entry.Hash, entry.Chunks, nil
+	}
+	hash, chunks, err := n.Content()
+	if err != nil {
+		return nil, nil, err
+	}
+	ctime := n.Ctime()
+	if time.Since(ctime) < RACY_WINDOW {
+		return hash, chunks, nil
+	}
+	entry = &StatEntry{Ino: n.Ino, Size: n.Size(), ModTime: n.ModTime(), Ctime: ctime, Hash: *hash, Chunks: chunks}
+	if err = cache.PutStatEntry(entry); err != nil {
+		return nil, nil, err
+	}
+	return hash, chunks, nil
+}
@@ -0,0 +1,170 @@
+// Node identities
+//
+// Every kusari node has an Ed25519 keypair generated on its first run.
+// The node ID is derived from the public key so peers can check a node is who it says it is.
+
+package identity
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const PEM_TYPE = "PRIVATE KEY"
+
+// number of bytes of the public key's hash used for the node ID
+const NODE_ID_BYTES = 10
+
+// how long node certificates are valid for (they're made fresh on every start)
+const CERT_VALIDITY = 365 * 24 * time.Hour
+
+type Identity struct {
+	NodeID     string
+	PublicKey  ed25519.PublicKey
+	PrivateKey ed25519.PrivateKey
+}
+
+// Generate creates a new identity w/ a random keypair
+func Generate() (*Identity, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return FromKey(key), nil
+}
+
+func FromKey(key ed25519.PrivateKey) *Identity {
+	public := key.Public().(ed25519.PublicKey)
+	return &Identity{NodeID: NodeIDFor(public), PublicKey: public, PrivateKey: key}
+}
+
+// Load loads the identity stored at path, it fails if there isn't one
+func Load(path string) (*Identity, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to read identity @ %q:\n%s", path, err.Error()))
+	}
+	return parse(path, data)
+}
+
+// LoadOrCreate loads the identity stored at path, generating and storing a new one if there isn't one
+func LoadOrCreate(path string) (*Identity, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return parse(path, data)
+	}
+	if !os.IsNotExist(err) {
+		return nil, errors.New(fmt.Sprintf("Failed to read identity @ %q:\n%s", path, err.Error()))
+	}
+	id, err := Generate()
+	if err != nil {
+		return nil, err
+	}
+	if err = id.save(path); err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to store identity @ %q:\n%s", path, err.Error()))
+	}
+	return id, nil
+}
+
+// EncodedPublicKey returns the public key as it's given in other nodes' trusted keys
+func (id *Identity) EncodedPublicKey() string {
+	return EncodePublicKey(id.PublicKey)
+}
+
+func (id *Identity) String() string {
+	return fmt.Sprintf("%s (%s)", id.NodeID, id.EncodedPublicKey())
+}
+
+// NodeIDFor returns the ID of the node w/ the given public key
+func NodeIDFor(key ed25519.PublicKey) string {
+	hash := sha256.Sum256(key)
+	return strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(hash[:NODE_ID_BYTES]))
+}
+
+func EncodePublicKey(key ed25519.PublicKey) string {
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Invalid public key %q:\n%s", s, err.Error()))
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, errors.New(fmt.Sprintf("Invalid public key %q, wrong size", s))
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// save writes the private key (PKCS #8, PEM encoded) readable only by us
+func (id *Identity) save(path string) error {
+	der, err := x509.MarshalPKCS8PrivateKey(id.PrivateKey)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	// never clobber a key that showed up in the meantime
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	err = pem.Encode(file, &pem.Block{Type: PEM_TYPE, Bytes: der})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func parse(path string, data []byte) (*Identity, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != PEM_TYPE {
+		return nil, errors.New(fmt.Sprintf("No private key in identity @ %q", path))
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Invalid private key in identity @ %q:\n%s", path, err.Error()))
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Identity @ %q isn't an Ed25519 key", path))
+	}
+	return FromKey(edKey), nil
+}
+
+// Certificate returns a self-signed TLS certificate for the node's key
+// peers pin the key itself so the certificate's other fields (and expiry) don't matter
+func (id *Identity) Certificate() (tls.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: id.NodeID},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(CERT_VALIDITY),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, id.PublicKey, id.PrivateKey)
+	if err != nil {
+		return tls.Certificate{}, errors.New(fmt.Sprintf("Failed to create certificate for %s:\n%s", id.NodeID, err.Error()))
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: id.PrivateKey}, nil
+}
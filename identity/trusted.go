@@ -0,0 +1,31 @@
+package identity
+
+import (
+	"crypto/ed25519"
+)
+
+// the public keys of the nodes we sync w/, by node ID
+type TrustedKeys map[string]ed25519.PublicKey
+
+// ParseTrustedKeys parses a list of (base64 encoded) public keys
+func ParseTrustedKeys(keys []string) (TrustedKeys, error) {
+	trusted := make(TrustedKeys, len(keys))
+	for _, s := range keys {
+		key, err := ParsePublicKey(s)
+		if err != nil {
+			return nil, err
+		}
+		trusted.Add(key)
+	}
+	return trusted, nil
+}
+
+func (t TrustedKeys) Add(key ed25519.PublicKey) {
+	t[NodeIDFor(key)] = key
+}
+
+// Trusts tells if the key is trusted and belongs to the node w/ the given ID
+func (t TrustedKeys) Trusts(nodeID string, key ed25519.PublicKey) bool {
+	trusted, ok := t[nodeID]
+	return ok && trusted.Equal(key)
+}
@@ -1,16 +1,219 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/ceejimus/kusari/badgerstore"
+	"github.com/ceejimus/kusari/blobstore"
 	"github.com/ceejimus/kusari/config"
+	"github.com/ceejimus/kusari/identity"
 	"github.com/ceejimus/kusari/logger"
+	"github.com/ceejimus/kusari/memstore"
+	"github.com/ceejimus/kusari/peer"
+	"github.com/ceejimus/kusari/scry"
+	"github.com/ceejimus/kusari/sqlitestore"
 )
 
 const CONFIG_YAML_PATH = "./.data/cnf.yaml"
 
 func main() {
+	// subcommands, w/o one we run the daemon
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "restore":
+			runRestore(os.Args[2:])
+			return
+		case "conflicts":
+			runConflicts(os.Args[2:])
+			return
+		case "resolve":
+			runResolve(os.Args[2:])
+			return
+		case "id":
+			// print what peers need to trust us
+			config := loadConfig()
+			loadIdentity(config, true)
+			fmt.Printf("node ID:    %s\npublic key: %s\n", config.NodeID, config.Identity.EncodedPublicKey())
+			return
+		}
+	}
+
+	config := loadConfig()
+	loadIdentity(config, true)
+
+	logger.Info(fmt.Sprintf("Running w/ config:%v\n", config))
+	logger.Info(fmt.Sprintf("Running as node %s", config.Identity))
+
+	// open the event store
+	store, err := openStore(config)
+	if err != nil {
+		logger.Fatal(err.Error())
+		os.Exit(1)
+	}
+	defer store.Close()
+
+	// a store w/o any dirs is new (or in memory), none of the content in the blob dir is from it
+	stored, err := store.GetDirs()
+	if err != nil {
+		logger.Fatal(err.Error())
+		os.Exit(1)
+	}
+	freshStore := len(stored) == 0
+
+	// make sure every configured directory is in the store, the ones that aren't configured anymore aren't scried
+	added, inactive, err := scry.ReconcileDirs(store, config.SrcriedDirectories, config.PurgeRemovedDirs)
+	if err != nil {
+		logger.Fatal(err.Error())
+		os.Exit(1)
+	}
+	for _, dir := range added {
+		logger.Info(fmt.Sprintf("Added new dir %q", dir.Path))
+	}
+	for _, dir := range inactive {
+		if config.PurgeRemovedDirs {
+			logger.Info(fmt.Sprintf("Purged dir %q, it's not configured anymore", dir.Path))
+		} else {
+			logger.Info(fmt.Sprintf("Not scrying dir %q, it's not configured anymore (its history is kept)", dir.Path))
+		}
+	}
+
+	// open the blob store for file content
+	blobs, err := blobstore.NewBlobStore(config.BlobDir)
+	if err != nil {
+		logger.Fatal(err.Error())
+		os.Exit(1)
+	}
+	// drop content no event refers to anymore (before the scryer starts adding more)
+	// unless the store is new, the content may be the history of another one (e.g. before the DSN changed)
+	if freshStore {
+		logger.Info(fmt.Sprintf("Not collecting blobs in %q, the store has no history yet", config.BlobDir))
+	} else if removed, err := blobs.GC(store); err != nil {
+		logger.Error(err.Error())
+	} else if removed > 0 {
+		logger.Info(fmt.Sprintf("Removed %d unreferenced blobs", removed))
+	}
+
+	// prune history to the retention policy and clean up what's been dropped (content too)
+	if bdgStore, ok := store.(*badgerstore.BadgerStore); ok {
+		var collector badgerstore.BlobCollector
+		if !freshStore {
+			collector = blobs
+		}
+		compactor := bdgStore.StartCompactor(config.Retention, config.CompactInterval, collector)
+		defer compactor.Stop()
+	} else if !config.Retention.IsZero() {
+		logger.Warn("Retention is only enforced for badger stores, keeping everything")
+	}
+
+	opts := scry.Options{NodeID: config.NodeID, Blobs: blobs, Debounce: config.Debounce, RawEvents: config.RawEvents, Paranoid: config.Paranoid, Applier: scry.NewApplier()}
+	scryer, err := scry.InitScryer(config.TopDir, config.SrcriedDirectories, store, opts)
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Failed to start scryer\n%s", err))
+		os.Exit(1)
+	}
+
+	go scryer.Run()
+	if config.RawEvents {
+		go logRawEvents(scryer.RawChanRx)
+	}
+
+	// serve our store to peers and pull from theirs
+	trusted, err := identity.ParseTrustedKeys(config.TrustedKeys)
+	if err != nil {
+		logger.Fatal(err.Error())
+		os.Exit(1)
+	}
+	node, err := peer.NewNode(config.TopDir, config.SrcriedDirectories, store, opts, config.Identity, trusted)
+	if err != nil {
+		logger.Fatal(err.Error())
+		os.Exit(1)
+	}
+	if config.Listen != "" {
+		if err = node.Listen(config.Listen); err != nil {
+			logger.Fatal(err.Error())
+			os.Exit(1)
+		}
+		go node.Serve()
+	}
+	stopSync := make(chan struct{})
+	if len(config.Peers) > 0 {
+		go syncPeers(node, config.Peers, config.SyncInterval, stopSync)
+	}
+
+	// wait for the signal to shut down
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-sigs
+	logger.Info(fmt.Sprintf("Received %s, shutting down", sig))
+
+	close(stopSync)
+	if err = node.Close(); err != nil {
+		logger.Error(fmt.Sprintf("Failed to close peer node\n%s", err))
+	}
+	if err = scryer.Close(); err != nil {
+		logger.Error(fmt.Sprintf("Failed to close scryer\n%s", err))
+	}
+}
+
+// openStore opens the event store the config's DSN points at (badger @ DataDir w/o one)
+func openStore(cnf *config.NodeConfig) (scry.EventStore, error) {
+	if cnf.DSN == "" {
+		store, err := badgerstore.NewBadgerStore(cnf.DataDir)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Failed to open store @ %q\n%s", cnf.DataDir, err))
+		}
+		return store, nil
+	}
+	scheme, path, err := config.ParseDSN(cnf.DSN)
+	if err != nil {
+		return nil, err
+	}
+	switch scheme {
+	case config.DSN_SQLITE:
+		store, err := sqlitestore.NewSqliteStore(path)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Failed to open store @ %q\n%s", cnf.DSN, err))
+		}
+		return store, nil
+	case config.DSN_MEMORY:
+		logger.Warn("Keeping events in memory, they're lost when kusari stops")
+		return memstore.NewMemStore(), nil
+	}
+	return nil, errors.New(fmt.Sprintf("Unknown DSN scheme %q", scheme))
+}
+
+// syncPeers pulls from every peer on an interval until stopped
+func syncPeers(node *peer.Node, peers []string, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, addr := range peers {
+			if err := node.SyncWith(addr); err != nil {
+				logger.Warn(fmt.Sprintf("Failed to sync w/ peer %q\n%s", addr, err))
+			}
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// logRawEvents logs the filesystem events the scryer receives before they're debounced
+func logRawEvents(rx <-chan scry.NodeEvent) {
+	for nodeEvent := range rx {
+		logger.Info(fmt.Sprintf("Raw event: %s %q", nodeEvent.Type, nodeEvent.FullPath))
+	}
+}
+
+// loadConfig loads and validates the config and initializes the logger, it exits on failure
+func loadConfig() *config.NodeConfig {
 	config, err := config.LoadConfig(CONFIG_YAML_PATH)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to parse YAML config @ '%s'\n%s\n", CONFIG_YAML_PATH, err)
@@ -25,5 +228,13 @@ func main() {
 
 	logger.Init(config.LogLevel)
 
-	logger.Info(fmt.Sprintf("Running w/ config:%v\n", config))
+	return config
+}
+
+// loadIdentity loads the node's identity (creating it w/ create), it exits on failure
+func loadIdentity(config *config.NodeConfig, create bool) {
+	if err := config.LoadIdentity(create); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load identity\n%s\n(has kusari been run yet?)\n", err.Error())
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,313 @@
+package memstore
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ceejimus/kusari/fnode"
+	"github.com/ceejimus/kusari/scry"
+)
+
+func NewMemStore() *MemStore {
+	return &MemStore{
+		dirs:      make(map[MemID]*scry.Dir),
+		dirPaths:  make(map[string]MemID),
+		chains:    make(map[MemID]*memChain),
+		dirChains: make(map[MemID][]MemID),
+		inos:      make(map[uint64]MemID),
+		paths:     make(map[pathKey]MemID),
+		removed:   make(map[removedKey]MemID),
+		events:    make(map[MemID]*memEvent),
+		conflicts: make(map[MemID]*memConflict),
+		stats:     make(map[uint64]fnode.StatEntry),
+	}
+}
+
+func (s *MemStore) AddDir(dir *scry.Dir) error {
+	if dir.ID != nil {
+		return errors.New(fmt.Sprintf("Cannot add new dir, non-nil ID %v", dir))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addDir(dir)
+}
+
+func (s *MemStore) AddChain(chain *scry.Chain, dirID scry.ID) error {
+	if chain.ID != nil {
+		return errors.New(fmt.Sprintf("Cannot add new chain, non-nil ID %v", chain))
+	}
+	memID, err := toMemID(dirID.Encode())
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addChain(chain, memID)
+}
+
+func (s *MemStore) AddEvent(event *scry.Event, chainID scry.ID) error {
+	if event.ID != nil {
+		return errors.New(fmt.Sprintf("Cannot add new event, non-nil ID %v", event))
+	}
+	memID, err := toMemID(chainID.Encode())
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addEvent(event, memID)
+}
+
+func (s *MemStore) SetChainIno(chainID scry.ID, ino uint64) error {
+	memID, err := toMemID(chainID.Encode())
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.setChainIno(memID, ino)
+}
+
+func (s *MemStore) GetDirByID(dirID scry.ID) (*scry.Dir, error) {
+	memID, err := toMemID(dirID.Encode())
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	dir, ok := s.dirs[memID]
+	if !ok {
+		return nil, nil
+	}
+	return &scry.Dir{ID: newID(memID), Path: dir.Path}, nil
+}
+
+func (s *MemStore) GetDirByPath(path string) (*scry.Dir, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	memID, ok := s.dirPaths[path]
+	if !ok {
+		return nil, nil
+	}
+	return &scry.Dir{ID: newID(memID), Path: path}, nil
+}
+
+func (s *MemStore) GetChainByID(chainID scry.ID) (*scry.Chain, error) {
+	memID, err := toMemID(chainID.Encode())
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.getChain(memID), nil
+}
+
+func (s *MemStore) GetChainByPath(dirID scry.ID, path string) (*scry.Chain, error) {
+	memID, err := toMemID(dirID.Encode())
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	chainID, found := s.getChainIDByPath(memID, path)
+	if !found {
+		return nil, nil
+	}
+	return s.getChain(chainID), nil
+}
+
+func (s *MemStore) GetRemovedChainByPath(dirID scry.ID, path string) (*scry.Chain, error) {
+	memID, err := toMemID(dirID.Encode())
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	chainID, ok := s.removed[removedKey{memID, path}]
+	if !ok {
+		return nil, nil
+	}
+	return s.getChain(chainID), nil
+}
+
+func (s *MemStore) GetChainByIno(ino uint64) (*scry.Chain, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	chainID, ok := s.inos[ino]
+	if !ok {
+		return nil, nil
+	}
+	return s.getChain(chainID), nil
+}
+
+func (s *MemStore) GetEventByID(eventID scry.ID) (*scry.Event, error) {
+	return s.getEvent(eventID, (*MemStore).getEventByID)
+}
+
+func (s *MemStore) GetPrevEvent(eventID scry.ID) (*scry.Event, error) {
+	return s.getEvent(eventID, (*MemStore).getEventPrev)
+}
+
+func (s *MemStore) GetNextEvent(eventID scry.ID) (*scry.Event, error) {
+	return s.getEvent(eventID, (*MemStore).getEventNext)
+}
+
+func (s *MemStore) GetChainHead(chainID scry.ID) (*scry.Event, error) {
+	return s.getEvent(chainID, (*MemStore).getChainHead)
+}
+
+func (s *MemStore) GetChainTail(chainID scry.ID) (*scry.Event, error) {
+	return s.getEvent(chainID, (*MemStore).getChainTail)
+}
+
+func (s *MemStore) AddConflict(conflict *scry.Conflict, dirID scry.ID) error {
+	if conflict.ID != nil {
+		return errors.New(fmt.Sprintf("Cannot add new conflict, non-nil ID %v", conflict))
+	}
+	memID, err := toMemID(dirID.Encode())
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addConflict(conflict, memID)
+}
+
+func (s *MemStore) GetConflictByID(conflictID scry.ID) (*scry.Conflict, error) {
+	memID, err := toMemID(conflictID.Encode())
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.getConflict(memID), nil
+}
+
+func (s *MemStore) GetConflictsInDir(dirID scry.ID) ([]scry.Conflict, error) {
+	memID, err := toMemID(dirID.Encode())
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	conflicts := make([]scry.Conflict, 0)
+	for _, conflictID := range sortedIDs(s.conflicts) {
+		if s.conflicts[conflictID].dirID == memID {
+			conflicts = append(conflicts, *s.getConflict(conflictID))
+		}
+	}
+	return conflicts, nil
+}
+
+func (s *MemStore) RemoveConflict(conflictID scry.ID) error {
+	memID, err := toMemID(conflictID.Encode())
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.removeConflict(memID)
+}
+
+func (s *MemStore) GetStatEntry(ino uint64) (*fnode.StatEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entry, ok := s.stats[ino]
+	if !ok {
+		return nil, nil
+	}
+	if entry.Chunks != nil {
+		entry.Chunks = append([]fnode.Chunk{}, entry.Chunks...)
+	}
+	return &entry, nil
+}
+
+func (s *MemStore) PutStatEntry(entry *fnode.StatEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	toPut := *entry
+	if toPut.Chunks != nil {
+		toPut.Chunks = append([]fnode.Chunk{}, toPut.Chunks...)
+	}
+	s.stats[entry.Ino] = toPut
+	return nil
+}
+
+func (s *MemStore) RemoveDir(dirID scry.ID) error {
+	memID, err := toMemID(dirID.Encode())
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.removeDir(memID)
+}
+
+func (s *MemStore) PruneChain(chainID scry.ID, before time.Time) (int, error) {
+	memID, err := toMemID(chainID.Encode())
+	if err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pruneChain(memID, before)
+}
+
+func (s *MemStore) GetDirs() ([]scry.Dir, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	dirs := make([]scry.Dir, 0, len(s.dirs))
+	for _, dirID := range sortedIDs(s.dirs) {
+		dirs = append(dirs, scry.Dir{ID: newID(dirID), Path: s.dirs[dirID].Path})
+	}
+	return dirs, nil
+}
+
+func (s *MemStore) GetChainsInDir(dirID scry.ID) ([]scry.Chain, error) {
+	memID, err := toMemID(dirID.Encode())
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	chains := make([]scry.Chain, 0, len(s.dirChains[memID]))
+	for _, chainID := range s.dirChains[memID] {
+		chains = append(chains, *s.getChain(chainID))
+	}
+	return chains, nil
+}
+
+func (s *MemStore) GetEventsInChain(chainID scry.ID) ([]scry.Event, error) {
+	memID, err := toMemID(chainID.Encode())
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	chain, ok := s.chains[memID]
+	if !ok {
+		return make([]scry.Event, 0), nil
+	}
+	events := make([]scry.Event, len(chain.events))
+	for i := range chain.events {
+		events[i] = *s.getEventAt(memID, i)
+	}
+	return events, nil
+}
+
+// getEvent gets a single event w/ the given getter
+func (s *MemStore) getEvent(id scry.ID, get func(*MemStore, MemID) *scry.Event) (*scry.Event, error) {
+	memID, err := toMemID(id.Encode())
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return get(s, memID), nil
+}
+
+// Close does nothing, what's stored is dropped w/ the store
+func (s *MemStore) Close() error {
+	return nil
+}
@@ -0,0 +1,62 @@
+package memstore
+
+import (
+	"path/filepath"
+)
+
+// the parent of the nodes at the top of a dir
+const ROOT_CHAIN_ID = MemID(0)
+
+// getChainIDByPath walks the path lkp one name at a time
+// found is false if there's no chain at path
+func (s *MemStore) getChainIDByPath(dirID MemID, path string) (MemID, bool) {
+	currChainID := ROOT_CHAIN_ID
+	if path == "" { // caller wants root chainID
+		return currChainID, true
+	}
+	for _, pathPart := range splitPath(path) {
+		chainID, found := s.paths[pathKey{dirID, currChainID, pathPart}]
+		if !found {
+			return 0, false
+		}
+		currChainID = chainID
+	}
+	return currChainID, true
+}
+
+func (s *MemStore) addChainPathLkp(dirID MemID, path string, chainID MemID) {
+	dir, name := filepath.Split(path)
+	// a parent that isn't found is the root (like BadgerStore)
+	parentChainID, _ := s.getChainIDByPath(dirID, dir)
+	s.paths[pathKey{dirID, parentChainID, name}] = chainID
+}
+
+func (s *MemStore) moveChainPathLkp(dirID MemID, dstPath string, srcPath string) {
+	dstDir, dstName := filepath.Split(dstPath)
+	srcDir, srcName := filepath.Split(srcPath)
+	dstDirParentChainID, _ := s.getChainIDByPath(dirID, dstDir)
+	srcDirParentChainID, _ := s.getChainIDByPath(dirID, srcDir)
+	// get the src chain ID
+	srcKey := pathKey{dirID, srcDirParentChainID, srcName}
+	chainID, found := s.paths[srcKey]
+	if !found {
+		return
+	}
+	// add chain ID to dst lkp then drop the src
+	s.paths[pathKey{dirID, dstDirParentChainID, dstName}] = chainID
+	delete(s.paths, srcKey)
+}
+
+func (s *MemStore) deleteChainPathLkp(dirID MemID, path string) {
+	dir, name := filepath.Split(path)
+	parentChainID, _ := s.getChainIDByPath(dirID, dir)
+	delete(s.paths, pathKey{dirID, parentChainID, name})
+}
+
+func splitPath(path string) []string {
+	if dir, name := filepath.Split(filepath.Clean(path)); dir == "" {
+		return []string{name}
+	} else {
+		return append(splitPath(dir), name)
+	}
+}
@@ -0,0 +1,369 @@
+package memstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ceejimus/kusari/fnode"
+	"github.com/ceejimus/kusari/scry"
+)
+
+// Everything is kept in maps and lost on Close, objects are copied in and out so callers can't change what's stored.
+
+type MemID uint64
+
+type memChain struct {
+	ID     MemID
+	DirID  MemID
+	Ino    uint64
+	events []MemID // in order
+}
+
+type memEvent struct {
+	event   scry.Event
+	chainID MemID
+	index   int // in the chain's events
+}
+
+type memConflict struct {
+	conflict scry.Conflict
+	dirID    MemID
+}
+
+// a name in the path lkp under the chain of its parent (0 at the top of the dir)
+type pathKey struct {
+	dirID    MemID
+	parentID MemID
+	name     string
+}
+
+// a path where a node was removed
+type removedKey struct {
+	dirID MemID
+	path  string
+}
+
+type MemStore struct {
+	mu        sync.RWMutex
+	lastID    MemID
+	dirs      map[MemID]*scry.Dir
+	dirPaths  map[string]MemID
+	chains    map[MemID]*memChain
+	dirChains map[MemID][]MemID // in order
+	inos      map[uint64]MemID
+	paths     map[pathKey]MemID
+	removed   map[removedKey]MemID
+	events    map[MemID]*memEvent
+	conflicts map[MemID]*memConflict
+	stats     map[uint64]fnode.StatEntry
+}
+
+func (id *MemID) Encode() []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(*id))
+}
+
+func (id *MemID) String() string {
+	return strconv.FormatUint(uint64(*id), 10)
+}
+
+func toMemID(bytes []byte) (MemID, error) {
+	if len(bytes) != 8 {
+		return 0, errors.New(fmt.Sprintf("Failed to convert ID bytes to MemID, bytes must represent uint64: %v", bytes))
+	}
+	id := MemID(binary.BigEndian.Uint64(bytes))
+	if id == 0 {
+		return 0, errors.New(fmt.Sprintln("Failed to convert ID bytes to MemID, ID must be greater than 0"))
+	}
+	return id, nil
+}
+
+func newID(id MemID) *MemID {
+	return &id
+}
+
+// nextID returns a new ID, IDs are never reused
+func (s *MemStore) nextID() MemID {
+	s.lastID++
+	return s.lastID
+}
+
+func (s *MemStore) addDir(dir *scry.Dir) error {
+	if _, ok := s.dirPaths[dir.Path]; ok {
+		return errors.New(fmt.Sprintf("Cannot add Dir, existing dir w/ path %s", dir.Path))
+	}
+	id := s.nextID()
+	s.dirs[id] = &scry.Dir{ID: newID(id), Path: dir.Path}
+	s.dirPaths[dir.Path] = id
+	dir.ID = newID(id)
+	return nil
+}
+
+func (s *MemStore) addChain(chain *scry.Chain, dirID MemID) error {
+	if _, ok := s.dirs[dirID]; !ok {
+		return errors.New(fmt.Sprintf("Cannot add new chain, nonexistent dir w/ id: %v", dirID))
+	}
+	// NOTE: inodes get reused, a new chain for an ino takes over its lkp
+	id := s.nextID()
+	s.chains[id] = &memChain{ID: id, DirID: dirID, Ino: chain.Ino}
+	s.dirChains[dirID] = append(s.dirChains[dirID], id)
+	s.inos[chain.Ino] = id
+	chain.ID = newID(id)
+	return nil
+}
+
+func (s *MemStore) setChainIno(chainID MemID, ino uint64) error {
+	chain, ok := s.chains[chainID]
+	if !ok {
+		return errors.New(fmt.Sprintf("Cannot set ino, nonexistent chain w/ id: %v", chainID))
+	}
+	// only drop the old ino lkp if it's still ours
+	if s.inos[chain.Ino] == chainID {
+		delete(s.inos, chain.Ino)
+	}
+	chain.Ino = ino
+	s.inos[ino] = chainID
+	return nil
+}
+
+func (s *MemStore) addEvent(event *scry.Event, chainID MemID) error {
+	chain, ok := s.chains[chainID]
+	if !ok {
+		return errors.New(fmt.Sprintf("Cannot add new event, nonexistent chain w/ id: %v", chainID))
+	}
+	var tail *scry.Event
+	if len(chain.events) > 0 {
+		tail = &s.events[chain.events[len(chain.events)-1]].event
+	}
+	// update the path lookup depending on event type (this sets the old path of move finalizations)
+	toAdd := copyEvent(*event)
+	toAdd.OldPath = nil
+	s.updateChainLkps(chain, &toAdd, tail)
+
+	id := s.nextID()
+	toAdd.ID = newID(id)
+	s.events[id] = &memEvent{event: toAdd, chainID: chainID, index: len(chain.events)}
+	chain.events = append(chain.events, id)
+	// set the ID and old path
+	event.ID = newID(id)
+	if toAdd.OldPath != nil {
+		oldPath := *toAdd.OldPath
+		event.OldPath = &oldPath
+	}
+	return nil
+}
+
+func (s *MemStore) updateChainLkps(chain *memChain, event *scry.Event, tail *scry.Event) {
+	if event.Type == scry.Create && tail != nil && tail.Type == scry.Rename {
+		// set old path for move finalizations
+		oldPath := tail.Path
+		event.OldPath = &oldPath
+		s.moveChainPathLkp(chain.DirID, event.Path, tail.Path)
+	} else if event.Type == scry.Remove {
+		s.deleteChainPathLkp(chain.DirID, event.Path)
+		delete(s.inos, chain.Ino)
+		s.removed[removedKey{chain.DirID, event.Path}] = chain.ID
+	} else if _, found := s.getChainIDByPath(chain.DirID, event.Path); !found {
+		s.addChainPathLkp(chain.DirID, event.Path, chain.ID)
+	}
+}
+
+func (s *MemStore) addConflict(conflict *scry.Conflict, dirID MemID) error {
+	if _, ok := s.dirs[dirID]; !ok {
+		return errors.New(fmt.Sprintf("Cannot add new conflict, nonexistent dir w/ id: %v", dirID))
+	}
+	chainID, err := toMemID(conflict.ChainID.Encode())
+	if err != nil {
+		return err
+	}
+	id := s.nextID()
+	// conflict events are kept w/o their IDs
+	toAdd := scry.Conflict{
+		ID:       newID(id),
+		ChainID:  newID(chainID),
+		Path:     conflict.Path,
+		Local:    copyEvent(conflict.Local),
+		Remote:   copyEvent(conflict.Remote),
+		Detected: conflict.Detected,
+	}
+	toAdd.Local.ID = nil
+	toAdd.Remote.ID = nil
+	s.conflicts[id] = &memConflict{conflict: toAdd, dirID: dirID}
+	conflict.ID = newID(id)
+	return nil
+}
+
+func (s *MemStore) removeConflict(conflictID MemID) error {
+	if _, ok := s.conflicts[conflictID]; !ok {
+		return errors.New(fmt.Sprintf("Cannot remove conflict, nonexistent conflict w/ id: %v", conflictID))
+	}
+	delete(s.conflicts, conflictID)
+	return nil
+}
+
+func (s *MemStore) removeDir(dirID MemID) error {
+	dir, ok := s.dirs[dirID]
+	if !ok {
+		return errors.New(fmt.Sprintf("Cannot remove dir, nonexistent dir w/ id: %v", dirID))
+	}
+	for _, chainID := range s.dirChains[dirID] {
+		chain := s.chains[chainID]
+		for _, eventID := range chain.events {
+			delete(s.events, eventID)
+		}
+		// the ino may belong to a newer chain (in another dir)
+		if s.inos[chain.Ino] == chainID {
+			delete(s.inos, chain.Ino)
+		}
+		delete(s.chains, chainID)
+	}
+	for key := range s.paths {
+		if key.dirID == dirID {
+			delete(s.paths, key)
+		}
+	}
+	for key := range s.removed {
+		if key.dirID == dirID {
+			delete(s.removed, key)
+		}
+	}
+	for conflictID, conflict := range s.conflicts {
+		if conflict.dirID == dirID {
+			delete(s.conflicts, conflictID)
+		}
+	}
+	delete(s.dirChains, dirID)
+	delete(s.dirPaths, dir.Path)
+	delete(s.dirs, dirID)
+	return nil
+}
+
+func (s *MemStore) pruneChain(chainID MemID, before time.Time) (int, error) {
+	chain, ok := s.chains[chainID]
+	if !ok {
+		return 0, errors.New(fmt.Sprintf("Cannot prune chain, nonexistent chain w/ id: %v", chainID))
+	}
+	events := make([]scry.Event, len(chain.events))
+	for i, eventID := range chain.events {
+		events[i] = s.events[eventID].event
+	}
+	// drop what isn't kept and re-index the rest
+	kept := make([]MemID, 0, len(chain.events))
+	for i, keep := range scry.KeepAfter(events, before) {
+		eventID := chain.events[i]
+		if !keep {
+			delete(s.events, eventID)
+			continue
+		}
+		s.events[eventID].index = len(kept)
+		kept = append(kept, eventID)
+	}
+	dropped := len(chain.events) - len(kept)
+	chain.events = kept
+	return dropped, nil
+}
+
+func (s *MemStore) getChain(chainID MemID) *scry.Chain {
+	chain, ok := s.chains[chainID]
+	if !ok {
+		return nil
+	}
+	return &scry.Chain{ID: newID(chain.ID), Ino: chain.Ino}
+}
+
+// getEventAt gets a copy of the event at index in a chain, nil if there's none
+func (s *MemStore) getEventAt(chainID MemID, index int) *scry.Event {
+	chain, ok := s.chains[chainID]
+	if !ok || index < 0 || index >= len(chain.events) {
+		return nil
+	}
+	event := copyEvent(s.events[chain.events[index]].event)
+	return &event
+}
+
+func (s *MemStore) getEventByID(eventID MemID) *scry.Event {
+	memEvent, ok := s.events[eventID]
+	if !ok {
+		return nil
+	}
+	return s.getEventAt(memEvent.chainID, memEvent.index)
+}
+
+func (s *MemStore) getEventPrev(eventID MemID) *scry.Event {
+	memEvent, ok := s.events[eventID]
+	if !ok {
+		return nil
+	}
+	return s.getEventAt(memEvent.chainID, memEvent.index-1)
+}
+
+func (s *MemStore) getEventNext(eventID MemID) *scry.Event {
+	memEvent, ok := s.events[eventID]
+	if !ok {
+		return nil
+	}
+	return s.getEventAt(memEvent.chainID, memEvent.index+1)
+}
+
+func (s *MemStore) getChainHead(chainID MemID) *scry.Event {
+	return s.getEventAt(chainID, 0)
+}
+
+func (s *MemStore) getChainTail(chainID MemID) *scry.Event {
+	chain, ok := s.chains[chainID]
+	if !ok {
+		return nil
+	}
+	return s.getEventAt(chainID, len(chain.events)-1)
+}
+
+func (s *MemStore) getConflict(conflictID MemID) *scry.Conflict {
+	memConflict, ok := s.conflicts[conflictID]
+	if !ok {
+		return nil
+	}
+	conflict := memConflict.conflict
+	conflict.ID = newID(conflictID)
+	conflict.ChainID = newID(*conflict.ChainID.(*MemID))
+	conflict.Local = copyEvent(conflict.Local)
+	conflict.Remote = copyEvent(conflict.Remote)
+	return &conflict
+}
+
+// sortedIDs returns the keys of a map of objects by ID in the order they were added
+func sortedIDs[T any](objects map[MemID]T) []MemID {
+	ids := make([]MemID, 0, len(objects))
+	for id := range objects {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// copyEvent copies an event and what it points to
+func copyEvent(event scry.Event) scry.Event {
+	if event.ID != nil {
+		if id, ok := event.ID.(*MemID); ok {
+			event.ID = newID(*id)
+		}
+	}
+	if event.OldPath != nil {
+		oldPath := *event.OldPath
+		event.OldPath = &oldPath
+	}
+	if event.Hash != nil {
+		hash := *event.Hash
+		event.Hash = &hash
+	}
+	if event.Chunks != nil {
+		event.Chunks = append([]fnode.Chunk{}, event.Chunks...)
+	}
+	if event.Version != nil {
+		event.Version = event.Version.Copy()
+	}
+	return event
+}
@@ -0,0 +1,386 @@
+package peer
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ceejimus/kusari/fnode"
+	"github.com/ceejimus/kusari/identity"
+	"github.com/ceejimus/kusari/logger"
+	"github.com/ceejimus/kusari/scry"
+)
+
+// what's kept between the requests on a connection
+type session struct {
+	liveFiles map[string][]string // full paths of the live files by hash (nil until content is read from them)
+}
+
+// Node serves the local event store to peers and syncs from them
+type Node struct {
+	topDir   string
+	scryDirs []scry.ScriedDirectory // only these dirs are served and synced
+	store    scry.EventStore
+	opts     scry.Options
+	tls      *tls.Config
+	listener net.Listener
+	connmu   sync.Mutex
+	conns    map[net.Conn]struct{}
+	wg       sync.WaitGroup
+	syncmu   sync.Mutex
+	applier  *scry.Applier
+}
+
+// w/o a blob store (opts.Blobs) content is only served from the files themselves
+// changes from peers are made w/ opts.Applier (share it w/ the scryer so it doesn't store them twice)
+// peers connect over TLS w/ their node keys, only peers w/ trusted keys are served and synced from
+// stored dirs that aren't in scryDirs (see scry.ReconcileDirs) aren't served or synced
+func NewNode(topDir string, scryDirs []scry.ScriedDirectory, store scry.EventStore, opts scry.Options, id *identity.Identity, trusted identity.TrustedKeys) (*Node, error) {
+	tlsConfig, err := tlsConfig(id, trusted)
+	if err != nil {
+		return nil, err
+	}
+	applier := opts.Applier
+	if applier == nil {
+		applier = scry.NewApplier()
+	}
+	return &Node{
+		topDir:   topDir,
+		scryDirs: scryDirs,
+		store:    store,
+		opts:     opts,
+		tls:      tlsConfig,
+		conns:    make(map[net.Conn]struct{}),
+		applier:  applier,
+	}, nil
+}
+
+// Listen binds the node to a TCP address, call Serve to accept peers
+func (n *Node) Listen(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Failed to listen on %q:\n%s", addr, err.Error()))
+	}
+	n.listener = listener
+	logger.Info(fmt.Sprintf("Listening for peers on %s", listener.Addr()))
+	return nil
+}
+
+// Addr returns the address the node is listening on (nil if it isn't)
+func (n *Node) Addr() net.Addr {
+	if n.listener == nil {
+		return nil
+	}
+	return n.listener.Addr()
+}
+
+// Serve accepts peer connections until the node is closed
+func (n *Node) Serve() {
+	for {
+		c, err := n.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logger.Error(fmt.Sprintf("Failed to accept peer connection:\n%s", err.Error()))
+			continue
+		}
+		n.connmu.Lock()
+		n.conns[c] = struct{}{}
+		n.connmu.Unlock()
+		n.wg.Add(1)
+		go n.handle(tls.Server(c, n.tls))
+	}
+}
+
+// Close stops listening and waits for open connections to finish
+func (n *Node) Close() error {
+	var err error
+	if n.listener != nil {
+		err = n.listener.Close()
+	}
+	n.connmu.Lock()
+	for c := range n.conns {
+		c.Close()
+	}
+	n.connmu.Unlock()
+	n.wg.Wait()
+	return err
+}
+
+// handle answers requests on a connection until the peer hangs up
+func (n *Node) handle(tlsConn *tls.Conn) {
+	defer func() {
+		n.connmu.Lock()
+		delete(n.conns, tlsConn.NetConn())
+		n.connmu.Unlock()
+		tlsConn.Close()
+		n.wg.Done()
+	}()
+	peerID, err := handshake(tlsConn)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Failed to authenticate peer %s:\n%s", tlsConn.RemoteAddr(), err.Error()))
+		return
+	}
+	logger.Debug(fmt.Sprintf("Peer %s connected from %s", peerID, tlsConn.RemoteAddr()))
+	c := newConn(tlsConn)
+	sess := &session{}
+	for {
+		var req Request
+		if err := c.dec.Decode(&req); err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				logger.Warn(fmt.Sprintf("Failed to read request from %s:\n%s", c.RemoteAddr(), err.Error()))
+			}
+			return
+		}
+		logger.Trace(fmt.Sprintf("Received %s request from %s", req.Type, c.RemoteAddr()))
+		res, err := n.respond(sess, &req)
+		if err != nil {
+			logger.Warn(fmt.Sprintf("Failed %s request from %s:\n%s", req.Type, c.RemoteAddr(), err.Error()))
+			res = &Response{Error: err.Error()}
+		}
+		if err = c.enc.Encode(res); err != nil {
+			logger.Warn(fmt.Sprintf("Failed to send response to %s:\n%s", c.RemoteAddr(), err.Error()))
+			return
+		}
+	}
+}
+
+func (n *Node) respond(sess *session, req *Request) (*Response, error) {
+	switch req.Type {
+	case GET_DIRS:
+		return n.getDirs()
+	case GET_SUMMARY:
+		return n.getSummary(req.DirPath)
+	case GET_EVENTS:
+		return n.getEvents(req.DirPath, req.Path)
+	case GET_CONTENT:
+		return n.getContent(sess, req.Hash)
+	case GET_CHUNKS:
+		return n.getChunks(sess, req.Hash, req.Chunks)
+	default:
+		return nil, errors.New(fmt.Sprintf("Unsupported request type: %s", req.Type))
+	}
+}
+
+func (n *Node) getDirs() (*Response, error) {
+	dirs, err := scry.GetScriedDirs(n.store, n.scryDirs)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, len(dirs))
+	for i, dir := range dirs {
+		paths[i] = dir.Path
+	}
+	return &Response{Dirs: paths}, nil
+}
+
+func (n *Node) getSummary(dirPath string) (*Response, error) {
+	dir, err := n.lkpDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	states, err := scry.GetChainStates(n.store, dir.ID, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	summary := make([]ChainSummary, 0, len(states))
+	for _, state := range states {
+		// nodes mid-move aren't anywhere yet
+		if state.Moved && !state.Removed {
+			continue
+		}
+		summary = append(summary, toSummary(state))
+	}
+	return &Response{Summary: summary}, nil
+}
+
+func (n *Node) getEvents(dirPath string, path string) (*Response, error) {
+	dir, err := n.lkpDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := n.chainAt(dir, path)
+	if err != nil {
+		return nil, err
+	}
+	if chain == nil {
+		return nil, errors.New(fmt.Sprintf("No chain w/ path %q in dir %q", path, dirPath))
+	}
+	events, err := n.store.GetEventsInChain(chain.ID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range events {
+		events[i].ID = nil // IDs are local to the store
+	}
+	return &Response{Events: events}, nil
+}
+
+// chainAt finds the chain of the node at path, or of the node last removed there
+// nodes mid-move aren't anywhere (like in the summary)
+func (n *Node) chainAt(dir *scry.Dir, path string) (*scry.Chain, error) {
+	chain, err := n.store.GetChainByPath(dir.ID, path)
+	if chain == nil || err != nil {
+		return n.store.GetRemovedChainByPath(dir.ID, path)
+	}
+	tail, err := n.store.GetChainTail(chain.ID)
+	if err != nil {
+		return nil, err
+	}
+	if tail != nil && tail.Type == scry.Rename {
+		return n.store.GetRemovedChainByPath(dir.ID, path)
+	}
+	return chain, nil
+}
+
+// getContent reads the content w/ the requested hash
+func (n *Node) getContent(sess *session, hash string) (*Response, error) {
+	content, err := n.readContent(sess, hash)
+	if err != nil {
+		return nil, err
+	}
+	return &Response{Content: content}, nil
+}
+
+// getChunks reads the requested chunks of the content w/ the given hash
+// chunks are read from the blob store if it has them, otherwise they're cut from the whole content
+func (n *Node) getChunks(sess *session, hash string, wanted []string) (*Response, error) {
+	if len(wanted) > MAX_CHUNKS_PER_REQUEST {
+		return nil, errors.New(fmt.Sprintf("Too many chunks requested (%d > %d)", len(wanted), MAX_CHUNKS_PER_REQUEST))
+	}
+	found := make(map[string][]byte, len(wanted))
+	missing := 0
+	for _, chunkHash := range wanted {
+		chunk, err := n.readBlob(chunkHash)
+		if err != nil {
+			return nil, err
+		}
+		if chunk == nil {
+			missing++
+			continue
+		}
+		found[chunkHash] = chunk
+	}
+	if missing > 0 {
+		content, err := n.readContent(sess, hash)
+		if err != nil {
+			return nil, err
+		}
+		if err = fnode.SplitChunks(bytes.NewReader(content), func(data []byte) error {
+			chunkHash, err := fnode.GetHash(bytes.NewReader(data))
+			if err != nil {
+				return err
+			}
+			if _, ok := found[chunkHash]; !ok {
+				found[chunkHash] = append([]byte{}, data...) // data is only valid in the callback
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	chunks := make([][]byte, len(wanted))
+	for i, chunkHash := range wanted {
+		chunk, ok := found[chunkHash]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("No chunk w/ hash %q in %q", chunkHash, hash))
+		}
+		chunks[i] = chunk
+	}
+	return &Response{Chunks: chunks}, nil
+}
+
+// readBlob reads the content w/ the given hash from the blob store, nil if we don't have one or it doesn't have it
+func (n *Node) readBlob(hash string) ([]byte, error) {
+	if n.opts.Blobs == nil {
+		return nil, nil
+	}
+	blob, err := n.opts.Blobs.Open(hash)
+	if blob == nil || err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+	return io.ReadAll(blob)
+}
+
+// readContent reads the content w/ the given hash
+// from the blob store if we have one, otherwise from a live file w/ that hash
+// the live files are looked up once per connection
+func (n *Node) readContent(sess *session, hash string) ([]byte, error) {
+	content, err := n.readBlob(hash)
+	if content != nil || err != nil {
+		return content, err
+	}
+	if sess.liveFiles == nil {
+		if sess.liveFiles, err = n.getLiveFiles(); err != nil {
+			return nil, err
+		}
+	}
+	for _, path := range sess.liveFiles[hash] {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		// the file may have changed since we last saw it
+		if ok, err := fnode.CheckHash(hash, bytes.NewReader(content)); err != nil || !ok {
+			continue
+		}
+		return content, nil
+	}
+	return nil, errors.New(fmt.Sprintf("No content w/ hash %q", hash))
+}
+
+// getLiveFiles returns the full paths of the live files in the scried dirs by hash
+func (n *Node) getLiveFiles() (map[string][]string, error) {
+	dirs, err := scry.GetScriedDirs(n.store, n.scryDirs)
+	if err != nil {
+		return nil, err
+	}
+	liveFiles := make(map[string][]string)
+	for _, dir := range dirs {
+		states, err := scry.GetChainStates(n.store, dir.ID, time.Time{})
+		if err != nil {
+			return nil, err
+		}
+		for _, state := range states {
+			if state.Removed || state.Moved || state.State == nil || state.State.Hash == nil {
+				continue
+			}
+			hash := *state.State.Hash
+			liveFiles[hash] = append(liveFiles[hash], filepath.Join(n.topDir, dir.Path, state.Path))
+		}
+	}
+	return liveFiles, nil
+}
+
+func (n *Node) lkpDir(dirPath string) (*scry.Dir, error) {
+	if !n.scries(dirPath) {
+		return nil, errors.New(fmt.Sprintf("No dir w/ path %q", dirPath))
+	}
+	dir, err := n.store.GetDirByPath(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	if dir == nil {
+		return nil, errors.New(fmt.Sprintf("No dir w/ path %q", dirPath))
+	}
+	return dir, nil
+}
+
+// scries tells if a dir is one of the configured dirs
+func (n *Node) scries(dirPath string) bool {
+	for _, scryDir := range n.scryDirs {
+		if scryDir.Path == dirPath {
+			return true
+		}
+	}
+	return false
+}
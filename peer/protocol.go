@@ -0,0 +1,159 @@
+// Peer protocol
+//
+// Nodes talk to each other over TLS using gob encoded Request/Response pairs.
+// Both ends authenticate w/ their node keys (see tlsConfig).
+// A connection carries any number of requests, each answered by exactly one response.
+// The protocol is pull based: a node asks a peer for the summary of a Dir,
+// compares it to its own, then asks for the Events and content it's missing.
+// Content of chunked files (see fnode.Chunk) is fetched chunk by chunk, only the chunks
+// the node doesn't already have (in its blob store or the file being replaced) are sent.
+
+package peer
+
+import (
+	"encoding/gob"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/ceejimus/kusari/fnode"
+	"github.com/ceejimus/kusari/scry"
+)
+
+type RequestType uint32
+
+const (
+	// list the paths of the peer's Dirs
+	GET_DIRS RequestType = iota + 1
+	// get the summary of every chain in a Dir
+	GET_SUMMARY
+	// get the events in the chain at a path
+	GET_EVENTS
+	// get the content of a file by hash
+	GET_CONTENT
+	// get some of the chunks of a file's content
+	GET_CHUNKS
+)
+
+// the most chunks asked for in a single GET_CHUNKS request
+const MAX_CHUNKS_PER_REQUEST = 64
+
+type Request struct {
+	Type    RequestType
+	DirPath string   // the Dir (by path) the request is for
+	Path    string   // node path relative to the Dir (GET_EVENTS)
+	Hash    string   // content hash (GET_CONTENT, GET_CHUNKS)
+	Chunks  []string // hashes of the chunks wanted (GET_CHUNKS)
+}
+
+type Response struct {
+	Error   string         // set if the request failed
+	Dirs    []string       // GET_DIRS
+	Summary []ChainSummary // GET_SUMMARY
+	Events  []scry.Event   // GET_EVENTS (w/o IDs, those are local to a store)
+	Content []byte         // GET_CONTENT
+	Chunks  [][]byte       // GET_CHUNKS (in the order they were asked for)
+}
+
+// the state of a chain as seen by a peer
+// chains are matched between peers by path since IDs and inodes are local
+type ChainSummary struct {
+	Path      string             // current path of the node (last path for removed nodes)
+	PrevPath  *string            // path of the node before it was last moved
+	Removed   bool               // the node was removed
+	Timestamp time.Time          // timestamp of the most recent event in the chain
+	Origin    string             // node the most recent event happened on
+	Version   scry.VersionVector // version of the most recent event in the chain
+	Hash      *string            // file hash (nil for dirs)
+	Size      uint64             // file size
+	ModTime   time.Time          // modification time
+	Mode      os.FileMode        // permission bits (0 if unknown)
+	Uid       uint32             // owner
+	Gid       uint32             // group
+	Target    string             // where a symlink points ("" for other nodes)
+	Chunks    []fnode.Chunk      // the chunks of a file's content (nil if it isn't chunked)
+}
+
+func (t RequestType) String() string {
+	switch t {
+	case GET_DIRS:
+		return "get-dirs"
+	case GET_SUMMARY:
+		return "get-summary"
+	case GET_EVENTS:
+		return "get-events"
+	case GET_CONTENT:
+		return "get-content"
+	case GET_CHUNKS:
+		return "get-chunks"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint32(t))
+	}
+}
+
+func (s ChainSummary) IsDir() bool {
+	return s.Hash == nil && s.Target == ""
+}
+
+func (s ChainSummary) IsLink() bool {
+	return s.Target != ""
+}
+
+// a gob encoded connection
+type conn struct {
+	net.Conn
+	enc *gob.Encoder
+	dec *gob.Decoder
+}
+
+func newConn(c net.Conn) *conn {
+	return &conn{Conn: c, enc: gob.NewEncoder(c), dec: gob.NewDecoder(c)}
+}
+
+// toEvent makes the event a summary describes
+// (the most recent event w/ the node's state)
+func (s ChainSummary) toEvent() scry.Event {
+	event := scry.Event{
+		Timestamp: s.Timestamp,
+		Path:      s.Path,
+		Type:      scry.Write,
+		Hash:      s.Hash,
+		Size:      s.Size,
+		ModTime:   s.ModTime,
+		Mode:      s.Mode,
+		Uid:       s.Uid,
+		Gid:       s.Gid,
+		Target:    s.Target,
+		Chunks:    s.Chunks,
+		Origin:    s.Origin,
+		Version:   s.Version,
+	}
+	if s.Removed {
+		event.Type = scry.Remove
+	}
+	return event
+}
+
+// toSummary strips the local bits (IDs, inodes) from a chain state
+func toSummary(state scry.ChainState) ChainSummary {
+	summary := ChainSummary{
+		Path:      state.Path,
+		PrevPath:  state.PrevPath,
+		Removed:   state.Removed,
+		Timestamp: state.Timestamp,
+		Origin:    state.Origin,
+		Version:   state.Version,
+	}
+	if state.State != nil {
+		summary.Hash = state.State.Hash
+		summary.Size = state.State.Size
+		summary.ModTime = state.State.ModTime
+		summary.Mode = state.State.Mode
+		summary.Uid = state.State.Uid
+		summary.Gid = state.State.Gid
+		summary.Target = state.State.Target
+		summary.Chunks = state.State.Chunks
+	}
+	return summary
+}
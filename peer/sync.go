@@ -0,0 +1,643 @@
+package peer
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ceejimus/kusari/fnode"
+	"github.com/ceejimus/kusari/logger"
+	"github.com/ceejimus/kusari/scry"
+)
+
+const DIAL_TIMEOUT = 10 * time.Second
+
+// SyncWith pulls changes from the peer at addr for every Dir both nodes scry
+//
+// the chains of each Dir are matched by path and compared by version
+// changes the peer has that we don't are applied to the filesystem and the peer's events
+// are appended to the local chains (new chains are created for nodes we didn't have)
+// concurrent changes to the same node are recorded as conflicts and left alone
+func (n *Node) SyncWith(addr string) error {
+	n.syncmu.Lock()
+	defer n.syncmu.Unlock()
+
+	c, err := net.DialTimeout("tcp", addr, DIAL_TIMEOUT)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Failed to connect to peer %q:\n%s", addr, err.Error()))
+	}
+	tlsConn := tls.Client(c, n.tls)
+	defer tlsConn.Close()
+	peerID, err := handshake(tlsConn)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Failed to authenticate peer %q:\n%s", addr, err.Error()))
+	}
+	peer := newConn(tlsConn)
+	logger.Debug(fmt.Sprintf("Syncing w/ peer %s @ %s", peerID, addr))
+
+	res, err := peer.request(&Request{Type: GET_DIRS})
+	if err != nil {
+		return err
+	}
+	for _, dirPath := range res.Dirs {
+		if !n.scries(dirPath) { // not one of ours (or it's inactive)
+			logger.Debug(fmt.Sprintf("Skipping dir %q from %s, we don't scry it", dirPath, addr))
+			continue
+		}
+		dir, err := n.lkpDir(dirPath)
+		if err != nil {
+			return err
+		}
+		if err = n.syncDir(peer, dir); err != nil {
+			return errors.New(fmt.Sprintf("Failed to sync dir %q w/ %s:\n%s", dirPath, addr, err.Error()))
+		}
+	}
+	return nil
+}
+
+// request sends a request to the peer and waits for the response
+func (c *conn) request(req *Request) (*Response, error) {
+	if err := c.enc.Encode(req); err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to send %s request:\n%s", req.Type, err.Error()))
+	}
+	var res Response
+	if err := c.dec.Decode(&res); err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to read %s response:\n%s", req.Type, err.Error()))
+	}
+	if res.Error != "" {
+		return nil, errors.New(fmt.Sprintf("Peer failed %s request: %s", req.Type, res.Error))
+	}
+	return &res, nil
+}
+
+// syncDir brings a local Dir in line w/ the peer's copy
+// removals happen deepest first, then moves, creates and writes shallowest first
+// so parents exist before their children
+func (n *Node) syncDir(peer *conn, dir *scry.Dir) error {
+	res, err := peer.request(&Request{Type: GET_SUMMARY, DirPath: dir.Path})
+	if err != nil {
+		return err
+	}
+	states, err := scry.GetChainStates(n.store, dir.ID, time.Time{})
+	if err != nil {
+		return err
+	}
+	// the nodes we have (or had), by path
+	local := make(map[string]*scry.ChainState, len(states))
+	for i := range states {
+		state := &states[i]
+		if state.Moved && !state.Removed {
+			continue
+		}
+		if other, ok := local[state.Path]; ok && prefer(other.Removed, other.Timestamp, state.Removed, state.Timestamp) {
+			continue
+		}
+		local[state.Path] = state
+	}
+	// the nodes the peer has (or had), by path
+	remote := make(map[string]*ChainSummary, len(res.Summary))
+	for i := range res.Summary {
+		summary := &res.Summary[i]
+		if !isLocalSummary(summary) {
+			logger.Warn(fmt.Sprintf("Not syncing %q in %q, the peer sent a path outside the dir", summary.Path, dir.Path))
+			continue
+		}
+		if other, ok := remote[summary.Path]; ok && prefer(other.Removed, other.Timestamp, summary.Removed, summary.Timestamp) {
+			continue
+		}
+		remote[summary.Path] = summary
+	}
+	toRemove := make([]*ChainSummary, 0)
+	toApply := make([]*ChainSummary, 0, len(remote))
+	for _, summary := range remote {
+		if summary.Removed {
+			toRemove = append(toRemove, summary)
+		} else {
+			toApply = append(toApply, summary)
+		}
+	}
+
+	// removals - deepest first
+	sort.SliceStable(toRemove, func(i, j int) bool {
+		return pathDepth(toRemove[i].Path) > pathDepth(toRemove[j].Path)
+	})
+	for _, summary := range toRemove {
+		state := local[summary.Path]
+		if state == nil || state.Removed {
+			continue
+		}
+		switch state.Version.Compare(summary.Version) {
+		case scry.VersionBefore:
+			logger.Debug(fmt.Sprintf("Sync remove %q", summary.Path))
+			path, err := n.writablePath(dir, summary.Path)
+			if err != nil {
+				logger.Warn(fmt.Sprintf("Failed to remove %q:\n%s", summary.Path, err.Error()))
+				continue
+			}
+			// never remove anything the peer doesn't know about (e.g. a non-empty dir)
+			if err = n.applier.Remove(path); err != nil && !os.IsNotExist(err) {
+				logger.Warn(fmt.Sprintf("Failed to remove %q:\n%s", summary.Path, err.Error()))
+				continue
+			}
+			if err = n.appendEvents(peer, dir, state.Chain.ID, summary.Path, state.Version); err != nil {
+				return err
+			}
+			state.Removed = true
+		case scry.VersionConcurrent:
+			if err = n.addConflict(peer, dir, state, summary); err != nil {
+				return err
+			}
+		}
+	}
+
+	// moves, creates and writes - shallowest first, moves before anything else at a depth
+	sort.SliceStable(toApply, func(i, j int) bool {
+		di, dj := pathDepth(toApply[i].Path), pathDepth(toApply[j].Path)
+		if di != dj {
+			return di < dj
+		}
+		return toApply[i].PrevPath != nil && toApply[j].PrevPath == nil
+	})
+	for _, summary := range toApply {
+		if err = n.applySummary(peer, dir, local, summary); err != nil {
+			return err
+		}
+	}
+
+	return n.clearConflicts(dir)
+}
+
+// clearConflicts removes conflicts that were resolved elsewhere
+// i.e. the local chain has caught up to (and past) the remote side of the conflict
+func (n *Node) clearConflicts(dir *scry.Dir) error {
+	conflicts, err := n.store.GetConflictsInDir(dir.ID)
+	if err != nil {
+		return err
+	}
+	for _, conflict := range conflicts {
+		tail, err := n.store.GetChainTail(conflict.ChainID)
+		if err != nil {
+			return err
+		}
+		if tail == nil || !tail.Version.Dominates(conflict.Remote.Version) {
+			continue
+		}
+		logger.Info(fmt.Sprintf("Conflict for %q was resolved by a peer", conflict.Path))
+		if err = n.store.RemoveConflict(conflict.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applySummary applies a single live node from the peer
+func (n *Node) applySummary(peer *conn, dir *scry.Dir, local map[string]*scry.ChainState, summary *ChainSummary) error {
+	state := local[summary.Path]
+	// the peer moved a node we still have at its old path
+	if (state == nil || state.Removed) && summary.PrevPath != nil {
+		prev := local[*summary.PrevPath]
+		if prev != nil && !prev.Removed && isDir(prev) == summary.IsDir() {
+			switch prev.Version.Compare(summary.Version) {
+			case scry.VersionBefore:
+				logger.Debug(fmt.Sprintf("Sync move %q -> %q", prev.Path, summary.Path))
+				oldPath, err := n.writablePath(dir, prev.Path)
+				if err != nil {
+					logger.Warn(err.Error())
+					return nil
+				}
+				newPath, err := n.writablePath(dir, summary.Path)
+				if err != nil {
+					logger.Warn(err.Error())
+					return nil
+				}
+				if err = n.applier.Rename(oldPath, newPath); err != nil {
+					logger.Warn(err.Error())
+					return nil
+				}
+				movePrefix(local, prev.Path, summary.Path)
+				state = prev
+			case scry.VersionConcurrent:
+				return n.addConflict(peer, dir, prev, summary)
+			}
+		}
+	}
+	// the peer has a node we don't
+	if state == nil {
+		return n.createNode(peer, dir, summary)
+	}
+	order := state.Version.Compare(summary.Version)
+	if order == scry.VersionConcurrent {
+		if !state.Removed && sameContent(state, summary) {
+			return n.addMerge(dir, state, summary)
+		}
+		return n.addConflict(peer, dir, state, summary)
+	}
+	if order != scry.VersionBefore { // we've seen the peer's changes
+		return nil
+	}
+	// the peer re-created a node we removed
+	if state.Removed {
+		return n.createNode(peer, dir, summary)
+	}
+	if isDir(state) != summary.IsDir() {
+		logger.Warn(fmt.Sprintf("Not syncing %q, it's a dir on one node and a file on the other", summary.Path))
+		return nil
+	}
+	path, err := n.writablePath(dir, summary.Path)
+	if err != nil {
+		logger.Warn(err.Error())
+		return nil
+	}
+	// links can't be written in place, the node is replaced (and gets a new chain)
+	if (isLink(state) || summary.IsLink()) && !sameContent(state, summary) {
+		logger.Debug(fmt.Sprintf("Sync replace %q", summary.Path))
+		if isLink(state) {
+			if err := n.applier.Remove(path); err != nil && !os.IsNotExist(err) {
+				logger.Warn(fmt.Sprintf("Failed to remove %q:\n%s", summary.Path, err.Error()))
+				return nil
+			}
+		}
+		return n.createNode(peer, dir, summary)
+	}
+	if !sameContent(state, summary) {
+		logger.Debug(fmt.Sprintf("Sync write %q", summary.Path))
+		if err := n.writeFile(peer, dir, summary); err != nil {
+			logger.Warn(err.Error())
+			return nil
+		}
+		// the file was replaced, keep its chain
+		if err := n.relinkChain(&state.Chain, path); err != nil {
+			return err
+		}
+	} else if !sameMeta(state, summary) {
+		logger.Debug(fmt.Sprintf("Sync chmod %q", summary.Path))
+		if err := n.applier.SetMeta(path, summary.Mode, summary.Uid, summary.Gid); err != nil {
+			logger.Warn(err.Error())
+			return nil
+		}
+	}
+	return n.appendEvents(peer, dir, state.Chain.ID, summary.Path, state.Version)
+}
+
+// createNode creates a node the peer has and a new chain for it
+func (n *Node) createNode(peer *conn, dir *scry.Dir, summary *ChainSummary) error {
+	logger.Debug(fmt.Sprintf("Sync create %q", summary.Path))
+	path, err := n.writablePath(dir, summary.Path)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Failed to create %q:\n%s", summary.Path, err.Error()))
+		return nil
+	}
+	if summary.IsDir() || summary.IsLink() {
+		if summary.IsDir() {
+			err = n.applier.Mkdir(path, fnode.NodeState{Mode: summary.Mode, Uid: summary.Uid, Gid: summary.Gid})
+		} else if err = n.applier.WriteLink(path, summary.Target); err == nil {
+			err = n.applier.SetMeta(path, summary.Mode, summary.Uid, summary.Gid)
+		}
+		if err != nil {
+			logger.Warn(fmt.Sprintf("Failed to create %q:\n%s", summary.Path, err.Error()))
+			return nil
+		}
+	} else if err := n.writeFile(peer, dir, summary); err != nil {
+		logger.Warn(err.Error())
+		return nil
+	}
+	node, err := fnode.NewNode(path)
+	if err != nil {
+		return err
+	}
+	chain := &scry.Chain{Ino: node.Ino}
+	if err = n.store.AddChain(chain, dir.ID); err != nil {
+		return err
+	}
+	return n.appendEvents(peer, dir, chain.ID, summary.Path, nil)
+}
+
+// addMerge records that we and the peer ended up w/ the same node independently
+// the merge event's version includes both so neither side sees a conflict again
+func (n *Node) addMerge(dir *scry.Dir, state *scry.ChainState, summary *ChainSummary) error {
+	logger.Debug(fmt.Sprintf("Sync merge %q", summary.Path))
+	event := &scry.Event{
+		Timestamp: time.Now(),
+		Path:      state.Path,
+		Type:      scry.Write,
+		Origin:    n.opts.NodeID,
+		Version:   state.Version.Merge(summary.Version).Bump(n.opts.NodeID),
+	}
+	if state.State != nil {
+		event.Size = state.State.Size
+		event.Hash = state.State.Hash
+		event.ModTime = state.State.ModTime
+		event.Mode = state.State.Mode
+		event.Uid = state.State.Uid
+		event.Gid = state.State.Gid
+		event.Target = state.State.Target
+		event.Chunks = state.State.Chunks
+	}
+	return n.store.AddEvent(event, state.Chain.ID)
+}
+
+// addConflict records a conflict between our node and the peer's (unless we already have)
+// the peer's content is kept so the conflict can be resolved after we disconnect
+func (n *Node) addConflict(peer *conn, dir *scry.Dir, state *scry.ChainState, summary *ChainSummary) error {
+	conflicts, err := n.store.GetConflictsInDir(dir.ID)
+	if err != nil {
+		return err
+	}
+	for _, conflict := range conflicts {
+		if conflict.Path == state.Path && conflict.Remote.Version.Compare(summary.Version) == scry.VersionEqual {
+			return nil
+		}
+	}
+	tail, err := n.store.GetChainTail(state.Chain.ID)
+	if err != nil {
+		return err
+	}
+	if tail == nil {
+		return errors.New(fmt.Sprintf("No events in chain for %q", state.Path))
+	}
+	logger.Warn(fmt.Sprintf("Conflict syncing %q, local: %s %s remote: %s %s", state.Path, tail, tail.Version, summary.toEvent(), summary.Version))
+	if !summary.Removed && summary.Hash != nil && n.opts.Blobs != nil {
+		if err = n.fetchContent(peer, dir, summary, n.keepBlob(summary)); err != nil {
+			logger.Warn(err.Error())
+		}
+	}
+	conflict := &scry.Conflict{
+		ChainID:  state.Chain.ID,
+		Path:     state.Path,
+		Local:    *tail,
+		Remote:   summary.toEvent(),
+		Detected: time.Now(),
+	}
+	return n.store.AddConflict(conflict, dir.ID)
+}
+
+// fetchContent fetches the content for a node from the peer, checks it and hands it off
+// chunked content is put together from the chunks we have and the ones the peer sends (see fetchChunks)
+func (n *Node) fetchContent(peer *conn, dir *scry.Dir, summary *ChainSummary, use func(hash string, r io.Reader) error) error {
+	var content []byte
+	if len(summary.Chunks) > 0 {
+		var err error
+		if content, err = n.fetchChunks(peer, dir, summary); err != nil {
+			return errors.New(fmt.Sprintf("Failed to fetch chunks for %q:\n%s", summary.Path, err.Error()))
+		}
+	} else {
+		res, err := peer.request(&Request{Type: GET_CONTENT, DirPath: dir.Path, Hash: *summary.Hash})
+		if err != nil {
+			return errors.New(fmt.Sprintf("Failed to fetch content for %q:\n%s", summary.Path, err.Error()))
+		}
+		content = res.Content
+	}
+	ok, err := fnode.CheckHash(*summary.Hash, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New(fmt.Sprintf("Content for %q doesn't match hash %q", summary.Path, *summary.Hash))
+	}
+	return use(*summary.Hash, bytes.NewReader(content))
+}
+
+// fetchChunks puts together chunked content for a node
+// chunks are taken from the blob store and the file at the node's path (the version we're replacing) if they're there,
+// only the rest are fetched from the peer
+func (n *Node) fetchChunks(peer *conn, dir *scry.Dir, summary *ChainSummary) ([]byte, error) {
+	have := make(map[string][]byte, len(summary.Chunks))
+	wanted := make(map[string]bool, len(summary.Chunks))
+	for _, chunk := range summary.Chunks {
+		wanted[chunk.Hash] = true
+	}
+	if n.opts.Blobs != nil {
+		for hash := range wanted {
+			blob, err := n.opts.Blobs.Open(hash)
+			if blob == nil || err != nil {
+				continue
+			}
+			data, err := io.ReadAll(blob)
+			blob.Close()
+			if err == nil {
+				have[hash] = data
+			}
+		}
+	}
+	if len(have) < len(wanted) {
+		readLocalChunks(n.fullPath(dir, summary.Path), wanted, have)
+	}
+	missing := make([]string, 0, len(wanted)-len(have))
+	for _, chunk := range summary.Chunks {
+		if _, ok := have[chunk.Hash]; !ok && wanted[chunk.Hash] {
+			missing = append(missing, chunk.Hash)
+			wanted[chunk.Hash] = false // only ask once
+		}
+	}
+	logger.Debug(fmt.Sprintf("Sync %q: fetching %d of %d chunks", summary.Path, len(missing), len(summary.Chunks)))
+	for start := 0; start < len(missing); start += MAX_CHUNKS_PER_REQUEST {
+		batch := missing[start:min(start+MAX_CHUNKS_PER_REQUEST, len(missing))]
+		res, err := peer.request(&Request{Type: GET_CHUNKS, DirPath: dir.Path, Hash: *summary.Hash, Chunks: batch})
+		if err != nil {
+			return nil, err
+		}
+		if len(res.Chunks) != len(batch) {
+			return nil, errors.New(fmt.Sprintf("Asked for %d chunks, got %d", len(batch), len(res.Chunks)))
+		}
+		for i, hash := range batch {
+			if ok, err := fnode.CheckHash(hash, bytes.NewReader(res.Chunks[i])); err != nil || !ok {
+				return nil, errors.New(fmt.Sprintf("Chunk doesn't match hash %q", hash))
+			}
+			have[hash] = res.Chunks[i]
+		}
+	}
+	var content bytes.Buffer
+	for _, chunk := range summary.Chunks {
+		content.Write(have[chunk.Hash])
+	}
+	return content.Bytes(), nil
+}
+
+// readLocalChunks adds the chunks of the file at path we want to have
+// it's best effort, a missing or unreadable file just doesn't add anything
+func readLocalChunks(path string, wanted map[string]bool, have map[string][]byte) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	fnode.SplitChunks(file, func(data []byte) error {
+		hash, err := fnode.GetHash(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		if _, ok := have[hash]; wanted[hash] && !ok {
+			have[hash] = append([]byte{}, data...) // data is only valid in the callback
+		}
+		return nil
+	})
+}
+
+// keepBlob returns a func that keeps fetched content for a node in the blob store (as chunks if it's chunked)
+func (n *Node) keepBlob(summary *ChainSummary) func(hash string, r io.Reader) error {
+	return func(hash string, r io.Reader) error {
+		if len(summary.Chunks) > 0 {
+			return n.opts.Blobs.AddChunks(hash, summary.Chunks, r)
+		}
+		return n.opts.Blobs.Add(hash, r)
+	}
+}
+
+// writeFile fetches the content for a node from the peer and writes it
+func (n *Node) writeFile(peer *conn, dir *scry.Dir, summary *ChainSummary) error {
+	path, err := n.writablePath(dir, summary.Path)
+	if err != nil {
+		return err
+	}
+	return n.fetchContent(peer, dir, summary, func(hash string, r io.Reader) error {
+		// keep the peer's mod time so the node matches the events we store for it
+		state := fnode.NodeState{Hash: &hash, ModTime: summary.ModTime, Mode: summary.Mode, Uid: summary.Uid, Gid: summary.Gid}
+		if err := n.applier.WriteFile(path, r, state); err != nil {
+			return err
+		}
+		if n.opts.Blobs != nil && len(summary.Chunks) > 0 {
+			return n.opts.Blobs.AddFileChunks(hash, summary.Chunks, path)
+		} else if n.opts.Blobs != nil {
+			return n.opts.Blobs.AddFile(hash, path)
+		}
+		return nil
+	})
+}
+
+// relinkChain points a chain at the node now at path if it's a new inode (e.g. the node was replaced w/ an update)
+func (n *Node) relinkChain(chain *scry.Chain, path string) error {
+	node, err := fnode.NewNode(path)
+	if err != nil {
+		return err
+	}
+	if node.Ino == chain.Ino {
+		return nil
+	}
+	if err = n.store.SetChainIno(chain.ID, node.Ino); err != nil {
+		return err
+	}
+	chain.Ino = node.Ino
+	return nil
+}
+
+// appendEvents adds the peer's events for the node at path to a local chain
+// only events newer than the version we have are added
+// a new chain (nil version) only gets the events from the node's most recent create
+func (n *Node) appendEvents(peer *conn, dir *scry.Dir, chainID scry.ID, path string, have scry.VersionVector) error {
+	res, err := peer.request(&Request{Type: GET_EVENTS, DirPath: dir.Path, Path: path})
+	if err != nil {
+		return err
+	}
+	events := res.Events
+	for _, event := range events {
+		if !filepath.IsLocal(event.Path) {
+			return errors.New(fmt.Sprintf("Peer sent event w/ path %q outside dir %q", event.Path, dir.Path))
+		}
+	}
+	if have == nil {
+		for i := len(events) - 1; i >= 0; i-- {
+			if events[i].Type == scry.Create {
+				events = events[i:]
+				break
+			}
+		}
+	}
+	for i := range events {
+		event := events[i]
+		if have != nil && have.Dominates(event.Version) {
+			continue
+		}
+		event.OldPath = nil // the store sets this from our chain
+		if err = n.store.AddEvent(&event, chainID); err != nil {
+			return errors.New(fmt.Sprintf("Failed to add synced event %s:\n%s", event, err.Error()))
+		}
+	}
+	return nil
+}
+
+// isLocalSummary tells if the paths in a summary stay inside the dir it's for
+// (a peer could send one like "../x" that would be written outside it)
+func isLocalSummary(summary *ChainSummary) bool {
+	return filepath.IsLocal(summary.Path) && (summary.PrevPath == nil || filepath.IsLocal(*summary.PrevPath))
+}
+
+func (n *Node) fullPath(dir *scry.Dir, path string) string {
+	return filepath.Join(n.topDir, dir.Path, path)
+}
+
+// writablePath is fullPath for a node sync is about to change
+// it errors if one of the node's parents is a symlink, the change could end up outside the dir
+func (n *Node) writablePath(dir *scry.Dir, path string) (string, error) {
+	fullPath := n.fullPath(dir, path)
+	if err := fnode.CheckNoLinks(n.fullPath(dir, ""), filepath.Dir(fullPath)); err != nil {
+		return "", err
+	}
+	return fullPath, nil
+}
+
+// movePrefix moves a node and everything under it to a new path
+func movePrefix(local map[string]*scry.ChainState, oldPath string, newPath string) {
+	oldPrefix := oldPath + string(filepath.Separator)
+	moved := make([]*scry.ChainState, 0)
+	for path, state := range local {
+		if path == oldPath || strings.HasPrefix(path, oldPrefix) {
+			moved = append(moved, state)
+			delete(local, path)
+		}
+	}
+	for _, state := range moved {
+		state.Path = filepath.Join(newPath, strings.TrimPrefix(state.Path, oldPath))
+		local[state.Path] = state
+	}
+}
+
+// prefer tells if the first of two nodes at the same path should be used
+// live nodes win over removed ones, otherwise the most recent wins
+func prefer(removed bool, timestamp time.Time, otherRemoved bool, otherTimestamp time.Time) bool {
+	if removed != otherRemoved {
+		return !removed
+	}
+	return timestamp.After(otherTimestamp)
+}
+
+func sameContent(state *scry.ChainState, summary *ChainSummary) bool {
+	if isDir(state) || summary.IsDir() {
+		return isDir(state) == summary.IsDir()
+	}
+	if isLink(state) || summary.IsLink() {
+		return state.State.Target == summary.Target
+	}
+	return *state.State.Hash == *summary.Hash
+}
+
+// sameMeta tells if the local node has the peer's mode and ownership
+// (ownership only counts when we're root, see fnode.SetMeta)
+func sameMeta(state *scry.ChainState, summary *ChainSummary) bool {
+	if state.State == nil || summary.Mode == 0 {
+		return true
+	}
+	if state.State.Mode != summary.Mode {
+		return false
+	}
+	return os.Geteuid() != 0 || (state.State.Uid == summary.Uid && state.State.Gid == summary.Gid)
+}
+
+func isDir(state *scry.ChainState) bool {
+	return state.State == nil || (state.State.Hash == nil && state.State.Target == "")
+}
+
+func isLink(state *scry.ChainState) bool {
+	return state.State != nil && state.State.Target != ""
+}
+
+func pathDepth(path string) int {
+	return strings.Count(filepath.Clean(path), string(filepath.Separator))
+}
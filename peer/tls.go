@@ -0,0 +1,76 @@
+package peer
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ceejimus/kusari/identity"
+)
+
+const HANDSHAKE_TIMEOUT = 10 * time.Second
+
+// tlsConfig makes the TLS config used for both ends of peer connections
+//
+// both sides present a self-signed certificate for their node key and
+// only accept a peer whose certificate is for a trusted key (the key is pinned, there's no CA)
+func tlsConfig(id *identity.Identity, trusted identity.TrustedKeys) (*tls.Config, error) {
+	cert, err := id.Certificate()
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS13,
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAnyClientCert,
+		// there's no chain to verify, VerifyPeerCertificate checks the key instead
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			key, err := peerKey(rawCerts)
+			if err != nil {
+				return err
+			}
+			if !trusted.Trusts(identity.NodeIDFor(key), key) {
+				return errors.New(fmt.Sprintf("Peer %s has an untrusted key %s", identity.NodeIDFor(key), identity.EncodePublicKey(key)))
+			}
+			return nil
+		},
+	}, nil
+}
+
+// handshake runs the TLS handshake (if it hasn't happened) and returns the peer's node ID
+func handshake(c *tls.Conn) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), HANDSHAKE_TIMEOUT)
+	defer cancel()
+	if err := c.HandshakeContext(ctx); err != nil {
+		return "", err
+	}
+	certs := c.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return "", errors.New("Peer didn't present a certificate")
+	}
+	key, ok := certs[0].PublicKey.(ed25519.PublicKey)
+	if !ok {
+		return "", errors.New("Peer's certificate isn't for an Ed25519 key")
+	}
+	return identity.NodeIDFor(key), nil
+}
+
+func peerKey(rawCerts [][]byte) (ed25519.PublicKey, error) {
+	if len(rawCerts) == 0 {
+		return nil, errors.New("Peer didn't present a certificate")
+	}
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Invalid peer certificate:\n%s", err.Error()))
+	}
+	key, ok := cert.PublicKey.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("Peer's certificate isn't for an Ed25519 key")
+	}
+	return key, nil
+}
@@ -0,0 +1,253 @@
+// Resolve conflicts recorded during sync
+//
+// A conflict is resolved by adding an event to the local chain whose version
+// includes both sides, so neither node sees the change as concurrent again.
+
+package resolve
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ceejimus/kusari/fnode"
+	"github.com/ceejimus/kusari/logger"
+	"github.com/ceejimus/kusari/scry"
+)
+
+// how to resolve a conflict
+type Resolution int
+
+const (
+	KEEP_LOCAL  Resolution = iota // keep the local node as is
+	KEEP_REMOTE                   // replace the local node w/ the remote one
+	KEEP_BOTH                     // keep the local node and write the remote one next to it
+)
+
+// suffix added to the path of the remote node when keeping both
+const CONFLICT_SUFFIX = ".conflict-"
+
+func ParseResolution(s string) (Resolution, error) {
+	switch s {
+	case "local":
+		return KEEP_LOCAL, nil
+	case "remote":
+		return KEEP_REMOTE, nil
+	case "both":
+		return KEEP_BOTH, nil
+	default:
+		return 0, errors.New(fmt.Sprintf("Unknown resolution %q (want local, remote or both)", s))
+	}
+}
+
+func (r Resolution) String() string {
+	switch r {
+	case KEEP_LOCAL:
+		return "local"
+	case KEEP_REMOTE:
+		return "remote"
+	case KEEP_BOTH:
+		return "both"
+	default:
+		panic(fmt.Sprintf("unexpected resolve.Resolution: %#v", r))
+	}
+}
+
+// Resolver resolves the conflicts of a Dir
+type Resolver struct {
+	topDir string
+	dir    *scry.Dir
+	store  scry.EventStore
+	opts   scry.Options
+}
+
+func NewResolver(topDir string, dir *scry.Dir, store scry.EventStore, opts scry.Options) *Resolver {
+	return &Resolver{topDir: topDir, dir: dir, store: store, opts: opts}
+}
+
+// Resolve resolves a conflict and removes it from the store
+// it returns the paths (relative to the Dir) that were written or removed
+func (r *Resolver) Resolve(conflict *scry.Conflict, resolution Resolution) ([]string, error) {
+	tail, err := r.store.GetChainTail(conflict.ChainID)
+	if err != nil {
+		return nil, err
+	}
+	if tail == nil {
+		return nil, errors.New(fmt.Sprintf("No events in chain for %q", conflict.Path))
+	}
+	// the node may have changed since the conflict was detected
+	version := tail.Version.Merge(conflict.Remote.Version)
+	var changed []string
+	switch resolution {
+	case KEEP_LOCAL:
+		err = r.keepLocal(conflict, tail, version)
+	case KEEP_REMOTE:
+		changed, err = r.keepRemote(conflict, tail, version)
+	case KEEP_BOTH:
+		if err = r.keepLocal(conflict, tail, version); err == nil {
+			changed, err = r.keepCopy(conflict)
+		}
+	}
+	if err != nil {
+		return changed, err
+	}
+	logger.Info(fmt.Sprintf("Resolved conflict for %q, kept %s", conflict.Path, resolution))
+	return changed, r.store.RemoveConflict(conflict.ID)
+}
+
+// keepLocal re-adds the local node's current state w/ a version that includes the remote change
+func (r *Resolver) keepLocal(conflict *scry.Conflict, tail *scry.Event, version scry.VersionVector) error {
+	event := &scry.Event{Path: tail.Path, Type: scry.Write}
+	if tail.Type == scry.Remove {
+		event.Type = scry.Remove
+	} else if tail.Type == scry.Create || tail.Type == scry.Write || tail.Type == scry.Chmod {
+		event.Size = tail.Size
+		event.Hash = tail.Hash
+		event.ModTime = tail.ModTime
+		event.Mode = tail.Mode
+		event.Uid = tail.Uid
+		event.Gid = tail.Gid
+		event.Target = tail.Target
+		event.Chunks = tail.Chunks
+	} else {
+		return errors.New(fmt.Sprintf("Cannot resolve %q while it's being moved", conflict.Path))
+	}
+	return r.addEvent(event, version, conflict.ChainID)
+}
+
+// keepRemote replaces the local node w/ the remote one
+func (r *Resolver) keepRemote(conflict *scry.Conflict, tail *scry.Event, version scry.VersionVector) ([]string, error) {
+	remote := conflict.Remote
+	path := r.fullPath(conflict.Path)
+	if remote.Type == scry.Remove {
+		if tail.Type == scry.Remove {
+			return nil, r.addEvent(&scry.Event{Path: conflict.Path, Type: scry.Remove}, version, conflict.ChainID)
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		return []string{conflict.Path}, r.addEvent(&scry.Event{Path: conflict.Path, Type: scry.Remove}, version, conflict.ChainID)
+	}
+	// links can't be written in place, the node is replaced
+	replace := tail.Type != scry.Remove && (remote.Target != "" || tail.Target != "")
+	if replace && tail.Target != "" {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	if err := r.writeNode(&remote, path); err != nil {
+		return nil, err
+	}
+	event := &scry.Event{Path: conflict.Path, Type: scry.Write, Size: remote.Size, Hash: remote.Hash, ModTime: remote.ModTime, Mode: remote.Mode, Uid: remote.Uid, Gid: remote.Gid, Target: remote.Target, Chunks: remote.Chunks}
+	if tail.Type != scry.Remove && !replace {
+		if err := r.relinkChain(conflict.ChainID, path); err != nil {
+			return nil, err
+		}
+		return []string{conflict.Path}, r.addEvent(event, version, conflict.ChainID)
+	}
+	// the local node is gone (or was replaced) so the remote one gets a new chain
+	event.Type = scry.Create
+	return []string{conflict.Path}, r.addChain(event, version, path)
+}
+
+// keepCopy writes the remote node next to the local one (on a new chain)
+func (r *Resolver) keepCopy(conflict *scry.Conflict) ([]string, error) {
+	remote := conflict.Remote
+	if remote.Type == scry.Remove { // nothing to keep
+		return nil, nil
+	}
+	copyPath := conflict.Path + CONFLICT_SUFFIX + remote.Origin
+	path := r.fullPath(copyPath)
+	if _, err := os.Lstat(path); err == nil {
+		return nil, errors.New(fmt.Sprintf("Cannot keep remote copy of %q, %q already exists", conflict.Path, copyPath))
+	}
+	if err := r.writeNode(&remote, path); err != nil {
+		return nil, err
+	}
+	event := &scry.Event{Path: copyPath, Type: scry.Create, Size: remote.Size, Hash: remote.Hash, ModTime: remote.ModTime, Mode: remote.Mode, Uid: remote.Uid, Gid: remote.Gid, Target: remote.Target, Chunks: remote.Chunks}
+	return []string{copyPath}, r.addChain(event, nil, path)
+}
+
+// writeNode writes a remote node at path, files and links replace whatever is there
+// nothing's written through symlinks, they could lead outside the Dir
+func (r *Resolver) writeNode(remote *scry.Event, path string) error {
+	dirPath := r.fullPath("")
+	if remote.Hash == nil && remote.Target == "" {
+		if err := fnode.CheckNoLinks(dirPath, path); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return err
+		}
+		return fnode.SetMeta(path, remote.Mode, remote.Uid, remote.Gid)
+	}
+	if err := fnode.CheckNoLinks(dirPath, filepath.Dir(path)); err != nil {
+		return err
+	}
+	if remote.Target != "" {
+		if err := fnode.WriteLink(path, remote.Target); err != nil {
+			return err
+		}
+		return fnode.SetMeta(path, remote.Mode, remote.Uid, remote.Gid)
+	}
+	if r.opts.Blobs == nil {
+		return errors.New("Cannot resolve files w/o a blob store")
+	}
+	blob, err := r.opts.Blobs.Open(*remote.Hash)
+	if err != nil {
+		return err
+	}
+	if blob == nil {
+		return errors.New(fmt.Sprintf("Remote content of %q (%s) wasn't kept", remote.Path, *remote.Hash))
+	}
+	defer blob.Close()
+	applier := r.opts.Applier
+	if applier == nil {
+		applier = scry.NewApplier()
+	}
+	state := fnode.NodeState{Hash: remote.Hash, ModTime: remote.ModTime, Mode: remote.Mode, Uid: remote.Uid, Gid: remote.Gid}
+	return applier.WriteFile(path, blob, state)
+}
+
+// relinkChain points a chain at the node now at path (it was replaced when it was written)
+func (r *Resolver) relinkChain(chainID scry.ID, path string) error {
+	chain, err := r.store.GetChainByID(chainID)
+	if chain == nil || err != nil {
+		return err
+	}
+	node, err := fnode.NewNode(path)
+	if err != nil {
+		return err
+	}
+	if node.Ino == chain.Ino {
+		return nil
+	}
+	return r.store.SetChainIno(chainID, node.Ino)
+}
+
+// addChain adds a new chain for a node we wrote w/ its create event
+func (r *Resolver) addChain(event *scry.Event, version scry.VersionVector, path string) error {
+	node, err := fnode.NewNode(path)
+	if err != nil {
+		return err
+	}
+	chain := &scry.Chain{Ino: node.Ino}
+	if err = r.store.AddChain(chain, r.dir.ID); err != nil {
+		return err
+	}
+	return r.addEvent(event, version, chain.ID)
+}
+
+// addEvent adds a resolving event, its version follows both sides of the conflict
+func (r *Resolver) addEvent(event *scry.Event, version scry.VersionVector, chainID scry.ID) error {
+	event.Timestamp = time.Now()
+	event.Origin = r.opts.NodeID
+	event.Version = version.Bump(r.opts.NodeID)
+	return r.store.AddEvent(event, chainID)
+}
+
+func (r *Resolver) fullPath(path string) string {
+	return filepath.Join(r.topDir, r.dir.Path, path)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ceejimus/kusari/blobstore"
+	"github.com/ceejimus/kusari/fnode"
+	"github.com/ceejimus/kusari/restore"
+	"github.com/ceejimus/kusari/scry"
+)
+
+// time formats accepted by --at (besides durations and event IDs)
+var AT_FORMATS = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+// kusari restore [--at <time|duration|eventID>] [--old-path] [--list] <path>
+func runRestore(args []string) {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	at := flags.String("at", "", "when to restore to - a time (e.g. 2006-01-02 15:04), how long ago (e.g. 24h) or an event ID (see --list)")
+	toOldPath := flags.Bool("old-path", false, "restore to the path the node had at that time instead of its current path")
+	list := flags.Bool("list", false, "list the node's history instead of restoring it")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: kusari restore [flags] <path>\n")
+		flags.PrintDefaults()
+	}
+	// allow flags on either side of the path
+	flags.Parse(args)
+	path := flags.Arg(0)
+	if flags.NArg() > 0 {
+		flags.Parse(flags.Args()[1:])
+	}
+	if path == "" || flags.NArg() > 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if !*list && *at == "" {
+		fmt.Fprintf(os.Stderr, "Either --at or --list is required\n")
+		os.Exit(2)
+	}
+
+	if err := restorePath(path, *at, *toOldPath, *list); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+func restorePath(path string, at string, toOldPath bool, list bool) error {
+	config := loadConfig()
+
+	store, err := openStore(config)
+	if err != nil {
+		return errors.New(fmt.Sprintf("%s\n(is kusari running?)", err.Error()))
+	}
+	defer store.Close()
+	blobs, err := blobstore.NewBlobStore(config.BlobDir)
+	if err != nil {
+		return err
+	}
+
+	dir, relPath, err := lkpDirForPath(store, config.TopDir, path)
+	if err != nil {
+		return err
+	}
+	restorer := restore.NewRestorer(config.TopDir, dir, store, blobs)
+
+	if list {
+		events, err := restorer.History(relPath)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			fmt.Printf("%s %s %s\n", restore.FormatID(event.ID), event.Timestamp.Format(time.RFC3339), event)
+		}
+		return nil
+	}
+
+	atTime, err := parseAt(restorer, relPath, at)
+	if err != nil {
+		return err
+	}
+	restored, err := restorer.Restore(relPath, atTime, toOldPath)
+	for _, p := range restored {
+		fmt.Printf("Restored %s\n", filepath.Join(config.TopDir, dir.Path, p))
+	}
+	return err
+}
+
+// lkpDirForPath finds the stored Dir a path is in and the path relative to it
+func lkpDirForPath(store scry.EventStore, topDir string, path string) (*scry.Dir, string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, "", err
+	}
+	absTopDir, err := filepath.Abs(topDir)
+	if err != nil {
+		return nil, "", err
+	}
+	dirs, err := store.GetDirs()
+	if err != nil {
+		return nil, "", err
+	}
+	for i := range dirs {
+		dirPath := filepath.Join(absTopDir, dirs[i].Path)
+		if strings.HasPrefix(absPath, dirPath+string(filepath.Separator)) {
+			return &dirs[i], fnode.GetRelativePath(absPath, dirPath), nil
+		}
+	}
+	return nil, "", errors.New(fmt.Sprintf("%q isn't in a scried directory", path))
+}
+
+// parseAt parses --at as a time, a duration ago or an event ID in the node's chain
+func parseAt(restorer *restore.Restorer, relPath string, at string) (time.Time, error) {
+	if ago, err := time.ParseDuration(at); err == nil {
+		return time.Now().Add(-ago), nil
+	}
+	for _, format := range AT_FORMATS {
+		if t, err := time.ParseInLocation(format, at, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return restorer.EventTime(relPath, at)
+}
@@ -0,0 +1,205 @@
+// Restore nodes to earlier versions
+//
+// Nodes are found by their chain so a node can be restored to how it was before
+// it was written, moved or removed. Content comes from the blob store.
+
+package restore
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ceejimus/kusari/fnode"
+	"github.com/ceejimus/kusari/logger"
+	"github.com/ceejimus/kusari/scry"
+)
+
+// Restorer restores the nodes of a Dir from its chains
+type Restorer struct {
+	topDir  string
+	dir     *scry.Dir
+	store   scry.EventStore
+	blobs   scry.BlobStore
+	applier *scry.Applier
+}
+
+func NewRestorer(topDir string, dir *scry.Dir, store scry.EventStore, blobs scry.BlobStore) *Restorer {
+	return &Restorer{topDir: topDir, dir: dir, store: store, blobs: blobs, applier: scry.NewApplier()}
+}
+
+// FormatID returns the string form of an event ID (as listed by History)
+func FormatID(id scry.ID) string {
+	return hex.EncodeToString(id.Encode())
+}
+
+// History returns the events in the chain of the node at path (relative to the Dir)
+// the node may have been removed
+func (r *Restorer) History(path string) ([]scry.Event, error) {
+	chain, err := r.lkpChain(path)
+	if err != nil {
+		return nil, err
+	}
+	return r.store.GetEventsInChain(chain.ID)
+}
+
+// EventTime returns the time of the event w/ the given ID in the chain of the node at path
+func (r *Restorer) EventTime(path string, eventID string) (time.Time, error) {
+	events, err := r.History(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, event := range events {
+		if FormatID(event.ID) == eventID {
+			return event.Timestamp, nil
+		}
+	}
+	return time.Time{}, errors.New(fmt.Sprintf("No event w/ ID %q in the chain for %q", eventID, path))
+}
+
+// Restore writes the node at path (relative to the Dir) back to how it was at the given time
+// directories are restored w/ everything that was in them, nothing newer is removed
+//
+// nodes are restored to their current path (where path is now) unless toOldPath is set,
+// then they're restored to the path they had at the given time
+//
+// it returns the paths (relative to the Dir) that were restored
+func (r *Restorer) Restore(path string, at time.Time, toOldPath bool) ([]string, error) {
+	chain, err := r.lkpChain(path)
+	if err != nil {
+		return nil, err
+	}
+	// find where the node was at the time
+	states, err := scry.GetChainStates(r.store, r.dir.ID, at)
+	if err != nil {
+		return nil, err
+	}
+	var state *scry.ChainState
+	for i := range states {
+		if string(states[i].Chain.ID.Encode()) == string(chain.ID.Encode()) {
+			state = &states[i]
+			break
+		}
+	}
+	if state == nil || state.Removed || state.Moved || state.State == nil {
+		return nil, errors.New(fmt.Sprintf("%q didn't exist at %s", path, at.Format(time.RFC3339)))
+	}
+	oldPath := state.Path
+	dstPath := func(p string) string {
+		if toOldPath {
+			return p
+		}
+		return path + strings.TrimPrefix(p, oldPath)
+	}
+	// get it and everything that was in it
+	dirState, err := scry.GetDirStateAt(r.store, r.dir.ID, at)
+	if err != nil {
+		return nil, err
+	}
+	toRestore := make([]fnode.NodeState, 0)
+	for p, nodeState := range dirState {
+		if p == oldPath || strings.HasPrefix(p, oldPath+string(filepath.Separator)) {
+			toRestore = append(toRestore, nodeState)
+		}
+	}
+	// parents before children
+	sort.Slice(toRestore, func(i, j int) bool {
+		return toRestore[i].Path < toRestore[j].Path
+	})
+	restored := make([]string, 0, len(toRestore))
+	for _, nodeState := range toRestore {
+		dst := dstPath(nodeState.Path)
+		if err = r.restoreNode(nodeState, dst); err != nil {
+			return restored, err
+		}
+		logger.Debug(fmt.Sprintf("Restored %q to %q", nodeState.Path, dst))
+		restored = append(restored, dst)
+	}
+	return restored, nil
+}
+
+// restoreNode writes a single node (creating its parents)
+// nothing's written through symlinks, they could lead outside the Dir
+func (r *Restorer) restoreNode(nodeState fnode.NodeState, dst string) error {
+	dirPath := filepath.Join(r.topDir, r.dir.Path)
+	fullPath := filepath.Join(dirPath, dst)
+	if nodeState.Hash == nil && nodeState.Target == "" {
+		if err := fnode.CheckNoLinks(dirPath, fullPath); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(fullPath, 0755); err != nil {
+			return err
+		}
+		return fnode.SetMeta(fullPath, nodeState.Mode, nodeState.Uid, nodeState.Gid)
+	}
+	// links and files replace whatever is at their path (a link there isn't followed)
+	if err := fnode.CheckNoLinks(dirPath, filepath.Dir(fullPath)); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return err
+	}
+	if nodeState.Target != "" {
+		if err := fnode.WriteLink(fullPath, nodeState.Target); err != nil {
+			return err
+		}
+		return fnode.SetMeta(fullPath, nodeState.Mode, nodeState.Uid, nodeState.Gid)
+	}
+	if r.blobs == nil {
+		return errors.New("Cannot restore files w/o a blob store")
+	}
+	blob, err := r.blobs.Open(*nodeState.Hash)
+	if err != nil {
+		return err
+	}
+	if blob == nil {
+		return errors.New(fmt.Sprintf("Content of %q (%s) wasn't kept", nodeState.Path, *nodeState.Hash))
+	}
+	defer blob.Close()
+	state := fnode.NodeState{Hash: nodeState.Hash, ModTime: nodeState.ModTime, Mode: nodeState.Mode, Uid: nodeState.Uid, Gid: nodeState.Gid}
+	if err = r.applier.WriteFile(fullPath, blob, state); err != nil {
+		return errors.New(fmt.Sprintf("Failed to write %q:\n%s", dst, err.Error()))
+	}
+	return r.relinkChain(dst, fullPath)
+}
+
+// relinkChain points the chain of the node at dst to the file now there
+// it was written next to it and moved into place so it has a new inode
+func (r *Restorer) relinkChain(dst string, fullPath string) error {
+	chain, err := r.store.GetChainByPath(r.dir.ID, dst)
+	if chain == nil || err != nil {
+		return err
+	}
+	node, err := fnode.NewNode(fullPath)
+	if err != nil {
+		return err
+	}
+	if node.Ino == chain.Ino {
+		return nil
+	}
+	return r.store.SetChainIno(chain.ID, node.Ino)
+}
+
+// lkpChain finds the chain for the node at path, even if it's been removed
+func (r *Restorer) lkpChain(path string) (*scry.Chain, error) {
+	chain, err := r.store.GetChainByPath(r.dir.ID, path)
+	if err != nil {
+		return nil, err
+	}
+	if chain != nil {
+		return chain, nil
+	}
+	state, err := scry.GetChainStateByPath(r.store, r.dir.ID, path, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, errors.New(fmt.Sprintf("No history for %q", path))
+	}
+	return &state.Chain, nil
+}
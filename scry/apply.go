@@ -0,0 +1,207 @@
+package scry
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ceejimus/kusari/fnode"
+)
+
+// the prefix of the temp nodes the applier writes, they're never scried
+const APPLY_TMP_PREFIX = ".kusari-apply-"
+
+// how long the events caused by an applied change are expected
+const APPLY_WINDOW = 30 * time.Second
+
+// Applier makes changes that happened elsewhere (e.g. on a peer) to the nodes in scried dirs
+//
+// Files and links are written next to where they go then renamed into place so they're never seen half written.
+// The changes are expected: a scryer sharing the applier (see Options.Applier) drops the events they cause
+// instead of storing them as local changes, the events for them are added by whoever applied them.
+// Events that don't match what was applied (e.g. the node was changed again) are stored as usual.
+type Applier struct {
+	mu       sync.Mutex
+	expected map[string]expectation // by full path
+}
+
+// what a node is expected to look like after an applied change
+type expectation struct {
+	gone  bool             // the node was removed or moved away
+	state *fnode.NodeState // nil matches any node (e.g. one that was moved here)
+	isDir bool
+	until time.Time
+}
+
+func NewApplier() *Applier {
+	return &Applier{expected: make(map[string]expectation)}
+}
+
+// WriteFile replaces the file at path w/ the content of r and the mode, owner and mod time in state
+// state.Hash is the hash of the content
+func (a *Applier) WriteFile(path string, r io.Reader, state fnode.NodeState) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), APPLY_TMP_PREFIX+"*")
+	if err != nil {
+		return errors.New(fmt.Sprintf("Failed to write %q:\n%s", path, err.Error()))
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.New(fmt.Sprintf("Failed to write %q:\n%s", path, err.Error()))
+	}
+	mode := state.Mode
+	if mode == 0 { // unknown, keep the mode of the file we're replacing
+		mode = 0644
+		if info, err := os.Stat(path); err == nil {
+			mode = info.Mode() & fnode.MODE_MASK
+		}
+	}
+	if err = fnode.SetMeta(tmp.Name(), mode, state.Uid, state.Gid); err != nil {
+		return err
+	}
+	if err = os.Chtimes(tmp.Name(), state.ModTime, state.ModTime); err != nil {
+		return err
+	}
+	state.Mode = mode
+	return a.rename(tmp.Name(), path, expectation{state: &state})
+}
+
+// WriteLink replaces the node at path w/ a symlink to target
+func (a *Applier) WriteLink(path string, target string) error {
+	tmpPath := filepath.Join(filepath.Dir(path), fmt.Sprintf("%s%d-%d", APPLY_TMP_PREFIX, os.Getpid(), time.Now().UnixNano()))
+	if err := os.Symlink(target, tmpPath); err != nil {
+		return errors.New(fmt.Sprintf("Failed to link %q -> %q:\n%s", path, target, err.Error()))
+	}
+	defer os.Remove(tmpPath)
+	return a.rename(tmpPath, path, expectation{state: &fnode.NodeState{Target: target}})
+}
+
+// Mkdir makes a dir (and any missing parents) w/ the mode and owner in state
+func (a *Applier) Mkdir(path string, state fnode.NodeState) error {
+	a.expect(path, expectation{state: &state, isDir: true})
+	if err := os.MkdirAll(path, 0755); err != nil {
+		a.forget(path)
+		return errors.New(fmt.Sprintf("Failed to create %q:\n%s", path, err.Error()))
+	}
+	return fnode.SetMeta(path, state.Mode, state.Uid, state.Gid)
+}
+
+// Remove removes the node at path (dirs must be empty)
+func (a *Applier) Remove(path string) error {
+	a.expect(path, expectation{gone: true})
+	if err := os.Remove(path); err != nil {
+		a.forget(path)
+		return err
+	}
+	return nil
+}
+
+// Rename moves the node at oldPath to newPath
+func (a *Applier) Rename(oldPath string, newPath string) error {
+	a.expect(oldPath, expectation{gone: true})
+	if err := a.rename(oldPath, newPath, expectation{}); err != nil {
+		a.forget(oldPath)
+		return err
+	}
+	return nil
+}
+
+// SetMeta changes the mode and owner of the node at path (see fnode.SetMeta)
+func (a *Applier) SetMeta(path string, mode os.FileMode, uid uint32, gid uint32) error {
+	node, err := fnode.NewNode(path)
+	if err != nil {
+		return err
+	}
+	state := node.State()
+	if mode != 0 {
+		state.Mode = mode
+	}
+	a.expect(path, expectation{state: &state, isDir: node.Type() == fnode.DIR})
+	if err = fnode.SetMeta(path, mode, uid, gid); err != nil {
+		a.forget(path)
+		return err
+	}
+	return nil
+}
+
+// rename renames a node into place w/ the given expectation for it
+func (a *Applier) rename(oldPath string, newPath string, e expectation) error {
+	a.expect(newPath, e)
+	if err := os.Rename(oldPath, newPath); err != nil {
+		a.forget(newPath)
+		return errors.New(fmt.Sprintf("Failed to move %q -> %q:\n%s", oldPath, newPath, err.Error()))
+	}
+	return nil
+}
+
+func (a *Applier) expect(path string, e expectation) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	e.until = time.Now().Add(APPLY_WINDOW)
+	a.expected[path] = e
+}
+
+func (a *Applier) forget(path string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.expected, path)
+}
+
+// suppresses tells if a node event was caused by an applied change
+// a change can cause a few events (e.g. create then chmod) so matched expectations are kept until they expire,
+// once an event doesn't match the node has changed since and the expectation is dropped
+func (a *Applier) suppresses(nodeEvent *NodeEvent) bool {
+	if a == nil {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	e, ok := a.expected[nodeEvent.FullPath]
+	if !ok {
+		return false
+	}
+	matched := false
+	if time.Now().Before(e.until) {
+		switch nodeEvent.Type {
+		case Remove, Rename:
+			matched = e.gone
+		case Create, Write, Chmod:
+			matched = !e.gone && e.matches(nodeEvent.node)
+		}
+	}
+	if !matched {
+		delete(a.expected, nodeEvent.FullPath)
+	}
+	return matched
+}
+
+// matches tells if a node is what's expected
+func (e *expectation) matches(node *fnode.Node) bool {
+	if e.state == nil {
+		return true
+	}
+	if e.isDir != (node.Type() == fnode.DIR) {
+		return false
+	}
+	state := node.State()
+	if e.state.Mode != 0 && e.state.Mode != state.Mode {
+		return false
+	}
+	if (e.state.Hash == nil) != (state.Hash == nil) || (e.state.Hash != nil && !fnode.SameHash(*e.state.Hash, *state.Hash)) {
+		return false
+	}
+	return e.state.Target == state.Target
+}
+
+// isApplyTmp tells if a node (by path) is one of the applier's temp nodes
+func isApplyTmp(path string) bool {
+	return strings.HasPrefix(filepath.Base(path), APPLY_TMP_PREFIX)
+}
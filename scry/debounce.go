@@ -0,0 +1,257 @@
+package scry
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ceejimus/kusari/fnode"
+	"github.com/ceejimus/kusari/logger"
+)
+
+// pending events are flushed at the latest after this many debounce windows
+// so a file that's written constantly still gets events
+const MAX_DEBOUNCE_WINDOWS = 8
+
+// debouncer holds back node events until the filesystem has been quiet for a window
+// the held back events are coalesced into fewer logical events (see coalesce)
+type debouncer struct {
+	window  time.Duration
+	pending []*NodeEvent
+	first   time.Time // when the oldest pending event was received
+	timer   *time.Timer
+}
+
+func newDebouncer(window time.Duration) *debouncer {
+	timer := time.NewTimer(window)
+	timer.Stop()
+	return &debouncer{window: window, timer: timer}
+}
+
+// add holds back an event and (re)starts the window
+// the node's inode is noted now, it may be gone or replaced by the time the events are coalesced
+func (d *debouncer) add(nodeEvent *NodeEvent) {
+	if nodeEvent.Type == Create || nodeEvent.Type == Write || nodeEvent.Type == Chmod {
+		if node, err := fnode.NewNode(nodeEvent.FullPath); err == nil {
+			nodeEvent.ino = node.Ino
+		}
+	}
+	now := time.Now()
+	if len(d.pending) == 0 {
+		d.first = now
+	}
+	d.pending = append(d.pending, nodeEvent)
+	wait := d.window
+	if maxWait := d.first.Add(MAX_DEBOUNCE_WINDOWS * d.window).Sub(now); maxWait < wait {
+		wait = maxWait
+	}
+	d.timer.Stop()
+	d.timer.Reset(wait)
+}
+
+// take returns the coalesced pending events and clears them
+func (d *debouncer) take() []*NodeEvent {
+	d.timer.Stop()
+	if len(d.pending) == 0 {
+		return nil
+	}
+	events := coalesce(d.pending)
+	logger.Trace(fmt.Sprintf("Coalesced %d events into %d", len(d.pending), len(events)))
+	d.pending = nil
+	return events
+}
+
+// holds tells if an event can be held back
+// creates of dirs aren't, they need to be watched right away so we don't miss what happens in them
+func (d *debouncer) holds(nodeEvent *NodeEvent) bool {
+	if d.window <= 0 {
+		return false
+	}
+	if nodeEvent.Type != Create {
+		return true
+	}
+	info, err := os.Lstat(nodeEvent.FullPath)
+	return err != nil || !info.IsDir()
+}
+
+// coalesce collapses a burst of node events (in the order they happened) into fewer logical events
+// nodes are told apart by inode, events w/o one (e.g. removes) are for the node last seen at their path
+// (their full path, a burst has events from every scried dir)
+//
+//   - writes and chmods to a node that was created or written earlier in the burst are dropped
+//     (the earlier event reads the node's state when it's processed, so it gets the last write)
+//   - repeated chmods to a node are dropped, a write after one is still kept
+//   - a node created in the burst then removed or moved away never happened (e.g. editor temp files)
+//   - a node created in the burst then moved over a path is a save of the node there
+//     (an atomic save: write a temp file then rename it over the original)
+//   - a node moved in the burst then removed is removed from where it was,
+//     unless a node created in the burst took its place, that's a save of the node
+//     (vim's backup: rename the file to file~, write a new file, remove file~)
+//   - writes to a node that's moved or removed later in the burst are dropped
+//
+// saves are a single write that replaces the node (see takeOverChain) so it keeps its chain
+func coalesce(events []*NodeEvent) []*NodeEvent {
+	type move struct {
+		rename int // index of the rename
+		create int // index of the create that finalized it
+	}
+	kept := make([]*NodeEvent, 0, len(events))
+	dropped := make([]bool, 0, len(events))
+	drop := func(idxs []int) {
+		for _, idx := range idxs {
+			dropped[idx] = true
+		}
+	}
+	// the node (by inode) last seen at a full path
+	// nodes we didn't get an inode for get a made up one
+	inos := make(map[string]uint64)
+	madeUp := uint64(0)
+	nodeAt := func(path string) uint64 {
+		if ino, ok := inos[path]; ok {
+			return ino
+		}
+		madeUp++
+		inos[path] = 1<<63 | madeUp
+		return inos[path]
+	}
+	// an event that reads the state of a node was kept
+	read := make(map[uint64]bool)
+	// a chmod for a node was kept
+	chmodded := make(map[uint64]bool)
+	// indexes of the events for a node that go if the node does
+	// (its writes, and its create if it was born in the burst)
+	nodeIdxs := make(map[uint64][]int)
+	// nodes born in the burst
+	born := make(map[uint64]bool)
+	// the move that brought a node to where it is in the burst
+	moves := make(map[uint64]move)
+	forget := func(ino uint64) {
+		delete(read, ino)
+		delete(chmodded, ino)
+		delete(nodeIdxs, ino)
+		delete(born, ino)
+		delete(moves, ino)
+	}
+	// a save that replaces the node at the event's path, it's never dropped w/ the node's other events
+	save := func(idx int) {
+		saved := *kept[idx]
+		saved.Type = Write
+		saved.replaces = true
+		kept[idx] = &saved
+	}
+	// the previous event, a create right after a rename is the other half of a move
+	var prev *NodeEvent
+	prevIdx := -1
+	renamed := uint64(0) // the node the previous event renamed
+
+	for _, nodeEvent := range events {
+		idx := len(kept)
+		path := nodeEvent.FullPath
+		keep := true
+		saves := false
+		switch nodeEvent.Type {
+		case Write:
+			ino := nodeEvent.ino
+			if ino == 0 {
+				ino = nodeAt(path)
+			}
+			inos[path] = ino
+			if read[ino] {
+				keep = false
+			} else {
+				nodeIdxs[ino] = append(nodeIdxs[ino], idx)
+				read[ino] = true
+			}
+		case Chmod:
+			ino := nodeEvent.ino
+			if ino == 0 {
+				ino = nodeAt(path)
+			}
+			inos[path] = ino
+			if read[ino] || chmodded[ino] {
+				keep = false
+			} else {
+				nodeIdxs[ino] = append(nodeIdxs[ino], idx)
+				chmodded[ino] = true
+			}
+		case Create:
+			ino := nodeEvent.ino
+			afterRename := prev != nil && prev.Type == Rename
+			if ino == 0 && afterRename {
+				ino = renamed
+			} else if ino == 0 {
+				delete(inos, path)
+				ino = nodeAt(path)
+			}
+			inos[path] = ino
+			if afterRename && prevIdx >= 0 {
+				moves[ino] = move{rename: prevIdx, create: idx}
+			} else if afterRename && born[ino] {
+				// a node born in the burst moved here (its events were dropped), it's a save
+				born[ino] = false
+				delete(nodeIdxs, ino)
+				saves = true
+			} else {
+				// new (or reusing the inode of a node that's gone)
+				forget(ino)
+				born[ino] = true
+				nodeIdxs[ino] = []int{idx}
+			}
+			read[ino] = true
+		case Rename:
+			ino := nodeAt(path)
+			// the node's state is read again where it ends up
+			drop(nodeIdxs[ino])
+			delete(nodeIdxs, ino)
+			delete(read, ino)
+			delete(chmodded, ino)
+			if born[ino] {
+				keep = false
+			}
+			delete(inos, path)
+			renamed = ino
+		case Remove:
+			ino := nodeAt(path)
+			drop(nodeIdxs[ino])
+			if born[ino] {
+				keep = false
+			} else if m, ok := moves[ino]; ok {
+				oldPath := kept[m.rename].FullPath
+				if other, ok := inos[oldPath]; ok && born[other] && len(nodeIdxs[other]) > 0 {
+					// a node born in the burst took the moved node's place
+					dropped[m.rename] = true
+					save(nodeIdxs[other][0])
+					born[other] = false
+					delete(nodeIdxs, other)
+				} else {
+					// remove the node from where it was before the move
+					removed := *kept[m.rename]
+					removed.Type = Remove
+					kept[m.rename] = &removed
+				}
+				dropped[m.create] = true
+				keep = false
+			}
+			forget(ino)
+			delete(inos, path)
+		}
+		prev = nodeEvent
+		prevIdx = -1
+		if keep {
+			kept = append(kept, nodeEvent)
+			dropped = append(dropped, false)
+			prevIdx = idx
+		}
+		if saves {
+			save(idx)
+		}
+	}
+
+	coalesced := make([]*NodeEvent, 0, len(kept))
+	for i := range kept {
+		if !dropped[i] {
+			coalesced = append(coalesced, kept[i])
+		}
+	}
+	return coalesced
+}
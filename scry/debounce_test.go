@@ -0,0 +1,168 @@
+package scry
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// make node events w/ the inodes a filesystem would give them
+// a path ending in "!" was gone by the time its event was received (so it has no inode)
+// paths are in dir "d" unless they're prefixed w/ another one (e.g. "e:a")
+func nodeEvents(events ...string) []*NodeEvent {
+	types := map[byte]EventType{'c': Create, 'w': Write, 'r': Rename, 'x': Remove, 'm': Chmod}
+	nodeEvents := make([]*NodeEvent, len(events))
+	inos := make(map[string]uint64)
+	nextIno := uint64(1)
+	inoAt := func(path string) uint64 {
+		if _, ok := inos[path]; !ok {
+			inos[path] = nextIno
+			nextIno++
+		}
+		return inos[path]
+	}
+	renamed := uint64(0)
+	for i, e := range events {
+		relPath, gone := strings.CutSuffix(e[2:], "!")
+		dirPath, relPath, ok := strings.Cut(relPath, ":")
+		if !ok {
+			dirPath, relPath = "d", dirPath
+		}
+		path := dirPath + "/" + relPath
+		nodeEvent := &NodeEvent{Type: types[e[0]], FullPath: path, Path: relPath, dir: &Dir{Path: dirPath}}
+		switch nodeEvent.Type {
+		case Create:
+			if i > 0 && nodeEvents[i-1].Type == Rename {
+				inos[path] = renamed
+			} else {
+				delete(inos, path)
+			}
+			nodeEvent.ino = inoAt(path)
+		case Write, Chmod:
+			nodeEvent.ino = inoAt(path)
+		case Rename:
+			renamed = inoAt(path)
+			delete(inos, path)
+		case Remove:
+			delete(inos, path)
+		}
+		if gone {
+			nodeEvent.ino = 0
+		}
+		nodeEvents[i] = nodeEvent
+	}
+	return nodeEvents
+}
+
+// writes that replace the node are "save"s, paths not in dir "d" are prefixed w/ their dir
+func eventStrings(nodeEvents []*NodeEvent) []string {
+	strs := make([]string, len(nodeEvents))
+	for i, e := range nodeEvents {
+		path := e.Path
+		if e.dir.Path != "d" {
+			path = e.dir.Path + ":" + path
+		}
+		strs[i] = fmt.Sprintf("%s %s", e.Type, path)
+		if e.replaces {
+			strs[i] = fmt.Sprintf("save %s", path)
+		}
+	}
+	return strs
+}
+
+func TestCoalesce(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []*NodeEvent
+		wanted []string
+	}{
+		{
+			"writes after a create",
+			nodeEvents("c a", "w a", "w a", "w b", "w b"),
+			[]string{"create a", "write b"},
+		},
+		{
+			"temp file",
+			nodeEvents("c 4913", "w 4913", "x 4913", "w a"),
+			[]string{"write a"},
+		},
+		{
+			"atomic save",
+			nodeEvents("c a.tmp", "w a.tmp", "r a.tmp", "c a"),
+			[]string{"save a"},
+		},
+		{
+			"atomic save then remove",
+			nodeEvents("c a.tmp", "w a.tmp", "r a.tmp", "c a", "w a", "x a"),
+			[]string{"save a", "remove a"},
+		},
+		{
+			"vim backup",
+			nodeEvents("r a", "c a~", "c a", "w a", "x a~"),
+			[]string{"save a"},
+		},
+		{
+			"vim backup gone before it's seen",
+			nodeEvents("r a", "c a~!", "c a", "w a", "x a~"),
+			[]string{"save a"},
+		},
+		{
+			"moved away then replaced",
+			nodeEvents("r a", "c b", "c a", "x b"),
+			[]string{"save a"},
+		},
+		{
+			"moved away and removed",
+			nodeEvents("r a", "c b", "x b", "c a"),
+			[]string{"remove a", "create a"},
+		},
+		{
+			"moves are kept",
+			nodeEvents("w a", "r a", "c b", "w b", "r b", "c c"),
+			[]string{"rename a", "create b", "rename b", "create c"},
+		},
+		{
+			"moved twice then removed",
+			nodeEvents("r a", "c b", "r b", "c c", "x c"),
+			[]string{"rename a", "create b", "remove b"},
+		},
+		{
+			"chmods",
+			nodeEvents("c a", "m a", "m b", "m b", "w b", "m b", "m c", "x c"),
+			[]string{"create a", "chmod b", "write b", "remove c"},
+		},
+		{
+			"writes to a node by inode",
+			nodeEvents("w a", "r a", "c b", "w b", "c a", "w a", "w b"),
+			[]string{"rename a", "create b", "create a"},
+		},
+		{
+			"writes before a remove",
+			nodeEvents("w a", "w a", "x a", "c a"),
+			[]string{"remove a", "create a"},
+		},
+		{
+			"same path in two dirs",
+			nodeEvents("c a", "x e:a", "w a", "w e:b", "x b", "m a"),
+			[]string{"create a", "remove e:a", "write e:b", "remove b"},
+		},
+		{
+			"write then remove of the same path in another dir",
+			nodeEvents("w a", "x e:a"),
+			[]string{"write a", "remove e:a"},
+		},
+		{
+			"moved between dirs then replaced",
+			nodeEvents("r a", "c e:a", "c a", "x e:a"),
+			[]string{"save a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wanted, eventStrings(coalesce(tt.events)))
+		})
+	}
+}
@@ -21,6 +21,10 @@ type NodeEvent struct {
 	State     *fnode.NodeState // node state pointer
 	doneTime  time.Time        // time the event finished processing
 	node      *fnode.Node      // node pointer
+	symlinks  SymlinkPolicy    // how the Dir scries symlinks
+	applied   bool             // the event was caused by a change the Applier made (so it wasn't stored)
+	ino       uint64           // inode of the node when the event was received (0 if it wasn't noted, see debouncer)
+	replaces  bool             // the node replaced the one at Path in an editor's save (see coalesce)
 	dir       *Dir             // stored Dir for this event
 	chain     *Chain           // stored Chain for this event
 }
@@ -50,20 +54,40 @@ func (n NodeEvent) ProcessingTime() time.Duration {
 }
 
 // process and store NodeEvent
-func processNodeEvent(nodeEvent *NodeEvent, store EventStore) error {
+func processNodeEvent(nodeEvent *NodeEvent, store EventStore, opts Options) error {
 	var err error
+	var newChainVersion VersionVector
 	// set node info on event
 	if err = setNode(nodeEvent); err != nil {
 		return errors.New(fmt.Sprintf("Failed to set node for: %+v", *nodeEvent))
 	}
+	// the events for applied changes are added by whoever applied them
+	if opts.Applier.suppresses(nodeEvent) {
+		logger.Debug(fmt.Sprintf("Suppressing event for applied change: %s %q", nodeEvent.Type, nodeEvent.Path))
+		nodeEvent.applied = true
+		return nil
+	}
 	// lookup chain for this event
 	if err = lkpChain(nodeEvent, store); err != nil {
 		return errors.New(fmt.Sprintf("Failed to find lookup Chain for event:  %v", nodeEvent))
 	}
+	// the node took the place of the one at its path, it keeps that node's chain
+	if nodeEvent.replaces {
+		if err = takeOverChain(nodeEvent, store); err != nil {
+			return errors.New(fmt.Sprintf("Failed to take over chain for event: %v\n%s", nodeEvent, err.Error()))
+		}
+	}
 	// ignore invalid events for now
 	if err := isValidEvent(nodeEvent); err != nil {
 		return err
 	}
+	// chmod events also fire for other attributes (e.g. times on touch), only keep the ones that change something
+	if nodeEvent.Type == Chmod {
+		changed, err := metaChanged(store, nodeEvent)
+		if err != nil || !changed {
+			return err
+		}
+	}
 	// add new chain for new nodes
 	if nodeEvent.Type == Create && nodeEvent.chain == nil {
 		// create new chain
@@ -74,17 +98,24 @@ func processNodeEvent(nodeEvent *NodeEvent, store EventStore) error {
 			os.Exit(1)
 		}
 		nodeEvent.chain = newChain
+		newChainVersion, err = GetPathVersion(store, nodeEvent.dir.ID, nodeEvent.Path)
+		if err != nil {
+			return err
+		}
 	}
 	// create new event to store
 	event := &Event{
 		Timestamp: nodeEvent.Timestamp,
 		Path:      nodeEvent.Path,
 		Type:      nodeEvent.Type,
+		Version:   newChainVersion,
 	}
 	// set event state from node
-	setEventState(event, nodeEvent.node)
+	setEventState(event, nodeEvent.node, statCache(store, opts))
+	// keep the content of this version
+	keepContent(opts.Blobs, event, nodeEvent.FullPath)
 	// add event to store
-	if err = store.AddEvent(event, nodeEvent.chain.ID); err != nil {
+	if err = AddLocalEvent(store, opts.NodeID, event, nodeEvent.chain.ID); err != nil {
 		logger.Fatal(err.Error())
 		os.Exit(1)
 	}
@@ -111,6 +142,8 @@ func toNodeEvent(event *fsnotify.Event) *NodeEvent {
 		nodeEvent.Type = Remove
 	case fsnotify.Rename:
 		nodeEvent.Type = Rename
+	case fsnotify.Chmod:
+		nodeEvent.Type = Chmod
 	default:
 		return nil
 	}
@@ -122,11 +155,14 @@ func toNodeEvent(event *fsnotify.Event) *NodeEvent {
 // the node is used to: detect new dirs, lookup chains by ino, set event state
 func setNode(nodeEvent *NodeEvent) error {
 	switch nodeEvent.Type {
-	case Create, Write:
+	case Create, Write, Chmod:
 		node, err := fnode.NewNode(nodeEvent.FullPath)
 		if err != nil {
 			return err
 		}
+		if node, err = applySymlinkPolicy(nodeEvent.symlinks, node); err != nil {
+			return err
+		}
 		nodeEvent.node = node
 	case Rename, Remove:
 	default:
@@ -143,7 +179,7 @@ func lkpChain(nodeEvent *NodeEvent, store EventStore) error {
 	var err error
 
 	switch nodeEvent.Type {
-	case Create, Write:
+	case Create, Write, Chmod:
 		chain, err = store.GetChainByIno(nodeEvent.node.Ino)
 	case Remove, Rename:
 		chain, err = store.GetChainByPath(nodeEvent.dir.ID, nodeEvent.Path)
@@ -157,6 +193,27 @@ func lkpChain(nodeEvent *NodeEvent, store EventStore) error {
 	return nil
 }
 
+// takeOverChain links the chain of the node at the event's path to the event's node (which replaced it)
+// if there's no node at the path the event's node is new after all and gets a chain of its own
+func takeOverChain(nodeEvent *NodeEvent, store EventStore) error {
+	chain, err := store.GetChainByPath(nodeEvent.dir.ID, nodeEvent.Path)
+	if err != nil {
+		return err
+	}
+	if chain == nil {
+		nodeEvent.Type = Create
+		return nil
+	}
+	if chain.Ino != nodeEvent.node.Ino {
+		if err = store.SetChainIno(chain.ID, nodeEvent.node.Ino); err != nil {
+			return err
+		}
+		chain.Ino = nodeEvent.node.Ino
+	}
+	nodeEvent.chain = chain
+	return nil
+}
+
 // isValidEvent ensures dirEvent is properly initialized for processing
 func isValidEvent(nodeEvent *NodeEvent) error {
 	node := nodeEvent.node
@@ -169,15 +226,15 @@ func isValidEvent(nodeEvent *NodeEvent) error {
 		if node.Type() < 1 {
 			return errors.New(fmt.Sprintf("create unsupported node: %v", node))
 		}
-	case Write:
+	case Write, Chmod:
 		if node == nil {
-			return errors.New("write w/ no node")
+			return errors.New(fmt.Sprintf("%s w/ no node", nodeEvent.Type))
 		}
 		if node.Type() < 1 {
-			return errors.New(fmt.Sprintf("write unsupported node: %v", node))
+			return errors.New(fmt.Sprintf("%s unsupported node: %v", nodeEvent.Type, node))
 		}
 		if chain == nil {
-			return errors.New(fmt.Sprintf("write w/ no chain"))
+			return errors.New(fmt.Sprintf("%s w/ no chain", nodeEvent.Type))
 		}
 	case Rename:
 		if chain == nil {
@@ -193,20 +250,85 @@ func isValidEvent(nodeEvent *NodeEvent) error {
 	return nil
 }
 
-func setEventState(event *Event, node *fnode.Node) {
+func setEventState(event *Event, node *fnode.Node, cache fnode.StatCache) {
 	switch event.Type {
-	case Create:
+	case Create, Write, Chmod:
 		// set event node state props
-		nodeState := node.State()
-		event.ModTime = node.ModTime()
-		event.Size = node.Size()
-		event.Hash = nodeState.Hash
-	case Write:
-		// set event node state props
-		nodeState := node.State()
+		nodeState := node.CachedState(cache)
 		event.ModTime = node.ModTime()
 		event.Size = node.Size()
 		event.Hash = nodeState.Hash
+		event.Mode = nodeState.Mode
+		event.Uid = nodeState.Uid
+		event.Gid = nodeState.Gid
+		event.Target = nodeState.Target
+		event.Chunks = nodeState.Chunks
 	default:
 	}
 }
+
+// statCache returns where the hashes of unchanged files are looked up
+// it's nil in paranoid mode so files are always hashed
+func statCache(store EventStore, opts Options) fnode.StatCache {
+	if opts.Paranoid {
+		return nil
+	}
+	return store
+}
+
+// metaChanged tells if a node's mode or ownership differ from the last state stored for it
+func metaChanged(store EventStore, nodeEvent *NodeEvent) (bool, error) {
+	events, err := store.GetEventsInChain(nodeEvent.chain.ID)
+	if err != nil {
+		return false, err
+	}
+	for i := len(events) - 1; i >= 0; i-- {
+		if hasState(&events[i]) {
+			return !sameMeta(&events[i], nodeEvent.node), nil
+		}
+	}
+	return true, nil
+}
+
+// hasState tells if an event records the state of its node
+func hasState(event *Event) bool {
+	return event.Type == Create || event.Type == Write || event.Type == Chmod
+}
+
+func sameMeta(event *Event, node *fnode.Node) bool {
+	return event.Mode == node.Mode() && event.Uid == node.Uid && event.Gid == node.Gid
+}
+
+// keepContent adds the content of a file event to the blob store (if there is one)
+// failing to is logged but not fatal, the file likely changed and we'll get another event for it
+func keepContent(blobs BlobStore, event *Event, path string) {
+	if blobs == nil || event.Hash == nil {
+		return
+	}
+	var err error
+	if len(event.Chunks) > 0 {
+		err = blobs.AddFileChunks(*event.Hash, event.Chunks, path)
+	} else {
+		err = blobs.AddFile(*event.Hash, path)
+	}
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Failed to keep content of %q:\n%s", path, err.Error()))
+	}
+}
+
+// AddLocalEvent stamps an event that happened on this node w/ its origin and version then stores it
+// the version follows the most recent event in the chain w/ this node's counter bumped
+// the first event in a chain follows the event's Version if it's set (see GetPathVersion)
+func AddLocalEvent(store EventStore, nodeID string, event *Event, chainID ID) error {
+	tail, err := store.GetChainTail(chainID)
+	if err != nil {
+		return err
+	}
+	version := event.Version
+	if tail != nil {
+		version = tail.Version
+	}
+	event.Origin = nodeID
+	event.Version = version.Bump(nodeID)
+	return store.AddEvent(event, chainID)
+}
@@ -0,0 +1,149 @@
+package scry
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/ceejimus/kusari/logger"
+	"github.com/gobwas/glob"
+)
+
+// gitignore-style files that can be put in any dir under a scried dir
+// the patterns in one apply to the nodes under the dir it's in
+const IGNORE_FILE = ".kusariignore"
+
+// a pattern from an ignore file
+type ignoreRule struct {
+	glob     glob.Glob
+	negate   bool // re-includes nodes an earlier pattern ignored
+	dirOnly  bool // only matches dirs
+	anchored bool // matched against the path relative to the ignore file's dir instead of the node's name
+}
+
+func (r *ignoreRule) matches(relPath string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if !r.anchored {
+		relPath = path.Base(relPath)
+	}
+	return r.glob.Match(relPath)
+}
+
+// parseIgnore parses the patterns in an ignore file (following gitignore)
+//
+//   - blank lines and lines starting w/ # are skipped
+//   - a leading ! negates the pattern
+//   - a trailing / only matches dirs
+//   - a / at the start or in the middle anchors the pattern to the ignore file's dir,
+//     otherwise it matches the name of nodes at any depth
+//   - * and ? don't match /, ** matches any number of dirs
+//
+// invalid patterns are logged and skipped
+func parseIgnore(data string) []ignoreRule {
+	rules := make([]ignoreRule, 0)
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		// trailing spaces are dropped unless escaped
+		for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+			line = line[:len(line)-1]
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := ignoreRule{}
+		pattern := line
+		if strings.HasPrefix(pattern, "!") {
+			rule.negate = true
+			pattern = pattern[1:]
+		}
+		if strings.HasSuffix(pattern, "/") {
+			rule.dirOnly = true
+			pattern = strings.TrimRight(pattern, "/")
+		}
+		if strings.Contains(pattern, "/") {
+			rule.anchored = true
+			pattern = strings.TrimPrefix(pattern, "/")
+		}
+		if pattern == "" {
+			continue
+		}
+		g, err := glob.Compile(toGlob(pattern), '/')
+		if err != nil {
+			logger.Warn(fmt.Sprintf("Skipping invalid ignore pattern %q:\n%s", line, err.Error()))
+			continue
+		}
+		rule.glob = g
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// toGlob turns a gitignore pattern into a glob (w/ / as the separator)
+// ** can match no dirs at all so "a/**/b" matches "a/b"
+func toGlob(pattern string) string {
+	// braces aren't special in ignore files
+	pattern = strings.NewReplacer("{", "\\{", "}", "\\}").Replace(pattern)
+	if strings.HasPrefix(pattern, "**/") {
+		pattern = "{,**/}" + pattern[3:]
+	}
+	return strings.ReplaceAll(pattern, "/**/", "{/,/**/}")
+}
+
+// ignoreFiles reads and caches the ignore files under a scried dir
+// files are read the first time they're needed, forget makes the next lookup read them again
+type ignoreFiles struct {
+	root  string                  // full path to the scried dir
+	rules map[string][]ignoreRule // by the dir (relative to root) the file is in, nil if there's no file
+}
+
+func newIgnoreFiles(root string) *ignoreFiles {
+	return &ignoreFiles{root: root, rules: make(map[string][]ignoreRule)}
+}
+
+// load returns the patterns in the ignore file in a dir (relative to the scried dir)
+func (f *ignoreFiles) load(relDirPath string) []ignoreRule {
+	if rules, ok := f.rules[relDirPath]; ok {
+		return rules
+	}
+	var rules []ignoreRule
+	data, err := os.ReadFile(filepath.Join(f.root, relDirPath, IGNORE_FILE))
+	if err == nil {
+		rules = parseIgnore(string(data))
+		logger.Debug(fmt.Sprintf("Loaded %d ignore patterns for %q", len(rules), filepath.Join(f.root, relDirPath)))
+	} else if !os.IsNotExist(err) {
+		logger.Warn(fmt.Sprintf("Failed to read ignore file in %q:\n%s", filepath.Join(f.root, relDirPath), err.Error()))
+	}
+	f.rules[relDirPath] = rules
+	return rules
+}
+
+// forget drops the cached patterns for a dir (relative to the scried dir)
+func (f *ignoreFiles) forget(relDirPath string) {
+	delete(f.rules, relDirPath)
+}
+
+// ignores tells if the node at relPath (relative to the scried dir) is ignored
+// the ignore files in the dirs above the node are checked from the top down and the last matching pattern wins,
+// so deeper files override the ones above them
+// it doesn't check the dirs the node is in, nothing in an ignored dir can be re-included (see nodeFilter)
+func (f *ignoreFiles) ignores(relPath string, isDir bool) bool {
+	parts := strings.Split(filepath.ToSlash(filepath.Clean(relPath)), "/")
+	ignored := false
+	for i := range parts {
+		dir := "."
+		if i > 0 {
+			dir = filepath.Join(parts[:i]...)
+		}
+		nodePath := strings.Join(parts[i:], "/")
+		for _, rule := range f.load(dir) {
+			if rule.matches(nodePath, isDir) {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored
+}
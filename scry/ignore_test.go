@@ -0,0 +1,88 @@
+package scry
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIgnoreRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns string
+		path     string
+		isDir    bool
+		ignored  bool
+	}{
+		{"name at any depth", "*.log", "a/b/c.log", false, true},
+		{"star doesn't cross dirs", "a/*.log", "a/b/c.log", false, false},
+		{"anchored", "/c.log", "a/c.log", false, false},
+		{"anchored at top", "/c.log", "c.log", false, true},
+		{"slash in middle anchors", "a/c.log", "b/a/c.log", false, false},
+		{"dir only", "build/", "build", false, false},
+		{"dir only matches dirs", "build/", "x/build", true, true},
+		{"negated", "*.log\n!keep.log", "keep.log", false, false},
+		{"last match wins", "!keep.log\n*.log", "keep.log", false, true},
+		{"leading double star", "**/tmp", "tmp", true, true},
+		{"leading double star nested", "**/tmp", "a/b/tmp", true, true},
+		{"middle double star", "a/**/b", "a/b", false, true},
+		{"middle double star nested", "a/**/b", "a/x/y/b", false, true},
+		{"trailing double star", "a/**", "a/x/y", false, true},
+		{"comments and blanks", "# *.log\n\n", "c.log", false, false},
+		{"escaped hash", "\\#c", "#c", false, true},
+		{"braces are literal", "{a,b}", "a", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			if err := os.WriteFile(filepath.Join(root, IGNORE_FILE), []byte(tt.patterns), 0644); err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, tt.ignored, newIgnoreFiles(root).ignores(tt.path, tt.isDir))
+		})
+	}
+}
+
+// test nested ignore files override the ones above them and only apply below them
+func TestNestedIgnoreFiles(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "a", "b"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		IGNORE_FILE:                     "*.log\n",
+		filepath.Join("a", IGNORE_FILE): "!keep.log\n/top.txt\n",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(filepath.Join(root, path), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ignores := newIgnoreFiles(root)
+	assert.True(t, ignores.ignores("keep.log", false))
+	assert.False(t, ignores.ignores("a/keep.log", false))
+	assert.False(t, ignores.ignores("a/b/keep.log", false))
+	assert.True(t, ignores.ignores("a/b/other.log", false))
+	assert.True(t, ignores.ignores("a/top.txt", false))
+	assert.False(t, ignores.ignores("a/b/top.txt", false))
+	assert.False(t, ignores.ignores("top.txt", false))
+
+	// changes are seen once the file's forgotten
+	if err := os.WriteFile(filepath.Join(root, IGNORE_FILE), []byte(""), 0644); err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, ignores.ignores("keep.log", false))
+	ignores.forget(".")
+	assert.False(t, ignores.ignores("keep.log", false))
+
+	// nothing in an ignored dir can be re-included
+	if err := os.WriteFile(filepath.Join(root, IGNORE_FILE), []byte("a/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	filter := newNodeFilter(filepath.Dir(root), ScriedDirectory{Path: filepath.Base(root)})
+	assert.False(t, filter.matches("a/keep.log", false))
+	assert.True(t, filter.matches("keep.log", false))
+}
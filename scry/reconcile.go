@@ -0,0 +1,301 @@
+package scry
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ceejimus/kusari/fnode"
+	"github.com/ceejimus/kusari/logger"
+)
+
+// ReconcileDirs brings the stored dirs in line w/ the configured ones
+// configured dirs that aren't stored are added (Reconcile scans what's in them)
+// stored dirs that aren't configured anymore are inactive, they aren't scried or synced
+// their history is kept in case they're configured again unless purge is set
+// returns the dirs added and the dirs that are inactive (or were purged)
+func ReconcileDirs(store EventStore, scryDirs []ScriedDirectory, purge bool) (added []Dir, inactive []Dir, err error) {
+	configured := make(map[string]bool, len(scryDirs))
+	for _, scryDir := range scryDirs {
+		configured[scryDir.Path] = true
+		dir, err := store.GetDirByPath(scryDir.Path)
+		if err != nil {
+			return nil, nil, errors.New(fmt.Sprintf("Failed to lookup dir %q:\n%s", scryDir.Path, err.Error()))
+		}
+		if dir != nil { // already tracking this one
+			continue
+		}
+		dir = &Dir{Path: scryDir.Path}
+		if err = store.AddDir(dir); err != nil {
+			return nil, nil, errors.New(fmt.Sprintf("Failed to add dir to event store:\n%s", err.Error()))
+		}
+		added = append(added, *dir)
+	}
+
+	dirs, err := store.GetDirs()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, dir := range dirs {
+		if configured[dir.Path] {
+			continue
+		}
+		if purge {
+			if err = store.RemoveDir(dir.ID); err != nil {
+				return nil, nil, errors.New(fmt.Sprintf("Failed to purge dir %q:\n%s", dir.Path, err.Error()))
+			}
+		}
+		inactive = append(inactive, dir)
+	}
+	return added, inactive, nil
+}
+
+// GetScriedDirs gets the stored dirs for the configured dirs (in the same order)
+// it errors if any of them aren't stored (see ReconcileDirs)
+func GetScriedDirs(store EventStore, scryDirs []ScriedDirectory) ([]Dir, error) {
+	dirs := make([]Dir, len(scryDirs))
+	for i, scryDir := range scryDirs {
+		dir, err := store.GetDirByPath(scryDir.Path)
+		if err != nil {
+			return nil, err
+		}
+		if dir == nil {
+			return nil, errors.New(fmt.Sprintf("No stored dir w/ path %q", scryDir.Path))
+		}
+		dirs[i] = *dir
+	}
+	return dirs, nil
+}
+
+// Reconcile brings the stored chains for a scried directory in line w/ what's on disk
+// anything that happened while we weren't watching (creates, writes, moves, removes)
+// is added to the store as "synthesized" events
+//
+// the Dir must already exist in the store
+func Reconcile(topDir string, scryDir ScriedDirectory, store EventStore, opts Options) error {
+	dir, err := store.GetDirByPath(scryDir.Path)
+	if err != nil {
+		return err
+	}
+	if dir == nil {
+		return errors.New(fmt.Sprintf("Cannot reconcile, no stored dir w/ path %q", scryDir.Path))
+	}
+	// get what's on disk
+	nodes, err := GetScriedNodes(topDir, scryDir)
+	if err != nil {
+		return err
+	}
+	dirPath := filepath.Join(topDir, scryDir.Path)
+	// get what's in the store
+	tracked, err := getTrackedNodes(store, dir.ID, time.Time{})
+	if err != nil {
+		return err
+	}
+	// index stored nodes by ino, if an ino shows up twice the most recent chain wins
+	// (the older ones were replaced w/o us seeing a remove so we leave them be)
+	storedByIno := make(map[uint64]*ChainState, len(tracked))
+	for _, t := range tracked {
+		if t.Removed {
+			continue
+		}
+		if other, ok := storedByIno[t.Chain.Ino]; ok && other.Timestamp.After(t.Timestamp) {
+			continue
+		}
+		storedByIno[t.Chain.Ino] = t
+	}
+	// match the nodes on disk to the stored nodes
+	byIno := make(map[uint64]*ChainState, len(nodes))
+	for i := range nodes {
+		t := storedByIno[nodes[i].Ino]
+		if t == nil {
+			continue
+		}
+		relPath := fnode.GetRelativePath(nodes[i].Path, dirPath)
+		if !isSameNode(t, &nodes[i], relPath, statCache(store, opts)) {
+			continue
+		}
+		byIno[nodes[i].Ino] = t
+	}
+	removed := make([]*ChainState, 0)
+	for _, t := range tracked {
+		if !t.Removed && byIno[t.Chain.Ino] != t {
+			removed = append(removed, t)
+		}
+	}
+
+	// remove nodes that are gone - deepest first
+	sort.SliceStable(removed, func(i, j int) bool {
+		return pathDepth(removed[i].Path) > pathDepth(removed[j].Path)
+	})
+	for _, t := range removed {
+		// only remove nodes the store still finds at their path
+		// otherwise we'd clobber the node that replaced them
+		chain, err := store.GetChainByPath(dir.ID, t.Path)
+		if err != nil {
+			return err
+		}
+		if chain == nil || string(chain.ID.Encode()) != string(t.Chain.ID.Encode()) {
+			continue
+		}
+		logger.Debug(fmt.Sprintf("Reconcile remove %q", t.Path))
+		if err = addSynthEvent(store, opts.NodeID, &Event{Path: t.Path, Type: Remove}, t.Chain.ID); err != nil {
+			return err
+		}
+	}
+
+	// walk the nodes on disk shallowest first so parents exist before children
+	// at each depth moves happen before creates so re-used paths end up on the new node
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return pathDepth(nodes[i].Path) < pathDepth(nodes[j].Path)
+	})
+	for start := 0; start < len(nodes); {
+		end := start
+		for end < len(nodes) && pathDepth(nodes[end].Path) == pathDepth(nodes[start].Path) {
+			end++
+		}
+		level := nodes[start:end]
+		// moves and writes for the nodes we know about
+		for i := range level {
+			t := byIno[level[i].Ino]
+			if t == nil {
+				continue
+			}
+			relPath := fnode.GetRelativePath(level[i].Path, dirPath)
+			if err = reconcileTracked(store, opts, tracked, t, &level[i], relPath); err != nil {
+				return err
+			}
+		}
+		// creates for the nodes we don't
+		for i := range level {
+			if byIno[level[i].Ino] != nil {
+				continue
+			}
+			relPath := fnode.GetRelativePath(level[i].Path, dirPath)
+			logger.Debug(fmt.Sprintf("Reconcile create %q", relPath))
+			chain := &Chain{Ino: level[i].Ino}
+			if err = store.AddChain(chain, dir.ID); err != nil {
+				return err
+			}
+			event := &Event{Path: relPath, Type: Create, Version: pathVersion(tracked, relPath)}
+			setEventState(event, &level[i], statCache(store, opts))
+			keepContent(opts.Blobs, event, level[i].Path)
+			if err = addSynthEvent(store, opts.NodeID, event, chain.ID); err != nil {
+				return err
+			}
+		}
+		start = end
+	}
+
+	return nil
+}
+
+// isSameNode guesses if a node on disk is the one the store tracks under the same ino
+// inodes get re-used so a node that moved and changed is treated as a new node
+func isSameNode(t *ChainState, node *fnode.Node, relPath string, cache fnode.StatCache) bool {
+	if !t.Moved && t.Path == relPath {
+		return true
+	}
+	if t.State == nil {
+		return false
+	}
+	// symlinks are the same if they point to the same place
+	if node.Type() == fnode.SYMLINK {
+		target, err := node.Target()
+		return err == nil && t.State.Hash == nil && t.State.Target == target
+	}
+	// only files have hashes
+	if node.Type() != fnode.FILE {
+		return t.State.Hash == nil && t.State.Target == ""
+	}
+	state := node.CachedState(cache)
+	return t.State.Size == state.Size && t.State.ModTime.Equal(state.ModTime) && eqNodeHash(t.State.Hash, node, state.Hash)
+}
+
+// reconcileTracked adds rename and write events for a known node
+func reconcileTracked(store EventStore, opts Options, tracked map[string]*ChainState, t *ChainState, node *fnode.Node, relPath string) error {
+	cache := statCache(store, opts)
+	if t.Moved || t.Path != relPath { // the node was moved
+		logger.Debug(fmt.Sprintf("Reconcile move %q -> %q", t.Path, relPath))
+		// we may have seen the rename but not where the node ended up
+		if !t.Moved {
+			if err := addSynthEvent(store, opts.NodeID, &Event{Path: t.Path, Type: Rename}, t.Chain.ID); err != nil {
+				return err
+			}
+		}
+		event := &Event{Path: relPath, Type: Create}
+		setEventState(event, node, cache)
+		keepContent(opts.Blobs, event, node.Path)
+		if err := addSynthEvent(store, opts.NodeID, event, t.Chain.ID); err != nil {
+			return err
+		}
+		movePrefix(tracked, t.Path, relPath)
+		return nil
+	}
+	// a symlink was pointed somewhere else w/o being replaced (e.g. its inode was re-used)
+	if node.Type() == fnode.SYMLINK {
+		if target, err := node.Target(); err == nil && t.State != nil && t.State.Target != target {
+			logger.Debug(fmt.Sprintf("Reconcile retarget %q", relPath))
+			event := &Event{Path: relPath, Type: Write}
+			setEventState(event, node, cache)
+			return addSynthEvent(store, opts.NodeID, event, t.Chain.ID)
+		}
+	}
+	// we only record writes for files
+	if node.Type() != fnode.FILE {
+		return reconcileMeta(store, opts, t, node, relPath)
+	}
+	state := node.CachedState(cache)
+	if t.State != nil && t.State.Size == state.Size && t.State.ModTime.Equal(state.ModTime) && eqNodeHash(t.State.Hash, node, state.Hash) {
+		// make sure we've kept the current version (e.g. the blob store is new)
+		keepContent(opts.Blobs, t.State, node.Path)
+		return reconcileMeta(store, opts, t, node, relPath)
+	}
+	logger.Debug(fmt.Sprintf("Reconcile write %q", relPath))
+	event := &Event{Path: relPath, Type: Write}
+	setEventState(event, node, cache)
+	keepContent(opts.Blobs, event, node.Path)
+	return addSynthEvent(store, opts.NodeID, event, t.Chain.ID)
+}
+
+// reconcileMeta adds a chmod event for a known node whose mode or ownership changed
+func reconcileMeta(store EventStore, opts Options, t *ChainState, node *fnode.Node, relPath string) error {
+	if t.State == nil || sameMeta(t.State, node) {
+		return nil
+	}
+	logger.Debug(fmt.Sprintf("Reconcile chmod %q", relPath))
+	event := &Event{Path: relPath, Type: Chmod}
+	setEventState(event, node, statCache(store, opts))
+	return addSynthEvent(store, opts.NodeID, event, t.Chain.ID)
+}
+
+func addSynthEvent(store EventStore, nodeID string, event *Event, chainID ID) error {
+	event.Timestamp = time.Now()
+	if err := AddLocalEvent(store, nodeID, event, chainID); err != nil {
+		return errors.New(fmt.Sprintf("Failed to add reconciled event %s:\n%s", event, err.Error()))
+	}
+	return nil
+}
+
+func pathDepth(path string) int {
+	return strings.Count(filepath.Clean(path), string(filepath.Separator))
+}
+
+// eqNodeHash tells if a node on disk (whose current hash is given) has the stored hash
+// hashes made w/ another algorithm (e.g. before sha256 was the default) are checked by rehashing the node
+func eqNodeHash(stored *string, node *fnode.Node, current *string) bool {
+	if stored == nil || current == nil {
+		return stored == current
+	}
+	if *stored == *current {
+		return true
+	}
+	algorithm, _, err := fnode.ParseHash(*stored)
+	if err != nil || algorithm == fnode.DEFAULT_HASH {
+		return false
+	}
+	ok, err := fnode.CheckFileHash(*stored, node.Path)
+	return err == nil && ok
+}
@@ -0,0 +1,62 @@
+package scry
+
+import (
+	"time"
+)
+
+// Retention says which events in a chain are kept when it's compacted
+// an event is kept if any rule keeps it, w/o any rules everything is kept
+type Retention struct {
+	KeepLast   int           `yaml:"keepLast"`   // the last N events of every chain
+	KeepWithin time.Duration `yaml:"keepWithin"` // events newer than this
+	KeepDaily  int           `yaml:"keepDaily"`  // the last event of each of the last N days
+}
+
+func (r Retention) IsZero() bool {
+	return r.KeepLast == 0 && r.KeepWithin == 0 && r.KeepDaily == 0
+}
+
+// Keep tells which of a chain's events (in order) the policy keeps as of now
+// pinned events are always kept (see IsPinned)
+func (r Retention) Keep(events []Event, now time.Time) []bool {
+	keep := make([]bool, len(events))
+	for i := range events {
+		keep[i] = r.IsZero() || IsPinned(events, i) ||
+			i >= len(events)-r.KeepLast ||
+			(r.KeepWithin > 0 && events[i].Timestamp.After(now.Add(-r.KeepWithin)))
+	}
+	if r.KeepDaily > 0 {
+		// the first day w/ a snapshot, days are in now's location
+		y, m, d := now.Date()
+		since := time.Date(y, m, d-(r.KeepDaily-1), 0, 0, 0, 0, now.Location())
+		lastOnDay := make(map[time.Time]int, r.KeepDaily)
+		for i, event := range events {
+			if event.Timestamp.Before(since) || event.Timestamp.After(now) {
+				continue
+			}
+			y, m, d := event.Timestamp.In(now.Location()).Date()
+			lastOnDay[time.Date(y, m, d, 0, 0, 0, 0, now.Location())] = i
+		}
+		for _, i := range lastOnDay {
+			keep[i] = true
+		}
+	}
+	return keep
+}
+
+// KeepAfter tells which of a chain's events (in order) happened at or after the given time
+// pinned events are always kept (see IsPinned)
+func KeepAfter(events []Event, before time.Time) []bool {
+	keep := make([]bool, len(events))
+	for i := range events {
+		keep[i] = IsPinned(events, i) || !events[i].Timestamp.Before(before)
+	}
+	return keep
+}
+
+// IsPinned tells if the event at i in a chain can't be pruned
+// the tail says where the node is (and what it was)
+// and finalized moves carry along the nodes under it when the dir is replayed
+func IsPinned(events []Event, i int) bool {
+	return i == len(events)-1 || (events[i].Type == Create && events[i].OldPath != nil)
+}
@@ -0,0 +1,60 @@
+package scry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetentionKeep(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	events := []Event{
+		{Timestamp: time.Date(2026, 3, 8, 10, 0, 0, 0, time.UTC), Type: Create},
+		{Timestamp: time.Date(2026, 3, 9, 9, 0, 0, 0, time.UTC), Type: Write},
+		{Timestamp: time.Date(2026, 3, 9, 18, 0, 0, 0, time.UTC), Type: Write},
+		{Timestamp: time.Date(2026, 3, 10, 8, 0, 0, 0, time.UTC), Type: Write},
+		{Timestamp: time.Date(2026, 3, 10, 11, 0, 0, 0, time.UTC), Type: Write},
+		{Timestamp: time.Date(2026, 3, 10, 11, 30, 0, 0, time.UTC), Type: Write},
+	}
+
+	tests := []struct {
+		name      string
+		retention Retention
+		wanted    []bool
+	}{
+		{"no rules", Retention{}, []bool{true, true, true, true, true, true}},
+		{"last", Retention{KeepLast: 2}, []bool{false, false, false, false, true, true}},
+		{"more than there are", Retention{KeepLast: 10}, []bool{true, true, true, true, true, true}},
+		{"within", Retention{KeepWithin: 5 * time.Hour}, []bool{false, false, false, true, true, true}},
+		{"daily", Retention{KeepDaily: 2}, []bool{false, false, true, false, false, true}},
+		{"daily and last", Retention{KeepDaily: 3, KeepLast: 2}, []bool{true, false, true, false, true, true}},
+		{"tail is pinned", Retention{KeepWithin: time.Minute}, []bool{false, false, false, false, false, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wanted, tt.retention.Keep(events, now))
+		})
+	}
+}
+
+func TestKeepAfter(t *testing.T) {
+	t0 := time.Now().Add(-time.Hour)
+	at := func(minutes int) time.Time { return t0.Add(time.Duration(minutes) * time.Minute) }
+	oldPath := "f"
+	events := []Event{
+		{Timestamp: at(1), Path: "f", Type: Create},
+		{Timestamp: at(2), Path: "f", Type: Rename},
+		{Timestamp: at(3), Path: "g", Type: Create, OldPath: &oldPath},
+		{Timestamp: at(4), Path: "g", Type: Write},
+		{Timestamp: at(5), Path: "g", Type: Write},
+	}
+
+	assert.Equal(t, []bool{true, true, true, true, true}, KeepAfter(events, at(1)))
+	assert.Equal(t, []bool{false, false, true, true, true}, KeepAfter(events, at(3)))
+	// finalized moves and the tail are pinned
+	assert.Equal(t, []bool{false, false, true, false, true}, KeepAfter(events, at(10)))
+	assert.Equal(t, []bool{false, false, true, false, true}, Retention{KeepLast: 1}.Keep(events, at(10)))
+	assert.Empty(t, KeepAfter(nil, at(10)))
+}
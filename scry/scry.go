@@ -12,17 +12,128 @@ import (
 	"github.com/gobwas/glob"
 )
 
+// how symlinks in a ScriedDirectory are scried
+type SymlinkPolicy string
+
+const (
+	// symlinks are tracked (and synced) as links
+	SYMLINKS_PRESERVE SymlinkPolicy = "preserve"
+	// symlinks to files are tracked (and synced) as the file they point to
+	// changes to the file are picked up when the link's dir is reconciled (we don't watch the file)
+	// links to anything else are still preserved
+	SYMLINKS_FOLLOW SymlinkPolicy = "follow"
+)
+
 type ScriedDirectory struct {
-	Path    string   `yaml:"path"`
-	Include []string `yaml:"incl"`
-	Exclude []string `yaml:"excl"`
+	Path     string        `yaml:"path"`
+	Include  []string      `yaml:"incl"`
+	Exclude  []string      `yaml:"excl"`
+	Symlinks SymlinkPolicy `yaml:"symlinks"` // defaults to SYMLINKS_PRESERVE
+}
+
+func ParseSymlinkPolicy(s string) (SymlinkPolicy, error) {
+	switch SymlinkPolicy(s) {
+	case "":
+		return SYMLINKS_PRESERVE, nil
+	case SYMLINKS_PRESERVE, SYMLINKS_FOLLOW:
+		return SymlinkPolicy(s), nil
+	default:
+		return "", errors.New(fmt.Sprintf("Unknown symlink policy %q, must be %q or %q", s, SYMLINKS_PRESERVE, SYMLINKS_FOLLOW))
+	}
+}
+
+// newScriedNode makes the node for path following the dir's symlink policy
+func newScriedNode(scryDir ScriedDirectory, path string) (*fnode.Node, error) {
+	node, err := fnode.NewNode(path)
+	if err != nil {
+		return nil, err
+	}
+	return applySymlinkPolicy(scryDir.Symlinks, node)
+}
+
+func applySymlinkPolicy(policy SymlinkPolicy, node *fnode.Node) (*fnode.Node, error) {
+	if policy == SYMLINKS_FOLLOW {
+		return fnode.Follow(node)
+	}
+	return node, nil
+}
+
+// matches node paths (relative to a ScriedDirectory) against its include/exclude globs and ignore files
+// dirs are matched w/ a trailing slash so globs like ".git/" only match dirs
+// everything in an excluded dir is excluded
+type nodeFilter struct {
+	include []glob.Glob
+	exclude []glob.Glob
+	ignores *ignoreFiles
+}
+
+func newNodeFilter(topDir string, scryDir ScriedDirectory) *nodeFilter {
+	return &nodeFilter{
+		include: mapToGlobs(scryDir.Include),
+		exclude: mapToGlobs(scryDir.Exclude),
+		ignores: newIgnoreFiles(filepath.Join(topDir, scryDir.Path)),
+	}
+}
+
+// forgetIgnores makes the filter re-read the ignore file in a dir (relative to the scried dir)
+func (f *nodeFilter) forgetIgnores(relDirPath string) {
+	if f != nil {
+		f.ignores.forget(relDirPath)
+	}
+}
+
+// matches tells if the node at relPath should be scried
+func (f *nodeFilter) matches(relPath string, isDir bool) bool {
+	// check the dirs it's in
+	for dir := filepath.Dir(relPath); dir != "." && dir != "/"; dir = filepath.Dir(dir) {
+		if f.excludes(dir) {
+			return false
+		}
+	}
+	return f.matchesNode(relPath, isDir)
+}
+
+// matchesNode checks the node's own path, not the dirs it's in
+func (f *nodeFilter) matchesNode(relPath string, isDir bool) bool {
+	// the applier's temp nodes come and go
+	if isApplyTmp(relPath) {
+		return false
+	}
+	if f == nil {
+		return true
+	}
+	if f.ignores.ignores(strings.TrimSuffix(relPath, "/"), isDir) {
+		logger.Trace(fmt.Sprintf("Ignored - %v", relPath))
+		return false
+	}
+	// add trailing slash to directories so we can match on our directory globs
+	if isDir && !strings.HasSuffix(relPath, "/") {
+		relPath = fmt.Sprintf("%s/", relPath)
+	}
+	if checkGlobs(f.exclude, relPath, false) {
+		logger.Trace(fmt.Sprintf("Excluded - %v", relPath))
+		return false
+	}
+	if !checkGlobs(f.include, relPath, true) {
+		logger.Trace(fmt.Sprintf("Not included - %v", relPath))
+		return false
+	}
+	return true
+}
+
+// excludes tells if a dir's whole subtree is excluded (so it needn't be walked or watched)
+func (f *nodeFilter) excludes(relDirPath string) bool {
+	if f == nil {
+		return false
+	}
+	relDirPath = strings.TrimSuffix(relDirPath, "/")
+	return checkGlobs(f.exclude, relDirPath+"/", false) || f.ignores.ignores(relDirPath, true)
 }
 
 func GetScriedNodes(topDir string, scryDir ScriedDirectory) ([]fnode.Node, error) {
 	scryNodes := make([]fnode.Node, 0)
 
-	inclGlobs := mapToGlobs(scryDir.Include)
-	exclGlobs := mapToGlobs(scryDir.Exclude)
+	filter := newNodeFilter(topDir, scryDir)
 
 	fullDirPath := filepath.Join(topDir, scryDir.Path)
 
@@ -38,33 +149,27 @@ func GetScriedNodes(topDir string, scryDir ScriedDirectory) ([]fnode.Node, error
 		}
 
 		relPath := fnode.GetRelativePath(path, fullDirPath)
-		// add trailing slash to directories so we can match on our directory globs
-		if d.Type().IsDir() && !strings.HasSuffix(relPath, "/") {
-			relPath = fmt.Sprintf("%s/", relPath)
-		}
 
-		// for now we only check globs on fnode
-		if checkGlobs(exclGlobs, relPath, false) {
+		// don't descend into excluded dirs
+		if d.Type().IsDir() && filter.excludes(relPath) {
 			logger.Trace(fmt.Sprintf("Excluded - %v : %v", relPath, d))
-			return nil
+			return filepath.SkipDir
 		}
 
-		if !checkGlobs(inclGlobs, relPath, true) {
-			logger.Trace(fmt.Sprintf("Not included - %v : %v", relPath, d))
+		if !filter.matchesNode(relPath, d.Type().IsDir()) {
 			return nil
 		}
 
-		// we're only going to look at regular fnode and regular dirs
+		// we're only going to look at regular files, dirs and symlinks
 		// TODO: implement our own directory recursion?
-		if !d.Type().IsRegular() && !d.Type().IsDir() {
+		if !d.Type().IsRegular() && !d.Type().IsDir() && d.Type()&fs.ModeSymlink == 0 {
 			logger.Trace(fmt.Sprintf("SKIPPING - %v : %v", relPath, d))
 			return nil
 		}
 
 		logger.Trace(fmt.Sprintf("Adding - %v : %v", relPath, d))
 
-		logger.Trace(fmt.Sprintf("%q", relPath))
-		node, err := fnode.NewNode(path)
+		node, err := newScriedNode(scryDir, path)
 		if err != nil {
 			return err
 		}
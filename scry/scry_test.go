@@ -242,3 +242,55 @@ func TestGetScriedFilesSubDirsIncludeExcludeGlob(t *testing.T) {
 
 	helperTestGetScriedFiles(t, tmpDir, include, exclude, wanted)
 }
+
+func TestGetScriedFilesExcludedDir(t *testing.T) {
+	wanted := []string{
+		"f1.txt",
+		"sub1",
+		"sub1/f1.txt",
+	}
+
+	tmpDir := utils.TmpDir{
+		Name: "d1",
+		Dirs: []*utils.TmpDir{
+			{
+				Name:  "sub1",
+				Files: []*utils.TmpFile{{Name: "f1.txt", Content: []byte("i am sub1/f1")}},
+			},
+			{
+				Name: ".git",
+				Dirs: []*utils.TmpDir{
+					{
+						Name:  "objects",
+						Files: []*utils.TmpFile{{Name: "ab", Content: []byte("i am an object")}},
+					},
+				},
+				Files: []*utils.TmpFile{{Name: "config", Content: []byte("i am config")}},
+			},
+		},
+		Files: []*utils.TmpFile{
+			{Name: "f1.txt", Content: []byte("i am f1")},
+			{Name: ".f1.txt.swp", Content: []byte("i am a swap file")},
+		},
+	}
+
+	include := []string{}
+	exclude := []string{".git/", "*.swp"}
+
+	helperTestGetScriedFiles(t, tmpDir, include, exclude, wanted)
+}
+
+func TestNodeFilter(t *testing.T) {
+	filter := newNodeFilter(t.TempDir(), ScriedDirectory{Include: []string{"*.txt", "**/"}, Exclude: []string{".git/", "s/t/"}})
+
+	assert.True(t, filter.matches("a.txt", false))
+	assert.True(t, filter.matches("s", true))
+	assert.True(t, filter.matches("s/a.txt", false))
+	assert.False(t, filter.matches("a.dat", false), "not included")
+	assert.False(t, filter.matches(".git", true), "excluded dir")
+	assert.False(t, filter.matches(".git/a.txt", false), "in an excluded dir")
+	assert.False(t, filter.matches("s/t/u/a.txt", false), "in an excluded dir")
+	assert.True(t, newNodeFilter(t.TempDir(), ScriedDirectory{Exclude: []string{".git/"}}).matches(".git", false), "dir globs only match dirs")
+	assert.True(t, filter.excludes("s/t"))
+	assert.False(t, filter.excludes("s"))
+}
@@ -17,13 +17,29 @@ import (
 	"github.com/fsnotify/fsnotify"
 )
 
+// optional scryer behaviour
+type Options struct {
+	NodeID    string        // ID of this node, stamped on the events it adds
+	Blobs     BlobStore     // keeps the content of every file version (nil to not keep content)
+	Debounce  time.Duration // how long the filesystem must be quiet before events are coalesced and stored (0 to store each event right away)
+	RawEvents bool          // send every event (before coalescing) on Scryer.RawChanRx
+	Paranoid  bool          // hash files every time instead of trusting the stat cache (see fnode.StatCache)
+	Applier   *Applier      // makes remote changes, the scryer doesn't store the events they cause (nil if nothing's applied)
+}
+
 type Scryer struct {
 	ProcessedChanRx <-chan NodeEvent
+	RawChanRx       <-chan NodeEvent // nil unless Options.RawEvents is set
 	watcher         *fsnotify.Watcher
+	debouncer       *debouncer
 	store           EventStore
+	opts            Options
 	topDir          string
 	dirPaths        []string
+	scryDirs        map[string]ScriedDirectory // by Dir.Path
+	filters         map[string]*nodeFilter     // by Dir.Path, dirs w/o one scry everything
 	processedChanTx chan<- NodeEvent
+	rawChanTx       chan<- NodeEvent
 	stopmu          sync.Mutex
 	stopping        int32
 	stopped         int32
@@ -39,6 +55,26 @@ func (s *Scryer) getDirForEvent(nodePath string) *Dir {
 	return nil
 }
 
+// filterFor finds the filter for a node (full path) and the node's path relative to its dir
+// ok is false if the node isn't in a scried dir
+func (s *Scryer) filterFor(fullPath string) (filter *nodeFilter, nodePath string, ok bool) {
+	relPath := fnode.GetRelativePath(fullPath, s.topDir)
+	dir := s.getDirForEvent(relPath)
+	if dir == nil {
+		return nil, "", false
+	}
+	return s.filters[dir.Path], fnode.GetRelativePath(relPath, dir.Path), true
+}
+
+// prunes tells if a dir (full path) and everything in it are excluded from scrying
+func (s *Scryer) prunes(fullPath string) bool {
+	filter, nodePath, ok := s.filterFor(fullPath)
+	if !ok {
+		return true
+	}
+	return nodePath != "." && filter.excludes(nodePath)
+}
+
 func (s *Scryer) Stop() {
 	s.stopmu.Lock()
 	defer s.stopmu.Unlock()
@@ -71,13 +107,26 @@ func (s *Scryer) Run() {
 			// process the event
 			logger.Trace(fmt.Sprintf("Received watcher event %s", event))
 
-			nodeEvent, err := processEvent(s, event)
+			nodeEvent, err := prepareEvent(s, event)
 			if err != nil {
 				logger.Error(err.Error())
 				continue
 			}
-			// update watcher for new dirs
-			updateWatcher(s, nodeEvent)
+			if nodeEvent == nil { // ignored event
+				continue
+			}
+			if s.rawChanTx != nil {
+				s.rawChanTx <- *nodeEvent
+			}
+			if s.debouncer.holds(nodeEvent) {
+				s.debouncer.add(nodeEvent)
+				continue
+			}
+			// keep events in order
+			s.flush()
+			processEvent(s, nodeEvent)
+		case <-s.debouncer.timer.C:
+			s.flush()
 		case err, ok := <-watcher.Errors:
 			if !ok { // channel closed
 				return
@@ -86,6 +135,7 @@ func (s *Scryer) Run() {
 		default: // no events from fsnotify
 			// if we're stopping and we've no more events
 			if atomic.LoadInt32(&s.stopping) == 1 {
+				s.flush()
 				logger.Info(fmt.Sprintf("No more events in fsnotify.Watcher. We're done!"))
 				atomic.StoreInt32(&s.stopped, 1)
 				return
@@ -94,16 +144,15 @@ func (s *Scryer) Run() {
 	}
 }
 
-func InitScryer(topDir string, scryDirs []ScriedDirectory, store EventStore) (*Scryer, error) {
+func InitScryer(topDir string, scryDirs []ScriedDirectory, store EventStore, opts Options) (*Scryer, error) {
 	// create a watcher
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		return nil, err
 	}
 
-	// get stored dirs
-	dirs, err := store.GetDirs()
-
+	// get stored dirs (dirs that aren't configured anymore aren't scried)
+	dirs, err := GetScriedDirs(store, scryDirs)
 	if err != nil {
 		return nil, err
 	}
@@ -112,12 +161,24 @@ func InitScryer(topDir string, scryDirs []ScriedDirectory, store EventStore) (*S
 	tx, rx := utils.NewDroppingChannel[NodeEvent](1024)
 	s := Scryer{
 		watcher:         watcher,
+		debouncer:       newDebouncer(opts.Debounce),
 		store:           store,
+		opts:            opts,
 		topDir:          topDir,
 		dirPaths:        make([]string, len(dirs)),
+		scryDirs:        make(map[string]ScriedDirectory, len(scryDirs)),
+		filters:         make(map[string]*nodeFilter, len(scryDirs)),
 		processedChanTx: tx,
 		ProcessedChanRx: rx,
 	}
+	if opts.RawEvents {
+		s.rawChanTx, s.RawChanRx = utils.NewDroppingChannel[NodeEvent](1024)
+	}
+
+	for _, scryDir := range scryDirs {
+		s.scryDirs[scryDir.Path] = scryDir
+		s.filters[scryDir.Path] = newNodeFilter(topDir, scryDir)
+	}
 
 	// add dirs to watcher
 	for i, dir := range dirs {
@@ -129,10 +190,26 @@ func InitScryer(topDir string, scryDirs []ScriedDirectory, store EventStore) (*S
 		}
 	}
 
+	// catch up on anything that happened while we weren't watching
+	for _, scryDir := range scryDirs {
+		if err = Reconcile(topDir, scryDir, store, opts); err != nil {
+			return nil, err
+		}
+	}
+
 	return &s, nil
 }
 
-func processEvent(s *Scryer, event fsnotify.Event) (*NodeEvent, error) {
+// flush processes the events held back by the debouncer
+func (s *Scryer) flush() {
+	for _, nodeEvent := range s.debouncer.take() {
+		processEvent(s, nodeEvent)
+	}
+}
+
+// prepareEvent makes a NodeEvent for an fsnotify event w/ its Dir and relative path set
+// it returns nil for events we don't care about
+func prepareEvent(s *Scryer, event fsnotify.Event) (*NodeEvent, error) {
 	// transform fsnotify event into local node event
 	nodeEvent := toNodeEvent(&event)
 	if nodeEvent == nil { // ignored event
@@ -146,16 +223,75 @@ func processEvent(s *Scryer, event fsnotify.Event) (*NodeEvent, error) {
 		return nodeEvent, errors.New(fmt.Sprintf("Failed to find dir for event: %s", event))
 	}
 	nodeEvent.dir = dir
+	nodeEvent.symlinks = s.scryDirs[dir.Path].Symlinks
 	// get relative path to node
 	nodeEvent.Path = fnode.GetRelativePath(relPath, dir.Path)
-	// process this event
-	if err := processNodeEvent(nodeEvent, s.store); err != nil {
+	// an ignore file changed, re-read it before filtering anything else
+	if isIgnoreFile(nodeEvent) {
+		s.filters[dir.Path].forgetIgnores(filepath.Dir(nodeEvent.Path))
+	}
+	// skip nodes the dir's globs leave out
+	if !eventMatches(s.filters[dir.Path], nodeEvent) {
+		logger.Trace(fmt.Sprintf("Ignoring event for excluded node %s", event))
+		return nil, nil
+	}
+	return nodeEvent, nil
+}
+
+// eventMatches tells if an event is for a node the filter matches
+// removed and renamed nodes are gone so we can't tell if they were dirs, either kind will do
+func eventMatches(filter *nodeFilter, nodeEvent *NodeEvent) bool {
+	switch nodeEvent.Type {
+	case Create, Write, Chmod:
+		info, err := os.Lstat(nodeEvent.FullPath)
+		if err != nil { // gone already, let processing deal w/ it
+			return filter.matches(nodeEvent.Path, false) || filter.matches(nodeEvent.Path, true)
+		}
+		return filter.matches(nodeEvent.Path, info.IsDir())
+	default:
+		return filter.matches(nodeEvent.Path, false) || filter.matches(nodeEvent.Path, true)
+	}
+}
+
+// processEvent stores a NodeEvent and updates the watcher for it
+func processEvent(s *Scryer, nodeEvent *NodeEvent) {
+	if err := processNodeEvent(nodeEvent, s.store, s.opts); err != nil {
 		logger.Error(fmt.Sprintf("Failed to handle fsnotify event:\n%v\n", err.Error()))
 	}
 	// send processed event out
 	nodeEvent.doneTime = time.Now()
 	s.processedChanTx <- *nodeEvent
-	return nodeEvent, nil
+	// update watcher for new dirs
+	updateWatcher(s, nodeEvent)
+	if isIgnoreFile(nodeEvent) {
+		reloadIgnores(s, nodeEvent)
+	}
+}
+
+func isIgnoreFile(nodeEvent *NodeEvent) bool {
+	return filepath.Base(nodeEvent.Path) == IGNORE_FILE
+}
+
+// reloadIgnores brings the store and watcher in line w/ a changed ignore file
+// the same as a restart would: newly ignored nodes are removed and re-included ones are created
+func reloadIgnores(s *Scryer, nodeEvent *NodeEvent) {
+	scryDir, ok := s.scryDirs[nodeEvent.dir.Path]
+	if !ok {
+		scryDir = ScriedDirectory{Path: nodeEvent.dir.Path}
+	}
+	logger.Info(fmt.Sprintf("Ignore file %q changed, reconciling %q", nodeEvent.FullPath, scryDir.Path))
+	if err := Reconcile(s.topDir, scryDir, s.store, s.opts); err != nil {
+		logger.Error(fmt.Sprintf("Failed to reconcile %q after ignore file change:\n%s", scryDir.Path, err.Error()))
+	}
+	s.stopmu.Lock()
+	defer s.stopmu.Unlock()
+	if atomic.LoadInt32(&s.stopping) == 1 {
+		return
+	}
+	// watch dirs that aren't ignored anymore
+	if err := recursiveWatcherAdd(s, filepath.Dir(nodeEvent.FullPath)); err != nil {
+		logger.Error(fmt.Sprintf("Failed to add %q to watcher.\n", filepath.Dir(nodeEvent.FullPath)))
+	}
 }
 
 func updateWatcher(s *Scryer, nodeEvent *NodeEvent) {
@@ -170,8 +306,8 @@ func updateWatcher(s *Scryer, nodeEvent *NodeEvent) {
 		if err := recursiveWatcherAdd(s, nodeEvent.FullPath); err != nil {
 			logger.Error(fmt.Sprintf("Failed to add %q to watcher.\n", nodeEvent.FullPath))
 		}
-		// if this ISN'T a moved directory
-		if nodeEvent.OldPath == nil {
+		// if this ISN'T a moved directory (or one the applier made, it adds what's in it)
+		if nodeEvent.OldPath == nil && !nodeEvent.applied {
 			if err := addSubDirsToStore(s, nodeEvent); err != nil {
 				logger.Error(fmt.Sprintf("Failed to add %q to watcher.\n", nodeEvent.FullPath))
 			}
@@ -197,6 +333,11 @@ func recursiveWatcherAdd(s *Scryer, newPath string) error {
 		if !d.Type().IsDir() {
 			return nil
 		}
+		// and not the ones we don't scry (or anything in them)
+		if s.prunes(path) {
+			logger.Trace(fmt.Sprintf("Not watching excluded dir %q", path))
+			return filepath.SkipDir
+		}
 		// add path to watcher
 		s.watcher.Add(path)
 		logger.Debug(fmt.Sprintf("Watching %q\n", path))
@@ -233,11 +374,18 @@ func addSubDirsToStore(s *Scryer, nodeEvent *NodeEvent) error {
 			logger.Warn(fmt.Sprintf("Unable to walk dir: %q", path))
 			return nil
 		}
-		// we only want to watch directories and normal files
-		if !d.Type().IsRegular() && !d.Type().IsDir() {
+		// we only want to watch directories, normal files and symlinks
+		if !d.Type().IsRegular() && !d.Type().IsDir() && d.Type()&fs.ModeSymlink == 0 {
 			logger.Trace(fmt.Sprintf("SKIPPING - %v : %v", path, d))
 			return nil
 		}
+		// that the dir's globs match
+		if d.Type().IsDir() && s.prunes(path) {
+			return filepath.SkipDir
+		}
+		if filter, nodePath, ok := s.filterFor(path); !ok || !filter.matchesNode(nodePath, d.Type().IsDir()) {
+			return nil
+		}
 
 		eventPath := fnode.GetRelativePath(path, scryDirPath)
 		filepath.Join(dirPath, d.Name())
@@ -253,6 +401,9 @@ func addSubDirsToStore(s *Scryer, nodeEvent *NodeEvent) error {
 		if err != nil {
 			return err
 		}
+		if node, err = applySymlinkPolicy(nodeEvent.symlinks, node); err != nil {
+			return err
+		}
 		// add chain for new node
 		chain := &Chain{Ino: node.Ino}
 		// add new chain
@@ -261,7 +412,7 @@ func addSubDirsToStore(s *Scryer, nodeEvent *NodeEvent) error {
 			os.Exit(1)
 		}
 		// add create event to chain
-		state := node.State()
+		state := node.CachedState(statCache(s.store, s.opts))
 		event := Event{
 			Timestamp: time.Now(),
 			Path:      eventPath,
@@ -269,8 +420,14 @@ func addSubDirsToStore(s *Scryer, nodeEvent *NodeEvent) error {
 			Size:      state.Size,
 			Hash:      state.Hash,
 			ModTime:   state.ModTime,
+			Mode:      state.Mode,
+			Uid:       state.Uid,
+			Gid:       state.Gid,
+			Target:    state.Target,
+			Chunks:    state.Chunks,
 		}
-		if err = s.store.AddEvent(&event, chain.ID); err != nil {
+		keepContent(s.opts.Blobs, &event, path)
+		if err = AddLocalEvent(s.store, s.opts.NodeID, &event, chain.ID); err != nil {
 			return err
 		}
 		return nil
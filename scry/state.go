@@ -0,0 +1,273 @@
+package scry
+
+import (
+	"container/heap"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ceejimus/kusari/fnode"
+)
+
+// the expected state of a Dir - node path (relative to Dir.Path) -> node state
+type DirState map[string]fnode.NodeState
+
+// the latest known state of a chain (and the node it tracks)
+type ChainState struct {
+	Chain     Chain         // the chain
+	Path      string        // current path of node (last path for removed nodes)
+	PrevPath  *string       // path of the node before it was last moved
+	State     *Event        // most recent event w/ node state (create/write/chmod)
+	Timestamp time.Time     // timestamp of the most recent event
+	Origin    string        // node the most recent event happened on
+	Version   VersionVector // version of the most recent event
+	Moved     bool          // the node was renamed but the move wasn't finalized
+	Removed   bool          // the node was removed
+}
+
+// GetDirState returns the state we expect the Dir to be in now
+// according to the event chains in the store
+func GetDirState(store EventStore, dirID ID) (DirState, error) {
+	return GetDirStateAt(store, dirID, time.Time{})
+}
+
+// GetDirStateAt returns the state we expect the Dir was in at the given time
+// only events that happened at or before that time are considered
+// a zero time means all events are considered
+func GetDirStateAt(store EventStore, dirID ID, at time.Time) (DirState, error) {
+	tracked, err := getTrackedNodes(store, dirID, at)
+	if err != nil {
+		return nil, err
+	}
+	// nodes mid-move aren't anywhere (and neither are their children)
+	movedPrefixes := make([]string, 0)
+	for _, t := range tracked {
+		if t.Moved && !t.Removed {
+			movedPrefixes = append(movedPrefixes, t.Path+string(filepath.Separator))
+		}
+	}
+	// if more than one chain claims a path the most recent wins
+	// (the older ones were replaced w/o us seeing a remove)
+	lastSeen := make(map[string]time.Time, len(tracked))
+	state := make(DirState, len(tracked))
+	for _, t := range tracked {
+		if t.Moved || t.Removed || t.State == nil || hasAnyPrefix(t.Path, movedPrefixes) {
+			continue
+		}
+		if last, ok := lastSeen[t.Path]; ok && last.After(t.Timestamp) {
+			continue
+		}
+		lastSeen[t.Path] = t.Timestamp
+		state[t.Path] = fnode.NodeState{
+			Path:    t.Path,
+			ModTime: t.State.ModTime,
+			Hash:    t.State.Hash,
+			Size:    t.State.Size,
+			Mode:    t.State.Mode,
+			Uid:     t.State.Uid,
+			Gid:     t.State.Gid,
+			Target:  t.State.Target,
+			Chunks:  t.State.Chunks,
+		}
+	}
+	return state, nil
+}
+
+// GetChainStates returns the latest state of every chain in the Dir (as of the given time)
+// chains for removed nodes are included w/ Removed set
+// a zero time means all events are considered
+func GetChainStates(store EventStore, dirID ID, at time.Time) ([]ChainState, error) {
+	tracked, err := getTrackedNodes(store, dirID, at)
+	if err != nil {
+		return nil, err
+	}
+	states := make([]ChainState, 0, len(tracked))
+	for _, t := range tracked {
+		states = append(states, *t)
+	}
+	return states, nil
+}
+
+// GetChainStateByPath returns the state of the chain for the node at path (as of the given time)
+// unlike EventStore.GetChainByPath this finds removed nodes too
+// live nodes win over removed ones, otherwise the most recent chain wins
+func GetChainStateByPath(store EventStore, dirID ID, path string, at time.Time) (*ChainState, error) {
+	tracked, err := getTrackedNodes(store, dirID, at)
+	if err != nil {
+		return nil, err
+	}
+	var found *ChainState
+	for _, t := range tracked {
+		if t.Path != path || (t.Moved && !t.Removed) {
+			continue
+		}
+		if found == nil ||
+			(found.Removed && !t.Removed) ||
+			(found.Removed == t.Removed && t.Timestamp.After(found.Timestamp)) {
+			found = t
+		}
+	}
+	return found, nil
+}
+
+// GetPathVersion returns the merged version of the chains whose node was last at path
+// (the one there now and the one last removed there)
+// a new chain for a node at a re-used path (e.g. a file replaced by an editor's save)
+// starts from this version so peers see it as following the node it replaced
+func GetPathVersion(store EventStore, dirID ID, path string) (VersionVector, error) {
+	live, err := store.GetChainByPath(dirID, path)
+	if err != nil {
+		return nil, err
+	}
+	removed, err := store.GetRemovedChainByPath(dirID, path)
+	if err != nil {
+		return nil, err
+	}
+	version := VersionVector{}
+	for _, chain := range []*Chain{live, removed} {
+		if chain == nil {
+			continue
+		}
+		tail, err := store.GetChainTail(chain.ID)
+		if err != nil {
+			return nil, err
+		}
+		if tail != nil {
+			version = version.Merge(tail.Version)
+		}
+	}
+	return version, nil
+}
+
+func pathVersion(tracked map[string]*ChainState, path string) VersionVector {
+	version := VersionVector{}
+	for _, t := range tracked {
+		if t.Path == path {
+			version = version.Merge(t.Version)
+		}
+	}
+	return version
+}
+
+// getTrackedNodes folds all the events in a Dir into the nodes the store thinks exist
+// events are replayed in time order so moves of parent directories carry their children along
+// the events in a chain are replayed in the order they were stored though, a peer's clock may be off
+// events after the given time are ignored unless it's zero
+func getTrackedNodes(store EventStore, dirID ID, at time.Time) (map[string]*ChainState, error) {
+	chains, err := store.GetChainsInDir(dirID)
+	if err != nil {
+		return nil, err
+	}
+	pending := make(chainHeap, 0, len(chains))
+	for i := range chains {
+		events, err := store.GetEventsInChain(chains[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		if !at.IsZero() {
+			kept := events[:0]
+			for _, event := range events {
+				if !event.Timestamp.After(at) {
+					kept = append(kept, event)
+				}
+			}
+			events = kept
+		}
+		if len(events) > 0 {
+			pending = append(pending, &chainEvents{chain: &chains[i], events: events, order: i})
+		}
+	}
+	heap.Init(&pending)
+
+	tracked := make(map[string]*ChainState, len(chains))
+	for len(pending) > 0 {
+		next := pending[0]
+		chain := next.chain
+		event := &next.events[0]
+		if next.events = next.events[1:]; len(next.events) > 0 {
+			heap.Fix(&pending, 0)
+		} else {
+			heap.Pop(&pending)
+		}
+		key := string(chain.ID.Encode())
+		t, ok := tracked[key]
+		if !ok {
+			t = &ChainState{Chain: *chain}
+			tracked[key] = t
+		}
+		t.Timestamp = event.Timestamp
+		t.Origin = event.Origin
+		t.Version = event.Version
+		switch event.Type {
+		case Create:
+			if event.OldPath != nil { // finalized move, bring the children along
+				movePrefix(tracked, *event.OldPath, event.Path)
+				t.PrevPath = event.OldPath
+			}
+			t.Path = event.Path
+			t.State = event
+			t.Moved = false
+			t.Removed = false
+		case Write, Chmod:
+			t.Path = event.Path
+			t.State = event
+		case Rename:
+			t.Path = event.Path
+			t.Moved = true
+		case Remove:
+			t.Path = event.Path
+			t.Removed = true
+		}
+	}
+
+	return tracked, nil
+}
+
+// the events of a chain that are left to replay
+type chainEvents struct {
+	chain  *Chain
+	events []Event
+	order  int // the chain's place in the Dir, it breaks ties
+}
+
+// chainHeap orders chains by the timestamp of their next event
+type chainHeap []*chainEvents
+
+func (h chainHeap) Len() int { return len(h) }
+
+func (h chainHeap) Less(i, j int) bool {
+	if ti, tj := h[i].events[0].Timestamp, h[j].events[0].Timestamp; !ti.Equal(tj) {
+		return ti.Before(tj)
+	}
+	return h[i].order < h[j].order
+}
+
+func (h chainHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *chainHeap) Push(x any) { *h = append(*h, x.(*chainEvents)) }
+
+func (h *chainHeap) Pop() any {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+// movePrefix updates the paths of all nodes under oldPath to be under newPath
+func movePrefix(tracked map[string]*ChainState, oldPath string, newPath string) {
+	oldPrefix := oldPath + string(filepath.Separator)
+	for _, t := range tracked {
+		if !t.Removed && strings.HasPrefix(t.Path, oldPrefix) {
+			t.Path = filepath.Join(newPath, strings.TrimPrefix(t.Path, oldPrefix))
+		}
+	}
+}
+
+func hasAnyPrefix(path string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
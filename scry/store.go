@@ -9,7 +9,11 @@ package scry
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"time"
+
+	"github.com/ceejimus/kusari/fnode"
 )
 
 // Internal "Op" enum (Create, Write, etc.)
@@ -52,20 +56,37 @@ type Chain struct {
 
 // something that happened to a node
 type Event struct {
-	ID        ID        // should be generated when adding
-	Timestamp time.Time // timestamp of the event
-	Path      string    // relative to DirName
-	OldPath   *string   // this is set for create events that finalize a move (rename)
-	Type      EventType // create, remove, rename, write, chmod
-	Size      uint64    // file size
-	Hash      *string   // file hash (null for non-files)
-	ModTime   time.Time // modification time
+	ID        ID            // should be generated when adding
+	Timestamp time.Time     // timestamp of the event
+	Path      string        // relative to DirName
+	OldPath   *string       // this is set for create events that finalize a move (rename)
+	Type      EventType     // create, remove, rename, write, chmod
+	Size      uint64        // file size
+	Hash      *string       // file hash (null for non-files)
+	ModTime   time.Time     // modification time
+	Mode      os.FileMode   // permission bits (0 if unknown)
+	Uid       uint32        // owner
+	Gid       uint32        // group
+	Target    string        // where a symlink points ("" for other nodes)
+	Chunks    []fnode.Chunk // the chunks of a file's content (nil unless it's at least fnode.CHUNKED_SIZE)
+	Origin    string        // ID of the node the event happened on
+	Version   VersionVector // the version of the chain as of this event
+}
+
+// two nodes changed the same chain concurrently
+// the remote change isn't applied until the conflict is resolved
+type Conflict struct {
+	ID       ID        // should be generated when adding
+	ChainID  ID        // the local chain
+	Path     string    // the local path of the node (relative to DirName)
+	Local    Event     // the most recent local event when the conflict was detected
+	Remote   Event     // the most recent remote event (w/o an ID)
+	Detected time.Time // when the conflict was detected
 }
 
 // EventStore is the interface that persists dirs, chains and events
 //
-// NOTE: this interface is a WIP, it doesn't include delete definitions for one
-// it uses []byte for ID for another
+// NOTE: this interface is a WIP, it uses []byte for ID for now
 //
 // The AddX methods add new objects to database.
 // These methods should generate and set the ID on the object (modify-in-place).
@@ -78,6 +99,11 @@ type Event struct {
 // Not finding the specified object is not "bad";
 // It is thus up to the caller to check if the returned pointer is nil
 // in addition to checking the error.
+//
+// The RemoveX and PruneX methods delete objects (and their lookups).
+// History is only dropped when asked for, nodes being removed just add events.
+//
+// storetest.Run checks an implementation against what's described here.
 type EventStore interface {
 	// add a new directory
 	// should error if user tries to add dir w/ existing path
@@ -88,6 +114,10 @@ type EventStore interface {
 	// add a new event
 	// should error if user specifies chainID of nonexistent Chain
 	AddEvent(event *Event, chainID ID) error
+	// point a chain at a new inode (e.g. its node was atomically replaced w/ an update)
+	// GetChainByIno should find the chain by the new ino and not the old one
+	// should error if user specifies chainID of nonexistent Chain
+	SetChainIno(chainID ID, ino uint64) error
 	// get scry directory by ID
 	GetDirByID(dirID ID) (*Dir, error)
 	// get scry dir by path
@@ -109,22 +139,76 @@ type EventStore interface {
 	// like the above, after a node is removed, this should return nil
 	// until a new node re-uses the inode
 	GetChainByIno(ino uint64) (*Chain, error)
+	// get the chain of the node that was last removed at path (nil if none was)
+	// unlike GetChainByPath it's still found after a new node re-uses the name
+	GetRemovedChainByPath(dirID ID, path string) (*Chain, error)
 	// get event by ID
 	GetEventByID(eventID ID) (*Event, error)
 	// get the event that occurred before this event
 	GetPrevEvent(eventID ID) (*Event, error)
 	// get the event that occurred after this event
 	GetNextEvent(eventID ID) (*Event, error)
+	// get the first event in chain
+	GetChainHead(chainID ID) (*Event, error)
+	// get the most recent event in chain
+	GetChainTail(chainID ID) (*Event, error)
+	// remove a dir and everything in it (chains, events, conflicts)
+	// should error if user specifies dirID of nonexistent Dir
+	RemoveDir(dirID ID) error
+	// drop the events in a chain that happened before the given time
+	// pinned events (the tail and finalized moves, see IsPinned) are kept
+	// returns the number of events dropped
+	// should error if user specifies chainID of nonexistent Chain
+	PruneChain(chainID ID, before time.Time) (int, error)
 	// get all stored dirs
 	GetDirs() ([]Dir, error)
 	// get all chains in directory
 	GetChainsInDir(dirID ID) ([]Chain, error)
 	// get all events in chain
 	GetEventsInChain(chainId ID) ([]Event, error)
+	// add a new conflict
+	// should error if user specifies dirID of nonexistent Dir
+	AddConflict(conflict *Conflict, dirID ID) error
+	// get conflict by ID
+	GetConflictByID(conflictID ID) (*Conflict, error)
+	// get all unresolved conflicts in directory
+	GetConflictsInDir(dirID ID) ([]Conflict, error)
+	// remove a (resolved) conflict
+	RemoveConflict(conflictID ID) error
+	// get the cached hash entry for the file w/ ino (see fnode.StatCache)
+	// like the GetXByY methods, this should return nil if there's no entry
+	GetStatEntry(ino uint64) (*fnode.StatEntry, error)
+	// set the cached hash entry for the file w/ entry.Ino, replacing any existing one
+	PutStatEntry(entry *fnode.StatEntry) error
 	// something for owners to call to cleanup underlying resources
 	Close() error
 }
 
+// BlobStore keeps the content of files keyed by hash
+//
+// The scryer adds content for every create/write event on a regular file
+// so any version in a chain can be fetched later (by peers, restores, etc.)
+type BlobStore interface {
+	// copy the file at path into the store
+	// should error if the file's content doesn't have the given hash
+	AddFile(hash string, path string) error
+	// copy the file at path into the store as the given chunks (for large files)
+	// chunks shared w/ content already in the store should only be stored once
+	// should error if the file's content doesn't have the given hash and chunks
+	AddFileChunks(hash string, chunks []fnode.Chunk, path string) error
+	// copy content into the store
+	// should error if the content doesn't have the given hash
+	Add(hash string, r io.Reader) error
+	// copy content into the store as the given chunks
+	// should error if the content doesn't have the given hash and chunks
+	AddChunks(hash string, chunks []fnode.Chunk, r io.Reader) error
+	// check if content w/ the given hash is stored
+	Has(hash string) (bool, error)
+	// open the content w/ the given hash
+	// like the GetXByY methods, this should return nil if there's no such content
+	Open(hash string) (io.ReadCloser, error)
+}
+
 func (d Dir) String() string {
 	return fmt.Sprintf("Dir: %s %q", d.ID, d.Path)
 }
@@ -137,6 +221,8 @@ func (e Event) String() string {
 	hash := ""
 	if e.Hash != nil {
 		hash = *e.Hash
+	} else if e.Target != "" {
+		hash = "-> " + e.Target
 	}
 	return fmt.Sprintf("(<%s> %s %d:|%s|)",
 		e.Type,
@@ -145,3 +231,14 @@ func (e Event) String() string {
 		hash,
 	)
 }
+
+func (c Conflict) String() string {
+	return fmt.Sprintf("Conflict: %s %q local: %s %s remote: %s %s",
+		c.ID,
+		c.Path,
+		c.Local,
+		c.Local.Version,
+		c.Remote,
+		c.Remote.Version,
+	)
+}
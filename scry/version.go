@@ -0,0 +1,108 @@
+package scry
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// per node counters of the events in a chain
+// every event a node adds to a chain bumps that node's counter
+// so comparing the vectors of two events tells if one happened after the other
+// or if they happened concurrently (neither node had seen the other's event)
+type VersionVector map[string]uint64
+
+// how two versions relate
+type VersionOrder int
+
+const (
+	VersionEqual VersionOrder = iota
+	VersionBefore
+	VersionAfter
+	VersionConcurrent
+)
+
+func (v VersionVector) Copy() VersionVector {
+	copied := make(VersionVector, len(v))
+	for node, counter := range v {
+		copied[node] = counter
+	}
+	return copied
+}
+
+// Merge returns a new vector w/ the max counter of each node in either vector
+func (v VersionVector) Merge(other VersionVector) VersionVector {
+	merged := v.Copy()
+	for node, counter := range other {
+		if counter > merged[node] {
+			merged[node] = counter
+		}
+	}
+	return merged
+}
+
+// Bump returns a new vector w/ the node's counter incremented
+func (v VersionVector) Bump(node string) VersionVector {
+	bumped := v.Copy()
+	bumped[node]++
+	return bumped
+}
+
+// Compare tells how this version relates to another
+func (v VersionVector) Compare(other VersionVector) VersionOrder {
+	before, after := false, false
+	for node, counter := range v {
+		if counter > other[node] {
+			after = true
+		}
+	}
+	for node, counter := range other {
+		if counter > v[node] {
+			before = true
+		}
+	}
+	switch {
+	case before && after:
+		return VersionConcurrent
+	case before:
+		return VersionBefore
+	case after:
+		return VersionAfter
+	default:
+		return VersionEqual
+	}
+}
+
+// Dominates tells if this version includes everything in another
+func (v VersionVector) Dominates(other VersionVector) bool {
+	order := v.Compare(other)
+	return order == VersionAfter || order == VersionEqual
+}
+
+func (o VersionOrder) String() string {
+	switch o {
+	case VersionEqual:
+		return "equal"
+	case VersionBefore:
+		return "before"
+	case VersionAfter:
+		return "after"
+	case VersionConcurrent:
+		return "concurrent"
+	default:
+		panic(fmt.Sprintf("unexpected scry.VersionOrder: %#v", o))
+	}
+}
+
+func (v VersionVector) String() string {
+	nodes := make([]string, 0, len(v))
+	for node := range v {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	counters := make([]string, len(nodes))
+	for i, node := range nodes {
+		counters[i] = fmt.Sprintf("%s:%d", node, v[node])
+	}
+	return fmt.Sprintf("{%s}", strings.Join(counters, " "))
+}
@@ -0,0 +1,49 @@
+package scry
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersionCompare(t *testing.T) {
+	tests := []struct {
+		name   string
+		v      VersionVector
+		other  VersionVector
+		wanted VersionOrder
+	}{
+		{"both empty", VersionVector{}, nil, VersionEqual},
+		{"equal", VersionVector{"a": 1, "b": 2}, VersionVector{"a": 1, "b": 2}, VersionEqual},
+		{"missing counter is zero", VersionVector{"a": 1, "b": 0}, VersionVector{"a": 1}, VersionEqual},
+		{"before", VersionVector{"a": 1}, VersionVector{"a": 1, "b": 1}, VersionBefore},
+		{"after", VersionVector{"a": 2, "b": 1}, VersionVector{"a": 1, "b": 1}, VersionAfter},
+		{"concurrent", VersionVector{"a": 2, "b": 1}, VersionVector{"a": 1, "b": 2}, VersionConcurrent},
+		{"concurrent disjoint", VersionVector{"a": 1}, VersionVector{"b": 1}, VersionConcurrent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wanted, tt.v.Compare(tt.other))
+		})
+	}
+}
+
+func TestVersionMergeAndBump(t *testing.T) {
+	a := VersionVector{"a": 2, "b": 1}
+	b := VersionVector{"a": 1, "b": 3, "c": 1}
+
+	merged := a.Merge(b)
+	assert.Equal(t, VersionVector{"a": 2, "b": 3, "c": 1}, merged)
+	assert.True(t, merged.Dominates(a))
+	assert.True(t, merged.Dominates(b))
+
+	bumped := merged.Bump("a")
+	assert.Equal(t, VersionAfter, bumped.Compare(merged))
+	assert.Equal(t, uint64(3), bumped["a"])
+	// neither operation changes the receiver
+	assert.Equal(t, VersionVector{"a": 2, "b": 1}, a)
+	assert.Equal(t, uint64(2), merged["a"])
+
+	assert.Equal(t, VersionVector{"n": 1}, VersionVector(nil).Bump("n"))
+}
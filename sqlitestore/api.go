@@ -0,0 +1,350 @@
+package sqlitestore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ceejimus/kusari/fnode"
+	"github.com/ceejimus/kusari/scry"
+)
+
+// NewSqliteStore opens (or creates) the sqlite database at path
+func NewSqliteStore(path string) (*SqliteStore, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)")
+	if err != nil {
+		return nil, err
+	}
+	// one connection so transactions are serialized (like badger's updates)
+	db.SetMaxOpenConns(1)
+	if _, err = db.Exec(SETUP_DB); err != nil {
+		db.Close()
+		return nil, errors.New(fmt.Sprintf("Failed to setup sqlite db @ %q:\n%s", path, err.Error()))
+	}
+	return &SqliteStore{db: db}, nil
+}
+
+func (s *SqliteStore) AddDir(dir *scry.Dir) error {
+	if dir.ID != nil {
+		return errors.New(fmt.Sprintf("Cannot add new dir, non-nil ID %v", dir))
+	}
+	return s.update(func(tx *sql.Tx) error {
+		return addDir(tx, dir)
+	})
+}
+
+func (s *SqliteStore) AddChain(chain *scry.Chain, dirID scry.ID) error {
+	if chain.ID != nil {
+		return errors.New(fmt.Sprintf("Cannot add new chain, non-nil ID %v", chain))
+	}
+	sqlID, err := toSqliteID(dirID.Encode())
+	if err != nil {
+		return err
+	}
+	return s.update(func(tx *sql.Tx) error {
+		return addChain(tx, chain, sqlID)
+	})
+}
+
+func (s *SqliteStore) AddEvent(event *scry.Event, chainID scry.ID) error {
+	if event.ID != nil {
+		return errors.New(fmt.Sprintf("Cannot add new event, non-nil ID %v", event))
+	}
+	sqlID, err := toSqliteID(chainID.Encode())
+	if err != nil {
+		return err
+	}
+	return s.update(func(tx *sql.Tx) error {
+		return addEvent(tx, event, sqlID)
+	})
+}
+
+func (s *SqliteStore) SetChainIno(chainID scry.ID, ino uint64) error {
+	sqlID, err := toSqliteID(chainID.Encode())
+	if err != nil {
+		return err
+	}
+	return s.update(func(tx *sql.Tx) error {
+		return setChainIno(tx, sqlID, ino)
+	})
+}
+
+func (s *SqliteStore) GetDirByID(dirID scry.ID) (*scry.Dir, error) {
+	sqlID, err := toSqliteID(dirID.Encode())
+	if err != nil {
+		return nil, err
+	}
+	var dir *scry.Dir
+	err = s.view(func(tx *sql.Tx) error {
+		dir, err = getDirByID(tx, sqlID)
+		return err
+	})
+	return dir, err
+}
+
+func (s *SqliteStore) GetDirByPath(path string) (*scry.Dir, error) {
+	var dir *scry.Dir
+	err := s.view(func(tx *sql.Tx) (err error) {
+		dir, err = getDirByPath(tx, path)
+		return err
+	})
+	return dir, err
+}
+
+func (s *SqliteStore) GetChainByID(chainID scry.ID) (*scry.Chain, error) {
+	sqlID, err := toSqliteID(chainID.Encode())
+	if err != nil {
+		return nil, err
+	}
+	var chain *scry.Chain
+	err = s.view(func(tx *sql.Tx) error {
+		chain, err = getChainByID(tx, sqlID)
+		return err
+	})
+	return chain, err
+}
+
+func (s *SqliteStore) GetChainByPath(dirID scry.ID, path string) (*scry.Chain, error) {
+	sqlID, err := toSqliteID(dirID.Encode())
+	if err != nil {
+		return nil, err
+	}
+	var chain *scry.Chain
+	err = s.view(func(tx *sql.Tx) error {
+		chainID, found, err := getChainIDByPath(tx, sqlID, path)
+		if !found || err != nil {
+			return err
+		}
+		chain, err = getChainByID(tx, chainID)
+		return err
+	})
+	return chain, err
+}
+
+func (s *SqliteStore) GetRemovedChainByPath(dirID scry.ID, path string) (*scry.Chain, error) {
+	sqlID, err := toSqliteID(dirID.Encode())
+	if err != nil {
+		return nil, err
+	}
+	var chain *scry.Chain
+	err = s.view(func(tx *sql.Tx) (err error) {
+		chain, err = getRemovedChainByPath(tx, sqlID, path)
+		return err
+	})
+	return chain, err
+}
+
+func (s *SqliteStore) GetChainByIno(ino uint64) (*scry.Chain, error) {
+	var chain *scry.Chain
+	err := s.view(func(tx *sql.Tx) (err error) {
+		chain, err = getChainByIno(tx, ino)
+		return err
+	})
+	return chain, err
+}
+
+func (s *SqliteStore) GetEventByID(eventID scry.ID) (*scry.Event, error) {
+	return s.getEvent(eventID, getEventByID)
+}
+
+func (s *SqliteStore) GetPrevEvent(eventID scry.ID) (*scry.Event, error) {
+	return s.getEvent(eventID, getEventPrev)
+}
+
+func (s *SqliteStore) GetNextEvent(eventID scry.ID) (*scry.Event, error) {
+	return s.getEvent(eventID, getEventNext)
+}
+
+func (s *SqliteStore) GetChainHead(chainID scry.ID) (*scry.Event, error) {
+	return s.getEvent(chainID, getChainHead)
+}
+
+func (s *SqliteStore) GetChainTail(chainID scry.ID) (*scry.Event, error) {
+	return s.getEvent(chainID, getChainTail)
+}
+
+func (s *SqliteStore) AddConflict(conflict *scry.Conflict, dirID scry.ID) error {
+	if conflict.ID != nil {
+		return errors.New(fmt.Sprintf("Cannot add new conflict, non-nil ID %v", conflict))
+	}
+	sqlID, err := toSqliteID(dirID.Encode())
+	if err != nil {
+		return err
+	}
+	return s.update(func(tx *sql.Tx) error {
+		return addConflict(tx, conflict, sqlID)
+	})
+}
+
+func (s *SqliteStore) GetConflictByID(conflictID scry.ID) (*scry.Conflict, error) {
+	sqlID, err := toSqliteID(conflictID.Encode())
+	if err != nil {
+		return nil, err
+	}
+	var conflict *scry.Conflict
+	err = s.view(func(tx *sql.Tx) error {
+		conflict, err = getConflictByID(tx, sqlID)
+		return err
+	})
+	return conflict, err
+}
+
+func (s *SqliteStore) GetConflictsInDir(dirID scry.ID) ([]scry.Conflict, error) {
+	sqlID, err := toSqliteID(dirID.Encode())
+	if err != nil {
+		return nil, err
+	}
+	conflicts := make([]scry.Conflict, 0)
+	err = s.view(func(tx *sql.Tx) error {
+		rows, err := tx.Query("SELECT id, chain_id, path, local, remote, detected FROM conflict WHERE dir_id = ? ORDER BY id", sqlID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			conflict, err := scanConflict(rows)
+			if err != nil {
+				return err
+			}
+			conflicts = append(conflicts, *conflict)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return conflicts, nil
+}
+
+func (s *SqliteStore) RemoveConflict(conflictID scry.ID) error {
+	sqlID, err := toSqliteID(conflictID.Encode())
+	if err != nil {
+		return err
+	}
+	return s.update(func(tx *sql.Tx) error {
+		return removeConflict(tx, sqlID)
+	})
+}
+
+func (s *SqliteStore) GetStatEntry(ino uint64) (*fnode.StatEntry, error) {
+	var entry *fnode.StatEntry
+	err := s.view(func(tx *sql.Tx) (err error) {
+		entry, err = getStatEntry(tx, ino)
+		return err
+	})
+	return entry, err
+}
+
+func (s *SqliteStore) PutStatEntry(entry *fnode.StatEntry) error {
+	return s.update(func(tx *sql.Tx) error {
+		return putStatEntry(tx, entry)
+	})
+}
+
+func (s *SqliteStore) RemoveDir(dirID scry.ID) error {
+	sqlID, err := toSqliteID(dirID.Encode())
+	if err != nil {
+		return err
+	}
+	return s.update(func(tx *sql.Tx) error {
+		return removeDir(tx, sqlID)
+	})
+}
+
+func (s *SqliteStore) PruneChain(chainID scry.ID, before time.Time) (int, error) {
+	sqlID, err := toSqliteID(chainID.Encode())
+	if err != nil {
+		return 0, err
+	}
+	var dropped int
+	err = s.update(func(tx *sql.Tx) error {
+		dropped, err = pruneChain(tx, sqlID, before)
+		return err
+	})
+	return dropped, err
+}
+
+func (s *SqliteStore) GetDirs() ([]scry.Dir, error) {
+	dirs := make([]scry.Dir, 0)
+	err := s.view(func(tx *sql.Tx) error {
+		rows, err := tx.Query("SELECT id, path FROM dir ORDER BY id")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			dir, err := scanDir(rows)
+			if err != nil {
+				return err
+			}
+			dirs = append(dirs, *dir)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dirs, nil
+}
+
+func (s *SqliteStore) GetChainsInDir(dirID scry.ID) ([]scry.Chain, error) {
+	sqlID, err := toSqliteID(dirID.Encode())
+	if err != nil {
+		return nil, err
+	}
+	chains := make([]scry.Chain, 0)
+	err = s.view(func(tx *sql.Tx) error {
+		rows, err := tx.Query("SELECT id, ino FROM chain WHERE dir_id = ? ORDER BY id", sqlID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id, ino int64
+			if err = rows.Scan(&id, &ino); err != nil {
+				return err
+			}
+			chains = append(chains, scry.Chain{ID: newID(id), Ino: uint64(ino)})
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return chains, nil
+}
+
+func (s *SqliteStore) GetEventsInChain(chainID scry.ID) ([]scry.Event, error) {
+	sqlID, err := toSqliteID(chainID.Encode())
+	if err != nil {
+		return nil, err
+	}
+	var events []scry.Event
+	err = s.view(func(tx *sql.Tx) error {
+		events, err = getEventsInChain(tx, sqlID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// getEvent gets a single event w/ the given getter
+func (s *SqliteStore) getEvent(id scry.ID, get func(*sql.Tx, SqliteID) (*scry.Event, error)) (*scry.Event, error) {
+	sqlID, err := toSqliteID(id.Encode())
+	if err != nil {
+		return nil, err
+	}
+	var event *scry.Event
+	err = s.view(func(tx *sql.Tx) error {
+		event, err = get(tx, sqlID)
+		return err
+	})
+	return event, err
+}
+
+func (s *SqliteStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,103 @@
+package sqlitestore
+
+import (
+	"database/sql"
+	"path/filepath"
+)
+
+// the parent of the nodes at the top of a dir
+const ROOT_CHAIN_ID = SqliteID(0)
+
+// getChainIDByPath walks the path lkp one name at a time
+// found is false if there's no chain at path
+func getChainIDByPath(tx *sql.Tx, dirID SqliteID, path string) (chainID SqliteID, found bool, err error) {
+	currChainID := ROOT_CHAIN_ID
+	if path == "" { // caller wants root chainID
+		return currChainID, true, nil
+	}
+	for _, pathPart := range splitPath(path) {
+		chainID, found, err := getChainIDFromLkp(tx, dirID, currChainID, pathPart)
+		if !found || err != nil {
+			return 0, false, err
+		}
+		currChainID = chainID
+	}
+	return currChainID, true, nil
+}
+
+func addChainPathLkp(tx *sql.Tx, dirID SqliteID, path string, chainID SqliteID) error {
+	dir, name := filepath.Split(path)
+	// a parent that isn't found is the root (like BadgerStore)
+	parentChainID, _, err := getChainIDByPath(tx, dirID, dir)
+	if err != nil {
+		return err
+	}
+	return addChainIDToLkp(tx, dirID, parentChainID, name, chainID)
+}
+
+func moveChainPathLkp(tx *sql.Tx, dirID SqliteID, dstPath string, srcPath string) error {
+	dstDir, dstName := filepath.Split(dstPath)
+	srcDir, srcName := filepath.Split(srcPath)
+	dstDirParentChainID, _, err := getChainIDByPath(tx, dirID, dstDir)
+	if err != nil {
+		return err
+	}
+	srcDirParentChainID, _, err := getChainIDByPath(tx, dirID, srcDir)
+	if err != nil {
+		return err
+	}
+	// get the src chain ID
+	chainID, found, err := getChainIDFromLkp(tx, dirID, srcDirParentChainID, srcName)
+	if !found || err != nil {
+		return err
+	}
+	// add chain ID to dst lkp then drop the src
+	if err = addChainIDToLkp(tx, dirID, dstDirParentChainID, dstName, chainID); err != nil {
+		return err
+	}
+	return removeChainIDFromLkp(tx, dirID, srcDirParentChainID, srcName)
+}
+
+func deleteChainPathLkp(tx *sql.Tx, dirID SqliteID, path string) error {
+	dir, name := filepath.Split(path)
+	parentChainID, _, err := getChainIDByPath(tx, dirID, dir)
+	if err != nil {
+		return err
+	}
+	return removeChainIDFromLkp(tx, dirID, parentChainID, name)
+}
+
+func getChainIDFromLkp(tx *sql.Tx, dirID SqliteID, parentChainID SqliteID, name string) (SqliteID, bool, error) {
+	var chainID int64
+	err := tx.QueryRow(
+		"SELECT chain_id FROM chain_path WHERE dir_id = ? AND parent_id = ? AND name = ?",
+		dirID, parentChainID, name,
+	).Scan(&chainID)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	return SqliteID(chainID), true, nil
+}
+
+func addChainIDToLkp(tx *sql.Tx, dirID SqliteID, parentChainID SqliteID, name string, chainID SqliteID) error {
+	_, err := tx.Exec(
+		"INSERT OR REPLACE INTO chain_path (dir_id, parent_id, name, chain_id) VALUES (?, ?, ?, ?)",
+		dirID, parentChainID, name, chainID,
+	)
+	return err
+}
+
+func removeChainIDFromLkp(tx *sql.Tx, dirID SqliteID, parentChainID SqliteID, name string) error {
+	_, err := tx.Exec("DELETE FROM chain_path WHERE dir_id = ? AND parent_id = ? AND name = ?", dirID, parentChainID, name)
+	return err
+}
+
+func splitPath(path string) []string {
+	if dir, name := filepath.Split(filepath.Clean(path)); dir == "" {
+		return []string{name}
+	} else {
+		return append(splitPath(dir), name)
+	}
+}
@@ -0,0 +1,12 @@
+SELECT
+  ino
+ ,size
+ ,modtime
+ ,ctime
+ ,hash
+ ,chunks
+FROM
+  stat
+WHERE
+  ino = :ino
+;
@@ -0,0 +1,102 @@
+-- scried directories
+CREATE TABLE IF NOT EXISTS dir (
+  id INTEGER PRIMARY KEY AUTOINCREMENT
+ ,path TEXT NOT NULL UNIQUE
+);
+
+-- chains of events, one per inode
+CREATE TABLE IF NOT EXISTS chain (
+  id INTEGER PRIMARY KEY AUTOINCREMENT
+ ,dir_id INTEGER NOT NULL REFERENCES dir(id)
+ ,ino INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS chain_dir ON chain(dir_id);
+
+-- the chain for each live inode (dropped when its node is removed)
+CREATE TABLE IF NOT EXISTS chain_ino (
+  ino INTEGER PRIMARY KEY
+ ,chain_id INTEGER NOT NULL REFERENCES chain(id)
+);
+
+-- the chain for each live path, one name at a time under the chain of its parent (0 at the top of the dir)
+-- moving a dir moves what's in it w/o touching their rows
+CREATE TABLE IF NOT EXISTS chain_path (
+  dir_id INTEGER NOT NULL REFERENCES dir(id)
+ ,parent_id INTEGER NOT NULL
+ ,name TEXT NOT NULL
+ ,chain_id INTEGER NOT NULL REFERENCES chain(id)
+ ,PRIMARY KEY(dir_id, parent_id, name)
+);
+
+-- the chain of the node last removed at each path
+CREATE TABLE IF NOT EXISTS chain_removed (
+  dir_id INTEGER NOT NULL REFERENCES dir(id)
+ ,path TEXT NOT NULL
+ ,chain_id INTEGER NOT NULL REFERENCES chain(id)
+ ,PRIMARY KEY(dir_id, path)
+);
+
+-- events in the order they were added (ids only go up so they're in chain order too)
+-- times are unix nanoseconds, chunks and versions are json
+CREATE TABLE IF NOT EXISTS event (
+  id INTEGER PRIMARY KEY AUTOINCREMENT
+ ,chain_id INTEGER NOT NULL REFERENCES chain(id)
+ ,type INTEGER NOT NULL
+ ,timestamp INTEGER
+ ,path TEXT NOT NULL
+ ,old_path TEXT
+ ,modtime INTEGER
+ ,hash TEXT
+ ,size INTEGER NOT NULL
+ ,mode INTEGER NOT NULL
+ ,uid INTEGER NOT NULL
+ ,gid INTEGER NOT NULL
+ ,target TEXT NOT NULL
+ ,chunks TEXT
+ ,origin TEXT NOT NULL
+ ,version TEXT
+);
+CREATE INDEX IF NOT EXISTS event_chain ON event(chain_id, id);
+
+-- unresolved conflicts, the events are json (w/o ids)
+CREATE TABLE IF NOT EXISTS conflict (
+  id INTEGER PRIMARY KEY AUTOINCREMENT
+ ,dir_id INTEGER NOT NULL REFERENCES dir(id)
+ ,chain_id INTEGER NOT NULL
+ ,path TEXT NOT NULL
+ ,local TEXT NOT NULL
+ ,remote TEXT NOT NULL
+ ,detected INTEGER
+);
+CREATE INDEX IF NOT EXISTS conflict_dir ON conflict(dir_id);
+
+-- the hash each file had w/ the stat it had when it was hashed
+CREATE TABLE IF NOT EXISTS stat (
+  ino INTEGER PRIMARY KEY
+ ,size INTEGER NOT NULL
+ ,modtime INTEGER
+ ,ctime INTEGER
+ ,hash TEXT NOT NULL
+ ,chunks TEXT
+);
+
+-- what the old file_state table kept: the latest event for each file path w/ each hash it's had
+CREATE VIEW IF NOT EXISTS file_state AS
+SELECT
+  dir.path || '/' || event.path AS path
+ ,event.hash
+ ,event.size
+ ,event.timestamp
+ ,event.modtime
+FROM
+  event
+  JOIN chain ON chain.id = event.chain_id
+  JOIN dir ON dir.id = chain.dir_id
+WHERE
+  event.id IN (
+    SELECT MAX(latest.id)
+    FROM event latest JOIN chain ON chain.id = latest.chain_id
+    WHERE latest.hash IS NOT NULL
+    GROUP BY chain.dir_id, latest.path, latest.hash
+  )
+;
@@ -0,0 +1,13 @@
+INSERT INTO stat
+  (ino, size, modtime, ctime, hash, chunks)
+VALUES
+  (:ino, :size, :modtime, :ctime, :hash, :chunks)
+ON CONFLICT
+  (ino)
+DO UPDATE SET
+  size=excluded.size
+ ,modtime=excluded.modtime
+ ,ctime=excluded.ctime
+ ,hash=excluded.hash
+ ,chunks=excluded.chunks
+;
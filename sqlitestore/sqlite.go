@@ -0,0 +1,567 @@
+package sqlitestore
+
+import (
+	"database/sql"
+	_ "embed"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ceejimus/kusari/fnode"
+	"github.com/ceejimus/kusari/scry"
+	_ "modernc.org/sqlite"
+)
+
+// the schema, every statement is idempotent so it's run each time the store is opened
+//
+//go:embed sql/setup_db.sql
+var SETUP_DB string
+
+//go:embed sql/upsert_stat.sql
+var UPSERT_STAT string
+
+//go:embed sql/query_stat.sql
+var QUERY_STAT string
+
+// the columns scanned into an event (see scanEvent)
+const EVENT_COLUMNS = "id, type, timestamp, path, old_path, modtime, hash, size, mode, uid, gid, target, chunks, origin, version"
+
+type SqliteID int64
+
+type SqliteStore struct {
+	db *sql.DB
+}
+
+// anything that can be scanned like a row
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func (id *SqliteID) Encode() []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(*id))
+}
+
+func (id *SqliteID) String() string {
+	return strconv.FormatInt(int64(*id), 10)
+}
+
+func toSqliteID(bytes []byte) (SqliteID, error) {
+	if len(bytes) != 8 {
+		return 0, errors.New(fmt.Sprintf("Failed to convert ID bytes to SqliteID, bytes must represent uint64: %v", bytes))
+	}
+	id := SqliteID(binary.BigEndian.Uint64(bytes))
+	if id <= 0 {
+		return 0, errors.New(fmt.Sprintln("Failed to convert ID bytes to SqliteID, ID must be greater than 0"))
+	}
+	return id, nil
+}
+
+func newID(id int64) *SqliteID {
+	sqlID := SqliteID(id)
+	return &sqlID
+}
+
+// view runs fn in a transaction that's always rolled back
+func (s *SqliteStore) view(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	return fn(tx)
+}
+
+// update runs fn in a transaction that's committed if fn doesn't error
+func (s *SqliteStore) update(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func addDir(tx *sql.Tx, dir *scry.Dir) error {
+	existingDir, err := getDirByPath(tx, dir.Path)
+	if err != nil {
+		return err
+	}
+	if existingDir != nil {
+		return errors.New(fmt.Sprintf("Cannot add Dir, existing dir w/ path %s", dir.Path))
+	}
+	result, err := tx.Exec("INSERT INTO dir (path) VALUES (?)", dir.Path)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	dir.ID = newID(id)
+	return nil
+}
+
+func addChain(tx *sql.Tx, chain *scry.Chain, dirID SqliteID) error {
+	// check dir exists
+	dir, err := getDirByID(tx, dirID)
+	if dir == nil || err != nil {
+		return errors.New(fmt.Sprintf("Cannot add new chain, nonexistent dir w/ id: %v", dirID))
+	}
+	// NOTE: inodes get reused, a new chain for an ino takes over its lkp
+	result, err := tx.Exec("INSERT INTO chain (dir_id, ino) VALUES (?, ?)", dirID, int64(chain.Ino))
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	if err = setInoLkp(tx, chain.Ino, SqliteID(id)); err != nil {
+		return err
+	}
+	chain.ID = newID(id)
+	return nil
+}
+
+func setChainIno(tx *sql.Tx, chainID SqliteID, ino uint64) error {
+	chain, err := getChainByID(tx, chainID)
+	if chain == nil || err != nil {
+		return errors.New(fmt.Sprintf("Cannot set ino, nonexistent chain w/ id: %v", chainID))
+	}
+	// only drop the old ino lkp if it's still ours
+	if _, err = tx.Exec("DELETE FROM chain_ino WHERE ino = ? AND chain_id = ?", int64(chain.Ino), chainID); err != nil {
+		return err
+	}
+	if _, err = tx.Exec("UPDATE chain SET ino = ? WHERE id = ?", int64(ino), chainID); err != nil {
+		return err
+	}
+	return setInoLkp(tx, ino, chainID)
+}
+
+func setInoLkp(tx *sql.Tx, ino uint64, chainID SqliteID) error {
+	_, err := tx.Exec("INSERT OR REPLACE INTO chain_ino (ino, chain_id) VALUES (?, ?)", int64(ino), chainID)
+	return err
+}
+
+func addEvent(tx *sql.Tx, event *scry.Event, chainID SqliteID) error {
+	// check chain exists
+	chain, dirID, err := getChainAndDir(tx, chainID)
+	if chain == nil || err != nil {
+		return errors.New(fmt.Sprintf("Cannot add new event, nonexistent chain w/ id: %v", chainID))
+	}
+	tail, err := getChainTail(tx, chainID)
+	if err != nil {
+		return err
+	}
+	// update the path lookup depending on event type (this sets the old path of move finalizations)
+	toAdd := *event
+	toAdd.OldPath = nil
+	if err = updateChainLkps(tx, chain, dirID, &toAdd, tail); err != nil {
+		return err
+	}
+	chunks, err := encodeJSON(toAdd.Chunks)
+	if err != nil {
+		return err
+	}
+	version, err := encodeJSON(toAdd.Version)
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec(
+		"INSERT INTO event (chain_id, type, timestamp, path, old_path, modtime, hash, size, mode, uid, gid, target, chunks, origin, version)"+
+			" VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		chainID,
+		uint32(toAdd.Type),
+		toNanos(toAdd.Timestamp),
+		toAdd.Path,
+		toAdd.OldPath,
+		toNanos(toAdd.ModTime),
+		toAdd.Hash,
+		int64(toAdd.Size),
+		uint32(toAdd.Mode),
+		toAdd.Uid,
+		toAdd.Gid,
+		toAdd.Target,
+		chunks,
+		toAdd.Origin,
+		version,
+	)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	// set the ID and old path
+	event.ID = newID(id)
+	event.OldPath = toAdd.OldPath
+	return nil
+}
+
+func updateChainLkps(tx *sql.Tx, chain *scry.Chain, dirID SqliteID, event *scry.Event, tail *scry.Event) error {
+	chainID, err := toSqliteID(chain.ID.Encode())
+	if err != nil {
+		return err
+	}
+	if event.Type == scry.Create && tail != nil && tail.Type == scry.Rename {
+		// set old path for move finalizations
+		oldPath := tail.Path
+		event.OldPath = &oldPath
+		return moveChainPathLkp(tx, dirID, event.Path, tail.Path)
+	} else if event.Type == scry.Remove {
+		if err := deleteChainPathLkp(tx, dirID, event.Path); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM chain_ino WHERE ino = ?", int64(chain.Ino)); err != nil {
+			return err
+		}
+		_, err := tx.Exec("INSERT OR REPLACE INTO chain_removed (dir_id, path, chain_id) VALUES (?, ?, ?)", dirID, event.Path, chainID)
+		return err
+	}
+	_, found, err := getChainIDByPath(tx, dirID, event.Path)
+	if found || err != nil {
+		return err
+	}
+	return addChainPathLkp(tx, dirID, event.Path, chainID)
+}
+
+func addConflict(tx *sql.Tx, conflict *scry.Conflict, dirID SqliteID) error {
+	// check dir exists
+	dir, err := getDirByID(tx, dirID)
+	if dir == nil || err != nil {
+		return errors.New(fmt.Sprintf("Cannot add new conflict, nonexistent dir w/ id: %v", dirID))
+	}
+	chainID, err := toSqliteID(conflict.ChainID.Encode())
+	if err != nil {
+		return err
+	}
+	local, err := encodeConflictEvent(conflict.Local)
+	if err != nil {
+		return err
+	}
+	remote, err := encodeConflictEvent(conflict.Remote)
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec(
+		"INSERT INTO conflict (dir_id, chain_id, path, local, remote, detected) VALUES (?, ?, ?, ?, ?, ?)",
+		dirID, chainID, conflict.Path, local, remote, toNanos(conflict.Detected),
+	)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	conflict.ID = newID(id)
+	return nil
+}
+
+func removeConflict(tx *sql.Tx, conflictID SqliteID) error {
+	result, err := tx.Exec("DELETE FROM conflict WHERE id = ?", conflictID)
+	if err != nil {
+		return err
+	}
+	if removed, err := result.RowsAffected(); removed == 0 || err != nil {
+		return errors.New(fmt.Sprintf("Cannot remove conflict, nonexistent conflict w/ id: %v", conflictID))
+	}
+	return nil
+}
+
+// removeDir deletes a dir and everything that refers to it
+func removeDir(tx *sql.Tx, dirID SqliteID) error {
+	dir, err := getDirByID(tx, dirID)
+	if dir == nil || err != nil {
+		return errors.New(fmt.Sprintf("Cannot remove dir, nonexistent dir w/ id: %v", dirID))
+	}
+	for _, query := range []string{
+		"DELETE FROM chain_path WHERE dir_id = ?",
+		"DELETE FROM chain_removed WHERE dir_id = ?",
+		"DELETE FROM chain_ino WHERE chain_id IN (SELECT id FROM chain WHERE dir_id = ?)",
+		"DELETE FROM event WHERE chain_id IN (SELECT id FROM chain WHERE dir_id = ?)",
+		"DELETE FROM chain WHERE dir_id = ?",
+		"DELETE FROM conflict WHERE dir_id = ?",
+		"DELETE FROM dir WHERE id = ?",
+	} {
+		if _, err = tx.Exec(query, dirID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func pruneChain(tx *sql.Tx, chainID SqliteID, before time.Time) (int, error) {
+	chain, err := getChainByID(tx, chainID)
+	if chain == nil || err != nil {
+		return 0, errors.New(fmt.Sprintf("Cannot prune chain, nonexistent chain w/ id: %v", chainID))
+	}
+	events, err := getEventsInChain(tx, chainID)
+	if err != nil {
+		return 0, err
+	}
+	dropped := 0
+	for i, keep := range scry.KeepAfter(events, before) {
+		if keep {
+			continue
+		}
+		if _, err = tx.Exec("DELETE FROM event WHERE id = ?", *events[i].ID.(*SqliteID)); err != nil {
+			return 0, err
+		}
+		dropped++
+	}
+	return dropped, nil
+}
+
+func getDirByID(tx *sql.Tx, dirID SqliteID) (*scry.Dir, error) {
+	return scanDir(tx.QueryRow("SELECT id, path FROM dir WHERE id = ?", dirID))
+}
+
+func getDirByPath(tx *sql.Tx, path string) (*scry.Dir, error) {
+	return scanDir(tx.QueryRow("SELECT id, path FROM dir WHERE path = ?", path))
+}
+
+func getChainByID(tx *sql.Tx, chainID SqliteID) (*scry.Chain, error) {
+	chain, _, err := getChainAndDir(tx, chainID)
+	return chain, err
+}
+
+// getChainAndDir gets a chain and the ID of the dir it's in
+func getChainAndDir(tx *sql.Tx, chainID SqliteID) (*scry.Chain, SqliteID, error) {
+	var id, dirID, ino int64
+	err := tx.QueryRow("SELECT id, dir_id, ino FROM chain WHERE id = ?", chainID).Scan(&id, &dirID, &ino)
+	if err == sql.ErrNoRows {
+		return nil, 0, nil
+	} else if err != nil {
+		return nil, 0, err
+	}
+	return &scry.Chain{ID: newID(id), Ino: uint64(ino)}, SqliteID(dirID), nil
+}
+
+func getRemovedChainByPath(tx *sql.Tx, dirID SqliteID, path string) (*scry.Chain, error) {
+	var chainID SqliteID
+	err := tx.QueryRow("SELECT chain_id FROM chain_removed WHERE dir_id = ? AND path = ?", dirID, path).Scan(&chainID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return getChainByID(tx, chainID)
+}
+
+func getChainByIno(tx *sql.Tx, ino uint64) (*scry.Chain, error) {
+	var chainID int64
+	err := tx.QueryRow("SELECT chain_id FROM chain_ino WHERE ino = ?", int64(ino)).Scan(&chainID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return getChainByID(tx, SqliteID(chainID))
+}
+
+func getEventByID(tx *sql.Tx, eventID SqliteID) (*scry.Event, error) {
+	return scanEvent(tx.QueryRow("SELECT "+EVENT_COLUMNS+" FROM event WHERE id = ?", eventID))
+}
+
+func getEventPrev(tx *sql.Tx, eventID SqliteID) (*scry.Event, error) {
+	return scanEvent(tx.QueryRow(
+		"SELECT "+EVENT_COLUMNS+" FROM event WHERE chain_id = (SELECT chain_id FROM event WHERE id = ?) AND id < ? ORDER BY id DESC LIMIT 1",
+		eventID, eventID,
+	))
+}
+
+func getEventNext(tx *sql.Tx, eventID SqliteID) (*scry.Event, error) {
+	return scanEvent(tx.QueryRow(
+		"SELECT "+EVENT_COLUMNS+" FROM event WHERE chain_id = (SELECT chain_id FROM event WHERE id = ?) AND id > ? ORDER BY id LIMIT 1",
+		eventID, eventID,
+	))
+}
+
+func getChainHead(tx *sql.Tx, chainID SqliteID) (*scry.Event, error) {
+	return scanEvent(tx.QueryRow("SELECT "+EVENT_COLUMNS+" FROM event WHERE chain_id = ? ORDER BY id LIMIT 1", chainID))
+}
+
+func getChainTail(tx *sql.Tx, chainID SqliteID) (*scry.Event, error) {
+	return scanEvent(tx.QueryRow("SELECT "+EVENT_COLUMNS+" FROM event WHERE chain_id = ? ORDER BY id DESC LIMIT 1", chainID))
+}
+
+func getEventsInChain(tx *sql.Tx, chainID SqliteID) ([]scry.Event, error) {
+	rows, err := tx.Query("SELECT "+EVENT_COLUMNS+" FROM event WHERE chain_id = ? ORDER BY id", chainID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := make([]scry.Event, 0)
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, *event)
+	}
+	return events, rows.Err()
+}
+
+func getConflictByID(tx *sql.Tx, conflictID SqliteID) (*scry.Conflict, error) {
+	return scanConflict(tx.QueryRow("SELECT id, chain_id, path, local, remote, detected FROM conflict WHERE id = ?", conflictID))
+}
+
+func getStatEntry(tx *sql.Tx, ino uint64) (*fnode.StatEntry, error) {
+	var entryIno, size int64
+	var modTime, ctime sql.NullInt64
+	var hash string
+	var chunks sql.NullString
+	err := tx.QueryRow(QUERY_STAT, sql.Named("ino", int64(ino))).Scan(&entryIno, &size, &modTime, &ctime, &hash, &chunks)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	entry := &fnode.StatEntry{
+		Ino:     uint64(entryIno),
+		Size:    uint64(size),
+		ModTime: fromNanos(modTime),
+		Ctime:   fromNanos(ctime),
+		Hash:    hash,
+	}
+	if entry.Chunks, err = decodeJSON[[]fnode.Chunk](chunks); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func putStatEntry(tx *sql.Tx, entry *fnode.StatEntry) error {
+	chunks, err := encodeJSON(entry.Chunks)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(UPSERT_STAT,
+		sql.Named("ino", int64(entry.Ino)),
+		sql.Named("size", int64(entry.Size)),
+		sql.Named("modtime", toNanos(entry.ModTime)),
+		sql.Named("ctime", toNanos(entry.Ctime)),
+		sql.Named("hash", entry.Hash),
+		sql.Named("chunks", chunks),
+	)
+	return err
+}
+
+func scanDir(row scanner) (*scry.Dir, error) {
+	var id int64
+	var path string
+	if err := row.Scan(&id, &path); err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &scry.Dir{ID: newID(id), Path: path}, nil
+}
+
+// scanEvent scans a row of EVENT_COLUMNS, nil if there's no row
+func scanEvent(row scanner) (*scry.Event, error) {
+	var id, size int64
+	var eventType, mode, uid, gid uint32
+	var timestamp, modTime sql.NullInt64
+	var path, target, origin string
+	var oldPath, hash, chunks, version sql.NullString
+	err := row.Scan(&id, &eventType, &timestamp, &path, &oldPath, &modTime, &hash, &size, &mode, &uid, &gid, &target, &chunks, &origin, &version)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	event := &scry.Event{
+		ID:        newID(id),
+		Timestamp: fromNanos(timestamp),
+		Path:      path,
+		Type:      scry.EventType(eventType),
+		Size:      uint64(size),
+		ModTime:   fromNanos(modTime),
+		Mode:      os.FileMode(mode),
+		Uid:       uid,
+		Gid:       gid,
+		Target:    target,
+		Origin:    origin,
+	}
+	if oldPath.Valid {
+		event.OldPath = &oldPath.String
+	}
+	if hash.Valid {
+		event.Hash = &hash.String
+	}
+	if event.Chunks, err = decodeJSON[[]fnode.Chunk](chunks); err != nil {
+		return nil, err
+	}
+	if event.Version, err = decodeJSON[scry.VersionVector](version); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+func scanConflict(row scanner) (*scry.Conflict, error) {
+	var id, chainID int64
+	var path, local, remote string
+	var detected sql.NullInt64
+	if err := row.Scan(&id, &chainID, &path, &local, &remote, &detected); err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	conflict := &scry.Conflict{ID: newID(id), ChainID: newID(chainID), Path: path, Detected: fromNanos(detected)}
+	if err := json.Unmarshal([]byte(local), &conflict.Local); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(remote), &conflict.Remote); err != nil {
+		return nil, err
+	}
+	return conflict, nil
+}
+
+// conflict events are kept as json w/o their IDs
+func encodeConflictEvent(event scry.Event) (string, error) {
+	event.ID = nil
+	data, err := json.Marshal(event)
+	return string(data), err
+}
+
+// times are stored as unix nanoseconds, the zero time as NULL
+func toNanos(t time.Time) sql.NullInt64 {
+	if t.IsZero() {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: t.UnixNano(), Valid: true}
+}
+
+func fromNanos(nanos sql.NullInt64) time.Time {
+	if !nanos.Valid {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos.Int64)
+}
+
+// encodeJSON encodes v as json, nil (slices, maps, etc.) as NULL
+func encodeJSON(v any) (sql.NullString, error) {
+	data, err := json.Marshal(v)
+	if err != nil || string(data) == "null" {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+func decodeJSON[T any](data sql.NullString) (T, error) {
+	var v T
+	if !data.Valid {
+		return v, nil
+	}
+	err := json.Unmarshal([]byte(data.String), &v)
+	return v, err
+}
@@ -0,0 +1,737 @@
+package storetest
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/ceejimus/kusari/fnode"
+	"github.com/ceejimus/kusari/scry"
+	"github.com/stretchr/testify/assert"
+)
+
+// Factory opens a new, empty store, it's closed by the suite when the test ends
+type Factory func(t *testing.T) scry.EventStore
+
+// Run checks a store against the semantics documented on scry.EventStore
+// each case gets its own store from newStore
+func Run(t *testing.T, newStore Factory) {
+	cases := []struct {
+		name string
+		run  func(*testing.T, scry.EventStore)
+	}{
+		{"Dirs", testDirs},
+		{"Chains", testChains},
+		{"Events", testEvents},
+		{"WalkChain", testWalkChain},
+		{"RemovedPath", testRemovedPath},
+		{"MovedNode", testMovedNode},
+		{"MovedSubdir", testMovedSubdir},
+		{"InoReuse", testInoReuse},
+		{"SetChainIno", testSetChainIno},
+		{"Conflicts", testConflicts},
+		{"StatEntries", testStatEntries},
+		{"RemoveDir", testRemoveDir},
+		{"PruneChain", testPruneChain},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			store := newStore(t)
+			t.Cleanup(func() { store.Close() })
+			c.run(t, store)
+		})
+	}
+}
+
+// an ID no store should have generated
+type missingID struct{}
+
+func (id missingID) Encode() []byte {
+	return []byte{0, 0, 0, 0, 0, 1, 0, 0}
+}
+
+func testDirs(t *testing.T, store scry.EventStore) {
+	dirs, err := store.GetDirs()
+	assert.NoError(t, err)
+	assert.Empty(t, dirs)
+
+	a := addDir(t, store, "a")
+	b := addDir(t, store, "b/c")
+	assert.False(t, sameID(a.ID, b.ID))
+
+	// adding a dir w/ an existing path or an ID errors
+	assert.Error(t, store.AddDir(&scry.Dir{Path: "a"}))
+	assert.Error(t, store.AddDir(&scry.Dir{ID: a.ID, Path: "d"}))
+
+	for _, want := range []*scry.Dir{a, b} {
+		got, err := store.GetDirByID(want.ID)
+		assert.NoError(t, err)
+		assertDir(t, want, got)
+		got, err = store.GetDirByPath(want.Path)
+		assert.NoError(t, err)
+		assertDir(t, want, got)
+	}
+
+	dirs, err = store.GetDirs()
+	assert.NoError(t, err)
+	if assert.Len(t, dirs, 2) {
+		assertDir(t, a, &dirs[0])
+		assertDir(t, b, &dirs[1])
+	}
+
+	got, err := store.GetDirByID(missingID{})
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+	got, err = store.GetDirByPath("d")
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+}
+
+func testChains(t *testing.T, store scry.EventStore) {
+	a := addDir(t, store, "a")
+	b := addDir(t, store, "b")
+	c1 := addChain(t, store, a, 1)
+	c2 := addChain(t, store, b, 2)
+	c3 := addChain(t, store, a, 3)
+
+	// adding a chain w/ an ID or to a nonexistent dir errors
+	assert.Error(t, store.AddChain(&scry.Chain{ID: c1.ID, Ino: 4}, a.ID))
+	assert.Error(t, store.AddChain(&scry.Chain{Ino: 4}, missingID{}))
+
+	for _, want := range []*scry.Chain{c1, c2, c3} {
+		got, err := store.GetChainByID(want.ID)
+		assert.NoError(t, err)
+		assertChain(t, want, got)
+		got, err = store.GetChainByIno(want.Ino)
+		assert.NoError(t, err)
+		assertChain(t, want, got)
+	}
+
+	chains, err := store.GetChainsInDir(a.ID)
+	assert.NoError(t, err)
+	if assert.Len(t, chains, 2) {
+		assertChain(t, c1, &chains[0])
+		assertChain(t, c3, &chains[1])
+	}
+
+	got, err := store.GetChainByID(missingID{})
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+	got, err = store.GetChainByIno(4)
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+}
+
+func testEvents(t *testing.T, store scry.EventStore) {
+	dir := addDir(t, store, "d")
+	chain := addChain(t, store, dir, 1)
+	ts := time.Now().Add(-time.Hour)
+
+	// every field should come back as it went in
+	want := scry.Event{
+		Timestamp: ts,
+		Path:      "f",
+		Type:      scry.Create,
+		Size:      3,
+		Hash:      strPtr("h1"),
+		ModTime:   ts.Add(-time.Minute),
+		Mode:      0640,
+		Uid:       1000,
+		Gid:       1001,
+		Chunks:    []fnode.Chunk{{Hash: "c1", Size: 1}, {Hash: "c2", Size: 2}},
+		Origin:    "node-a",
+		Version:   scry.VersionVector{"node-a": 1, "node-b": 2},
+	}
+	event := want
+	if err := store.AddEvent(&event, chain.ID); err != nil {
+		t.Fatal(err)
+	}
+	assert.NotNil(t, event.ID)
+	got, err := store.GetEventByID(event.ID)
+	assert.NoError(t, err)
+	want.ID = event.ID
+	assertEvent(t, &want, got)
+
+	link := scry.Event{Timestamp: ts, Path: "l", Type: scry.Create, Target: "f"}
+	addEvent(t, store, addChain(t, store, dir, 2), &link)
+	got, err = store.GetEventByID(link.ID)
+	assert.NoError(t, err)
+	assertEvent(t, &link, got)
+	assert.False(t, sameID(event.ID, link.ID))
+
+	// adding an event w/ an ID or to a nonexistent chain errors
+	assert.Error(t, store.AddEvent(&scry.Event{ID: event.ID, Path: "f", Type: scry.Write}, chain.ID))
+	assert.Error(t, store.AddEvent(&scry.Event{Path: "g", Type: scry.Create}, missingID{}))
+
+	got, err = store.GetEventByID(missingID{})
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+}
+
+func testWalkChain(t *testing.T, store scry.EventStore) {
+	dir := addDir(t, store, "d")
+	a := addChain(t, store, dir, 1)
+	b := addChain(t, store, dir, 2)
+	// interleave events from two chains so their IDs aren't contiguous
+	aEvents := []*scry.Event{
+		{Path: "a", Type: scry.Create, Hash: strPtr("a1")},
+		{Path: "a", Type: scry.Write, Hash: strPtr("a2")},
+		{Path: "a", Type: scry.Chmod, Hash: strPtr("a2")},
+	}
+	bEvents := []*scry.Event{
+		{Path: "b", Type: scry.Create, Hash: strPtr("b1")},
+		{Path: "b", Type: scry.Write, Hash: strPtr("b2")},
+	}
+	addEvent(t, store, a, aEvents[0])
+	addEvent(t, store, b, bEvents[0])
+	addEvent(t, store, a, aEvents[1])
+	addEvent(t, store, b, bEvents[1])
+	addEvent(t, store, a, aEvents[2])
+
+	assertEventsInChain(t, store, a, aEvents)
+	assertEventsInChain(t, store, b, bEvents)
+
+	// a chain w/o events has no head or tail
+	empty := addChain(t, store, dir, 3)
+	head, err := store.GetChainHead(empty.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, head)
+	tail, err := store.GetChainTail(empty.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, tail)
+	events, err := store.GetEventsInChain(empty.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, events)
+}
+
+func testRemovedPath(t *testing.T, store scry.EventStore) {
+	dir := addDir(t, store, "d")
+	chain := addChain(t, store, dir, 1)
+	addEvent(t, store, chain, &scry.Event{Path: "f", Type: scry.Create})
+	assertChainAt(t, store, dir, "f", chain)
+	addEvent(t, store, chain, &scry.Event{Path: "f", Type: scry.Write})
+	assertChainAt(t, store, dir, "f", chain)
+
+	assertRemovedChainAt(t, store, dir, "f", nil)
+
+	// removed nodes aren't found by path or ino
+	addEvent(t, store, chain, &scry.Event{Path: "f", Type: scry.Remove})
+	assertChainAt(t, store, dir, "f", nil)
+	assertChainWithIno(t, store, 1, nil)
+	assertRemovedChainAt(t, store, dir, "f", chain)
+
+	// until a new node re-uses the name
+	reused := addChain(t, store, dir, 2)
+	addEvent(t, store, reused, &scry.Event{Path: "f", Type: scry.Create})
+	assertChainAt(t, store, dir, "f", reused)
+	assertChainWithIno(t, store, 2, reused)
+	assertRemovedChainAt(t, store, dir, "f", chain)
+
+	// the node removed last wins
+	addEvent(t, store, reused, &scry.Event{Path: "f", Type: scry.Remove})
+	assertRemovedChainAt(t, store, dir, "f", reused)
+	other := addDir(t, store, "e")
+	assertRemovedChainAt(t, store, other, "f", nil)
+}
+
+func testMovedNode(t *testing.T, store scry.EventStore) {
+	dir := addDir(t, store, "d")
+	chain := addChain(t, store, dir, 1)
+	addEvent(t, store, chain, &scry.Event{Path: "f", Type: scry.Create})
+	addEvent(t, store, chain, &scry.Event{Path: "f", Type: scry.Rename})
+	// the node is still at its old path until the move is finalized
+	assertChainAt(t, store, dir, "f", chain)
+
+	created := scry.Event{Path: "g", Type: scry.Create}
+	addEvent(t, store, chain, &created)
+	// finalizing a move sets the old path
+	if assert.NotNil(t, created.OldPath) {
+		assert.Equal(t, "f", *created.OldPath)
+	}
+	got, err := store.GetEventByID(created.ID)
+	assert.NoError(t, err)
+	assertEvent(t, &created, got)
+	assertChainAt(t, store, dir, "f", nil)
+	assertChainAt(t, store, dir, "g", chain)
+	assertChainWithIno(t, store, 1, chain)
+
+	// other creates don't have old paths
+	other := scry.Event{Path: "h", Type: scry.Create}
+	addEvent(t, store, addChain(t, store, dir, 2), &other)
+	assert.Nil(t, other.OldPath)
+}
+
+func testMovedSubdir(t *testing.T, store scry.EventStore) {
+	dir := addDir(t, store, "d")
+	s := addChain(t, store, dir, 1)
+	f := addChain(t, store, dir, 2)
+	addEvent(t, store, s, &scry.Event{Path: "s1", Type: scry.Create})
+	addEvent(t, store, f, &scry.Event{Path: "s1/f", Type: scry.Create})
+	assertChainAt(t, store, dir, "s1/f", f)
+
+	// moving s1 -> s2 moves s1/f -> s2/f w/o any events on f
+	addEvent(t, store, s, &scry.Event{Path: "s1", Type: scry.Rename})
+	addEvent(t, store, s, &scry.Event{Path: "s2", Type: scry.Create})
+	assertChainAt(t, store, dir, "s2", s)
+	assertChainAt(t, store, dir, "s2/f", f)
+	assertChainAt(t, store, dir, "s1", nil)
+	assertChainAt(t, store, dir, "s1/f", nil)
+
+	// a new s1 doesn't get the old one's children
+	s1 := addChain(t, store, dir, 3)
+	addEvent(t, store, s1, &scry.Event{Path: "s1", Type: scry.Create})
+	assertChainAt(t, store, dir, "s1", s1)
+	assertChainAt(t, store, dir, "s1/f", nil)
+
+	// paths are kept per dir
+	other := addDir(t, store, "e")
+	assertChainAt(t, store, other, "s2", nil)
+	assertChainAt(t, store, other, "s2/f", nil)
+}
+
+func testInoReuse(t *testing.T, store scry.EventStore) {
+	dir := addDir(t, store, "d")
+	a := addChain(t, store, dir, 1)
+	addEvent(t, store, a, &scry.Event{Path: "a", Type: scry.Create})
+	addEvent(t, store, a, &scry.Event{Path: "a", Type: scry.Remove})
+
+	// a new node w/ the same inode gets a new chain
+	b := addChain(t, store, dir, 1)
+	addEvent(t, store, b, &scry.Event{Path: "b", Type: scry.Create})
+	assert.False(t, sameID(a.ID, b.ID))
+	assertChainWithIno(t, store, 1, b)
+	assertChainAt(t, store, dir, "a", nil)
+	assertChainAt(t, store, dir, "b", b)
+
+	// the old chain keeps its history
+	events, err := store.GetEventsInChain(a.ID)
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+}
+
+func testSetChainIno(t *testing.T, store scry.EventStore) {
+	dir := addDir(t, store, "d")
+	a := addChain(t, store, dir, 1)
+	addEvent(t, store, a, &scry.Event{Path: "a", Type: scry.Create})
+
+	// the chain is found by its new ino and not the old one
+	if err := store.SetChainIno(a.ID, 2); err != nil {
+		t.Fatal(err)
+	}
+	a.Ino = 2
+	assertChainWithIno(t, store, 2, a)
+	assertChainWithIno(t, store, 1, nil)
+	got, err := store.GetChainByID(a.ID)
+	assert.NoError(t, err)
+	assertChain(t, a, got)
+	assertChainAt(t, store, dir, "a", a)
+
+	// moving off an ino another chain took over leaves it alone
+	b := addChain(t, store, dir, 2)
+	if err := store.SetChainIno(a.ID, 3); err != nil {
+		t.Fatal(err)
+	}
+	a.Ino = 3
+	assertChainWithIno(t, store, 2, b)
+	assertChainWithIno(t, store, 3, a)
+
+	assert.Error(t, store.SetChainIno(missingID{}, 4))
+}
+
+func testConflicts(t *testing.T, store scry.EventStore) {
+	a := addDir(t, store, "a")
+	b := addDir(t, store, "b")
+	chain := addChain(t, store, a, 1)
+	local := scry.Event{Path: "f", Type: scry.Write, Hash: strPtr("l"), Origin: "local", Version: scry.VersionVector{"local": 2}}
+	addEvent(t, store, chain, &local)
+	remote := scry.Event{Path: "f", Type: scry.Write, Hash: strPtr("r"), Origin: "remote", Version: scry.VersionVector{"local": 1, "remote": 1}}
+	detected := time.Now()
+
+	c1 := &scry.Conflict{ChainID: chain.ID, Path: "f", Local: local, Remote: remote, Detected: detected}
+	if err := store.AddConflict(c1, a.ID); err != nil {
+		t.Fatal(err)
+	}
+	assert.NotNil(t, c1.ID)
+	c2 := &scry.Conflict{ChainID: chain.ID, Path: "f", Local: local, Remote: remote, Detected: detected.Add(time.Second)}
+	if err := store.AddConflict(c2, a.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// adding a conflict w/ an ID or to a nonexistent dir errors
+	assert.Error(t, store.AddConflict(&scry.Conflict{ID: c1.ID, ChainID: chain.ID, Path: "f"}, a.ID))
+	assert.Error(t, store.AddConflict(&scry.Conflict{ChainID: chain.ID, Path: "f"}, missingID{}))
+
+	// conflict events are kept w/o their IDs
+	got, err := store.GetConflictByID(c1.ID)
+	assert.NoError(t, err)
+	if assert.NotNil(t, got) {
+		assert.True(t, sameID(c1.ID, got.ID))
+		assert.True(t, sameID(chain.ID, got.ChainID))
+		assert.Equal(t, "f", got.Path)
+		assert.True(t, detected.Equal(got.Detected))
+		wantLocal := local
+		wantLocal.ID = nil
+		assertEvent(t, &wantLocal, &got.Local)
+		assertEvent(t, &remote, &got.Remote)
+	}
+
+	conflicts, err := store.GetConflictsInDir(a.ID)
+	assert.NoError(t, err)
+	if assert.Len(t, conflicts, 2) {
+		assert.True(t, sameID(c1.ID, conflicts[0].ID))
+		assert.True(t, sameID(c2.ID, conflicts[1].ID))
+	}
+	conflicts, err = store.GetConflictsInDir(b.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, conflicts)
+
+	if err := store.RemoveConflict(c1.ID); err != nil {
+		t.Fatal(err)
+	}
+	got, err = store.GetConflictByID(c1.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+	conflicts, err = store.GetConflictsInDir(a.ID)
+	assert.NoError(t, err)
+	if assert.Len(t, conflicts, 1) {
+		assert.True(t, sameID(c2.ID, conflicts[0].ID))
+	}
+
+	got, err = store.GetConflictByID(missingID{})
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+}
+
+func testStatEntries(t *testing.T, store scry.EventStore) {
+	got, err := store.GetStatEntry(1)
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+
+	now := time.Now()
+	entry := fnode.StatEntry{Ino: 1, Size: 3, ModTime: now.Add(-time.Minute), Ctime: now, Hash: "h1"}
+	if err := store.PutStatEntry(&entry); err != nil {
+		t.Fatal(err)
+	}
+	got, err = store.GetStatEntry(1)
+	assert.NoError(t, err)
+	assertStatEntry(t, &entry, got)
+
+	// putting an entry for the same ino replaces it
+	replaced := fnode.StatEntry{Ino: 1, Size: 4, ModTime: now, Ctime: now, Hash: "h2", Chunks: []fnode.Chunk{{Hash: "c1", Size: 4}}}
+	if err := store.PutStatEntry(&replaced); err != nil {
+		t.Fatal(err)
+	}
+	got, err = store.GetStatEntry(1)
+	assert.NoError(t, err)
+	assertStatEntry(t, &replaced, got)
+
+	// inos as big as they get
+	big := fnode.StatEntry{Ino: 1 << 63, Size: 1, ModTime: now, Ctime: now, Hash: "h3"}
+	if err := store.PutStatEntry(&big); err != nil {
+		t.Fatal(err)
+	}
+	got, err = store.GetStatEntry(1 << 63)
+	assert.NoError(t, err)
+	assertStatEntry(t, &big, got)
+}
+
+func testRemoveDir(t *testing.T, store scry.EventStore) {
+	a := addDir(t, store, "a")
+	b := addDir(t, store, "b")
+	s := addChain(t, store, a, 1)
+	f := addChain(t, store, a, 2)
+	fEvent := scry.Event{Path: "s/f", Type: scry.Create}
+	addEvent(t, store, s, &scry.Event{Path: "s", Type: scry.Create})
+	addEvent(t, store, f, &fEvent)
+	addEvent(t, store, s, &scry.Event{Path: "s", Type: scry.Rename})
+	addEvent(t, store, s, &scry.Event{Path: "t", Type: scry.Create})
+	conflict := &scry.Conflict{ChainID: f.ID, Path: "t/f", Local: fEvent, Remote: scry.Event{Path: "t/f", Type: scry.Write}}
+	if err := store.AddConflict(conflict, a.ID); err != nil {
+		t.Fatal(err)
+	}
+	r := addChain(t, store, a, 3)
+	addEvent(t, store, r, &scry.Event{Path: "r", Type: scry.Create})
+	addEvent(t, store, r, &scry.Event{Path: "r", Type: scry.Remove})
+	// b's chain takes over ino 2 (like a file moved between dirs)
+	g := addChain(t, store, b, 2)
+	addEvent(t, store, g, &scry.Event{Path: "f", Type: scry.Create})
+
+	if err := store.RemoveDir(a.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing in a is found
+	got, err := store.GetDirByID(a.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+	got, err = store.GetDirByPath("a")
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+	for _, chain := range []*scry.Chain{s, f} {
+		got, err := store.GetChainByID(chain.ID)
+		assert.NoError(t, err)
+		assert.Nil(t, got)
+	}
+	assertChainWithIno(t, store, 1, nil)
+	event, err := store.GetEventByID(fEvent.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, event)
+	gotConflict, err := store.GetConflictByID(conflict.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, gotConflict)
+
+	// everything in b is
+	dirs, err := store.GetDirs()
+	assert.NoError(t, err)
+	if assert.Len(t, dirs, 1) {
+		assertDir(t, b, &dirs[0])
+	}
+	assertChainAt(t, store, b, "f", g)
+	assertChainWithIno(t, store, 2, g)
+
+	// a new dir w/ the same path starts empty
+	newA := addDir(t, store, "a")
+	assert.False(t, sameID(a.ID, newA.ID))
+	chains, err := store.GetChainsInDir(newA.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, chains)
+	conflicts, err := store.GetConflictsInDir(newA.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, conflicts)
+	assertChainAt(t, store, newA, "t", nil)
+	assertChainAt(t, store, newA, "t/f", nil)
+	assertRemovedChainAt(t, store, newA, "r", nil)
+
+	// removing a nonexistent dir errors
+	assert.Error(t, store.RemoveDir(a.ID))
+	assert.Error(t, store.RemoveDir(missingID{}))
+}
+
+func testPruneChain(t *testing.T, store scry.EventStore) {
+	t0 := time.Now().Add(-time.Hour)
+	at := func(minutes int) time.Time { return t0.Add(time.Duration(minutes) * time.Minute) }
+
+	dir := addDir(t, store, "d")
+	chain := addChain(t, store, dir, 1)
+	events := []*scry.Event{
+		{Timestamp: at(1), Path: "f", Type: scry.Create, Hash: strPtr("v1")},
+		{Timestamp: at(2), Path: "f", Type: scry.Write, Hash: strPtr("v2")},
+		{Timestamp: at(3), Path: "f", Type: scry.Rename},
+		{Timestamp: at(4), Path: "g", Type: scry.Create, Hash: strPtr("v2")},
+		{Timestamp: at(5), Path: "g", Type: scry.Write, Hash: strPtr("v3")},
+		{Timestamp: at(6), Path: "g", Type: scry.Write, Hash: strPtr("v4")},
+	}
+	for _, event := range events {
+		addEvent(t, store, chain, event)
+	}
+
+	// the finalized move is kept w/ everything from at(5) on
+	dropped, err := store.PruneChain(chain.ID, at(5))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, dropped)
+	assertEventsInChain(t, store, chain, events[3:])
+	for _, event := range events[:3] {
+		got, err := store.GetEventByID(event.ID)
+		assert.NoError(t, err)
+		assert.Nil(t, got)
+	}
+	assertChainAt(t, store, dir, "g", chain)
+	assertChainWithIno(t, store, 1, chain)
+
+	// pruning everything keeps what's pinned (the move and the tail)
+	dropped, err = store.PruneChain(chain.ID, at(10))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, dropped)
+	assertEventsInChain(t, store, chain, []*scry.Event{events[3], events[5]})
+	dropped, err = store.PruneChain(chain.ID, at(10))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, dropped)
+
+	// new events go on the end of what's left
+	next := scry.Event{Timestamp: at(7), Path: "g", Type: scry.Write, Hash: strPtr("v5")}
+	addEvent(t, store, chain, &next)
+	assertEventsInChain(t, store, chain, []*scry.Event{events[3], events[5], &next})
+
+	empty := addChain(t, store, dir, 2)
+	dropped, err = store.PruneChain(empty.ID, at(10))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, dropped)
+
+	_, err = store.PruneChain(missingID{}, at(10))
+	assert.Error(t, err)
+}
+
+// assertEventsInChain checks the events in a chain and walking it both ways
+func assertEventsInChain(t *testing.T, store scry.EventStore, chain *scry.Chain, want []*scry.Event) {
+	t.Helper()
+	events, err := store.GetEventsInChain(chain.ID)
+	assert.NoError(t, err)
+	if !assert.Len(t, events, len(want)) {
+		return
+	}
+	for i := range want {
+		assertEvent(t, want[i], &events[i])
+	}
+	head, err := store.GetChainHead(chain.ID)
+	assert.NoError(t, err)
+	assertEvent(t, want[0], head)
+	tail, err := store.GetChainTail(chain.ID)
+	assert.NoError(t, err)
+	assertEvent(t, want[len(want)-1], tail)
+	for i, event := range want {
+		prev, err := store.GetPrevEvent(event.ID)
+		assert.NoError(t, err)
+		if i == 0 {
+			assert.Nil(t, prev)
+		} else {
+			assertEvent(t, want[i-1], prev)
+		}
+		next, err := store.GetNextEvent(event.ID)
+		assert.NoError(t, err)
+		if i == len(want)-1 {
+			assert.Nil(t, next)
+		} else {
+			assertEvent(t, want[i+1], next)
+		}
+	}
+}
+
+func addDir(t *testing.T, store scry.EventStore, path string) *scry.Dir {
+	dir := &scry.Dir{Path: path}
+	if err := store.AddDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if dir.ID == nil {
+		t.Fatalf("AddDir didn't set the ID of %v", dir)
+	}
+	return dir
+}
+
+func addChain(t *testing.T, store scry.EventStore, dir *scry.Dir, ino uint64) *scry.Chain {
+	chain := &scry.Chain{Ino: ino}
+	if err := store.AddChain(chain, dir.ID); err != nil {
+		t.Fatal(err)
+	}
+	if chain.ID == nil {
+		t.Fatalf("AddChain didn't set the ID of %v", chain)
+	}
+	return chain
+}
+
+// add an event, timestamped now unless it already is
+func addEvent(t *testing.T, store scry.EventStore, chain *scry.Chain, event *scry.Event) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	if err := store.AddEvent(event, chain.ID); err != nil {
+		t.Fatal(err)
+	}
+	if event.ID == nil {
+		t.Fatalf("AddEvent didn't set the ID of %v", event)
+	}
+}
+
+func assertChainAt(t *testing.T, store scry.EventStore, dir *scry.Dir, path string, want *scry.Chain) {
+	t.Helper()
+	got, err := store.GetChainByPath(dir.ID, path)
+	assert.NoError(t, err)
+	if want == nil {
+		assert.Nil(t, got, "chain @ %q", path)
+		return
+	}
+	if assert.NotNil(t, got, "chain @ %q", path) {
+		assert.True(t, sameID(want.ID, got.ID), "chain @ %q: wanted %v, got %v", path, want, got)
+	}
+}
+
+func assertRemovedChainAt(t *testing.T, store scry.EventStore, dir *scry.Dir, path string, want *scry.Chain) {
+	t.Helper()
+	got, err := store.GetRemovedChainByPath(dir.ID, path)
+	assert.NoError(t, err)
+	if want == nil {
+		assert.Nil(t, got, "removed chain @ %q", path)
+		return
+	}
+	if assert.NotNil(t, got, "removed chain @ %q", path) {
+		assert.True(t, sameID(want.ID, got.ID), "removed chain @ %q: wanted %v, got %v", path, want, got)
+	}
+}
+
+func assertChainWithIno(t *testing.T, store scry.EventStore, ino uint64, want *scry.Chain) {
+	t.Helper()
+	got, err := store.GetChainByIno(ino)
+	assert.NoError(t, err)
+	if want == nil {
+		assert.Nil(t, got, "chain w/ ino %d", ino)
+		return
+	}
+	if assert.NotNil(t, got, "chain w/ ino %d", ino) {
+		assert.True(t, sameID(want.ID, got.ID), "chain w/ ino %d: wanted %v, got %v", ino, want, got)
+	}
+}
+
+func assertDir(t *testing.T, want *scry.Dir, got *scry.Dir) {
+	t.Helper()
+	if !assert.NotNil(t, got) {
+		return
+	}
+	assert.True(t, sameID(want.ID, got.ID), "wanted %v, got %v", want, got)
+	assert.Equal(t, want.Path, got.Path)
+}
+
+func assertChain(t *testing.T, want *scry.Chain, got *scry.Chain) {
+	t.Helper()
+	if !assert.NotNil(t, got) {
+		return
+	}
+	assert.True(t, sameID(want.ID, got.ID), "wanted %v, got %v", want, got)
+	assert.Equal(t, want.Ino, got.Ino)
+}
+
+// times are compared w/ Equal since stores don't have to keep locations or monotonic readings
+func assertEvent(t *testing.T, want *scry.Event, got *scry.Event) {
+	t.Helper()
+	if !assert.NotNil(t, got) {
+		return
+	}
+	assert.True(t, sameID(want.ID, got.ID), "wanted %v, got %v", want, got)
+	assert.True(t, want.Timestamp.Equal(got.Timestamp), "wanted timestamp %v, got %v", want.Timestamp, got.Timestamp)
+	assert.True(t, want.ModTime.Equal(got.ModTime), "wanted mod time %v, got %v", want.ModTime, got.ModTime)
+	assert.Equal(t, want.Path, got.Path)
+	assert.Equal(t, want.OldPath, got.OldPath)
+	assert.Equal(t, want.Type, got.Type)
+	assert.Equal(t, want.Size, got.Size)
+	assert.Equal(t, want.Hash, got.Hash)
+	assert.Equal(t, want.Mode, got.Mode)
+	assert.Equal(t, want.Uid, got.Uid)
+	assert.Equal(t, want.Gid, got.Gid)
+	assert.Equal(t, want.Target, got.Target)
+	assert.Equal(t, want.Chunks, got.Chunks)
+	assert.Equal(t, want.Origin, got.Origin)
+	assert.Equal(t, want.Version, got.Version)
+}
+
+func assertStatEntry(t *testing.T, want *fnode.StatEntry, got *fnode.StatEntry) {
+	t.Helper()
+	if !assert.NotNil(t, got) {
+		return
+	}
+	assert.Equal(t, want.Ino, got.Ino)
+	assert.Equal(t, want.Size, got.Size)
+	assert.True(t, want.ModTime.Equal(got.ModTime), "wanted mod time %v, got %v", want.ModTime, got.ModTime)
+	assert.True(t, want.Ctime.Equal(got.Ctime), "wanted ctime %v, got %v", want.Ctime, got.Ctime)
+	assert.Equal(t, want.Hash, got.Hash)
+	assert.Equal(t, want.Chunks, got.Chunks)
+}
+
+// IDs of different stores are different types, compare what they encode to
+func sameID(a scry.ID, b scry.ID) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return bytes.Equal(a.Encode(), b.Encode())
+}
+
+func strPtr(s string) *string {
+	return &s
+}
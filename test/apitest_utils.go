@@ -11,7 +11,9 @@ import (
 	"github.com/ceejimus/kusari/badgerstore"
 	"github.com/ceejimus/kusari/fnode"
 	"github.com/ceejimus/kusari/logger"
+	"github.com/ceejimus/kusari/memstore"
 	"github.com/ceejimus/kusari/scry"
+	"github.com/ceejimus/kusari/sqlitestore"
 	"github.com/ceejimus/kusari/utils"
 	"github.com/stretchr/testify/assert"
 )
@@ -21,6 +23,12 @@ type Chains []Chain                                      // a slice of chains ex
 type TailPathToChainMap map[string]Chains                // a map of final paths to expected chains
 type DirPathToTailChainMap map[string]TailPathToChainMap // a map of dir names to path -> chains lookup
 
+// how long the scryer has to go w/o new events before it's caught up w/ an action
+const SETTLE_QUIET = 50 * time.Millisecond
+
+// how long a (slow) store gets to catch up w/ an action before the test fails
+const SETTLE_TIMEOUT = 10 * time.Second
+
 // this code was mostly stolen from watcher.go
 func setupStoreFromLocalState(tmpFs *utils.TmpFs, scryDirs []scry.ScriedDirectory, store scry.EventStore) error {
 	for _, dir := range scryDirs {
@@ -58,6 +66,9 @@ func setupStoreFromLocalState(tmpFs *utils.TmpFs, scryDirs []scry.ScriedDirector
 				Size:      state.Size,
 				Hash:      state.Hash,
 				ModTime:   state.ModTime,
+				Mode:      state.Mode,
+				Uid:       state.Uid,
+				Gid:       state.Gid,
 			}
 
 			err = store.AddEvent(&event, chain.ID)
@@ -70,7 +81,37 @@ func setupStoreFromLocalState(tmpFs *utils.TmpFs, scryDirs []scry.ScriedDirector
 	return nil
 }
 
+// the stores the api tests are run against, each opened in an empty dir
+var testStores = []struct {
+	name string
+	open func(dbDir string) (scry.EventStore, error)
+}{
+	{"badger", func(dbDir string) (scry.EventStore, error) {
+		return badgerstore.NewBadgerStore(dbDir)
+	}},
+	{"mem", func(dbDir string) (scry.EventStore, error) {
+		return memstore.NewMemStore(), nil
+	}},
+	{"sqlite", func(dbDir string) (scry.EventStore, error) {
+		if err := os.MkdirAll(dbDir, 0755); err != nil {
+			return nil, err
+		}
+		return sqlitestore.NewSqliteStore(filepath.Join(dbDir, "kusari.db"))
+	}},
+}
+
+// run an api test once per store, each w/ its own fs
 func runApiTest(t *testing.T, tmpFs *utils.TmpFs, watchedDirPaths []string, actions []utils.FsAction, wantedMap DirPathToTailChainMap) {
+	for _, testStore := range testStores {
+		t.Run(testStore.name, func(t *testing.T) {
+			storeFs := *tmpFs
+			storeActions := append([]utils.FsAction{}, actions...)
+			runApiTestWith(t, testStore.open, &storeFs, watchedDirPaths, storeActions, wantedMap)
+		})
+	}
+}
+
+func runApiTestWith(t *testing.T, open func(string) (scry.EventStore, error), tmpFs *utils.TmpFs, watchedDirPaths []string, actions []utils.FsAction, wantedMap DirPathToTailChainMap) {
 	var err error
 	if err := tmpFs.Instantiate(); err != nil {
 		t.Fatal(err)
@@ -78,9 +119,8 @@ func runApiTest(t *testing.T, tmpFs *utils.TmpFs, watchedDirPaths []string, acti
 
 	defer tmpFs.Destroy()
 
-	// store := memstore.NewMemStore()
 	dbDir := filepath.Join(tmpFs.Path, "./.db/")
-	store, err := badgerstore.NewBadgerStore(dbDir)
+	store, err := open(dbDir)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -100,7 +140,12 @@ func runApiTest(t *testing.T, tmpFs *utils.TmpFs, watchedDirPaths []string, acti
 		}
 	}
 
-	takeActions(t, actions)
+	// a slow store lags behind the fs, the next action could move a node before its create is stored
+	pending := 0
+	for _, action := range actions {
+		takeActions(t, []utils.FsAction{action})
+		waitForScryer(t, watcher, &pending)
+	}
 
 	watcher.Close()
 
@@ -120,7 +165,7 @@ func initApiTest(t *testing.T, tmpFs *utils.TmpFs, watchedDirPaths []string, sto
 		t.Fatal(err)
 	}
 
-	watcher, err := scry.InitScryer(tmpFs.Path, scryDirs, store)
+	watcher, err := scry.InitScryer(tmpFs.Path, scryDirs, store, scry.Options{RawEvents: true})
 	if err != nil {
 		tmpFs.Destroy()
 		t.Fatal(err)
@@ -131,6 +176,39 @@ func initApiTest(t *testing.T, tmpFs *utils.TmpFs, watchedDirPaths []string, sto
 	return watcher
 }
 
+// waitForScryer waits until the scryer's processed every event it's received and no more are coming
+// pending counts the events received but not processed yet (across calls), it needs RawEvents and no debouncing
+func waitForScryer(t *testing.T, watcher *scry.Scryer, pending *int) {
+	timeout := time.After(SETTLE_TIMEOUT)
+	for {
+		select {
+		case <-watcher.RawChanRx:
+			*pending++
+		case <-watcher.ProcessedChanRx:
+			*pending--
+		case <-time.After(SETTLE_QUIET):
+			if *pending == 0 {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("Scryer didn't catch up, %d events still pending", *pending)
+		}
+	}
+}
+
+// take actions w/ paths relative to root (symlink targets are left as they are)
+func takeActionsIn(t *testing.T, root string, actions []utils.FsAction) {
+	for i := range actions {
+		if actions[i].SrcPath != "" && actions[i].Kind != utils.SYMLINK {
+			actions[i].SrcPath = filepath.Join(root, actions[i].SrcPath)
+		}
+		if actions[i].DstPath != "" {
+			actions[i].DstPath = filepath.Join(root, actions[i].DstPath)
+		}
+	}
+	takeActions(t, actions)
+}
+
 func takeActions(t *testing.T, actions []utils.FsAction) {
 	for _, action := range actions {
 		if err := action.Take(); err != nil {
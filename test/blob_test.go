@@ -0,0 +1,128 @@
+package test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ceejimus/kusari/blobstore"
+	"github.com/ceejimus/kusari/fnode"
+	"github.com/ceejimus/kusari/scry"
+	"github.com/ceejimus/kusari/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// test reconciliation keeps every version it sees and GC only drops unreferenced content
+func TestBlobsKeptAndCollected(t *testing.T) {
+	topDir := t.TempDir()
+	tmpDir := utils.TmpDir{
+		Name:  "d",
+		Dirs:  []*utils.TmpDir{{Name: "s"}},
+		Files: []*utils.TmpFile{{Name: "a", Content: []byte("i am a")}},
+	}
+	if err := tmpDir.Instantiate(topDir); err != nil {
+		t.Fatal(err)
+	}
+	store := newTestBadgerStore(t)
+	if err := store.AddDir(&scry.Dir{Path: "d"}); err != nil {
+		t.Fatal(err)
+	}
+	blobs, err := blobstore.NewBlobStore(filepath.Join(t.TempDir(), "blobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := scry.Options{Blobs: blobs}
+	scryDir := scry.ScriedDirectory{Path: "d"}
+
+	if err = scry.Reconcile(topDir, scryDir, store, opts); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(topDir, "d/a"), []byte("i am a v2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = scry.Reconcile(topDir, scryDir, store, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, content := range []string{"i am a", "i am a v2"} {
+		has, err := blobs.Has(*hashPtr(content))
+		assert.NoError(t, err)
+		assert.True(t, has, "content %q should be kept", content)
+	}
+
+	// add some content no event refers to
+	stray := filepath.Join(t.TempDir(), "stray")
+	if err = os.WriteFile(stray, []byte("i am stray"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = blobs.AddFile(*hashPtr("i am stray"), stray); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := blobs.GC(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, removed)
+	for content, wanted := range map[string]bool{"i am a": true, "i am a v2": true, "i am stray": false} {
+		has, err := blobs.Has(*hashPtr(content))
+		assert.NoError(t, err)
+		assert.Equal(t, wanted, has, "content %q", content)
+	}
+}
+
+// test large files are chunked in their events and the chunks are kept (and collected) w/ them
+func TestChunkedContentKept(t *testing.T) {
+	topDir := t.TempDir()
+	content := bytes.Repeat([]byte("i am big "), fnode.CHUNKED_SIZE/4)
+	tmpDir := utils.TmpDir{
+		Name:  "d",
+		Files: []*utils.TmpFile{{Name: "big", Content: content}, {Name: "small", Content: []byte("i am small")}},
+	}
+	if err := tmpDir.Instantiate(topDir); err != nil {
+		t.Fatal(err)
+	}
+	store := newTestBadgerStore(t)
+	dir := &scry.Dir{Path: "d"}
+	if err := store.AddDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	blobs, err := blobstore.NewBlobStore(filepath.Join(t.TempDir(), "blobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = scry.Reconcile(topDir, scry.ScriedDirectory{Path: "d"}, store, scry.Options{Blobs: blobs}); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, chunked := range map[string]bool{"big": true, "small": false} {
+		chain, err := store.GetChainByPath(dir.ID, path)
+		if err != nil || chain == nil {
+			t.Fatalf("no chain for %q: %v", path, err)
+		}
+		tail, err := store.GetChainTail(chain.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, chunked, len(tail.Chunks) > 0, "%q", path)
+		stored, err := blobs.Chunks(*tail.Hash)
+		assert.NoError(t, err)
+		assert.Equal(t, tail.Chunks, stored, "%q", path)
+	}
+
+	blob, err := blobs.Open(*hashPtr(string(content)))
+	if err != nil || blob == nil {
+		t.Fatalf("big isn't kept: %v", err)
+	}
+	got, err := io.ReadAll(blob)
+	blob.Close()
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(content, got))
+
+	// nothing's collected while it's referenced
+	removed, err := blobs.GC(store)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, removed)
+}
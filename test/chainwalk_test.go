@@ -0,0 +1,128 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ceejimus/kusari/badgerstore"
+	"github.com/ceejimus/kusari/scry"
+	"github.com/stretchr/testify/assert"
+)
+
+// test walking chains one event at a time in both directions
+func TestWalkChain(t *testing.T) {
+	t0 := time.Now().Add(-time.Hour)
+	at := func(minutes int) time.Time { return t0.Add(time.Duration(minutes) * time.Minute) }
+
+	store := newTestBadgerStore(t)
+	// interleave events from two chains so their IDs aren't contiguous
+	dir := addScriptedEvents(t, store, "d", []scriptedEvent{
+		{0, scry.Event{Timestamp: at(1), Path: "a", Type: scry.Create, Hash: hashPtr("a1")}},
+		{1, scry.Event{Timestamp: at(2), Path: "b", Type: scry.Create, Hash: hashPtr("b1")}},
+		{0, scry.Event{Timestamp: at(3), Path: "a", Type: scry.Write, Hash: hashPtr("a2")}},
+		{1, scry.Event{Timestamp: at(4), Path: "b", Type: scry.Remove}},
+		{0, scry.Event{Timestamp: at(5), Path: "a", Type: scry.Rename}},
+		{0, scry.Event{Timestamp: at(6), Path: "c", Type: scry.Create, Hash: hashPtr("a2")}},
+	})
+
+	chains, err := store.GetChainsInDir(dir.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !assert.Len(t, chains, 2) {
+		return
+	}
+	for _, chain := range chains {
+		events, err := store.GetEventsInChain(chain.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// forwards from the head
+		walked := make([]scry.Event, 0)
+		event, err := store.GetChainHead(chain.ID)
+		for ; event != nil && err == nil; event, err = store.GetNextEvent(event.ID) {
+			walked = append(walked, *event)
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, events, walked)
+		// backwards from the tail
+		walked = make([]scry.Event, 0)
+		event, err = store.GetChainTail(chain.ID)
+		for ; event != nil && err == nil; event, err = store.GetPrevEvent(event.ID) {
+			walked = append([]scry.Event{*event}, walked...)
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, events, walked)
+		// by ID
+		for _, event := range events {
+			got, err := store.GetEventByID(event.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, &event, got)
+		}
+	}
+
+	missing := badgerstore.BadgerID([]byte{0, 0, 0, 0, 0, 0, 1, 0})
+	event, err := store.GetEventByID(&missing)
+	assert.NoError(t, err)
+	assert.Nil(t, event)
+}
+
+// test added events get their own IDs
+func TestAddEventSetsID(t *testing.T) {
+	store := newTestBadgerStore(t)
+	dir := &scry.Dir{Path: "d"}
+	if err := store.AddDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	chain := &scry.Chain{Ino: 1}
+	if err := store.AddChain(chain, dir.ID); err != nil {
+		t.Fatal(err)
+	}
+	first := &scry.Event{Timestamp: time.Now(), Path: "a", Type: scry.Create}
+	second := &scry.Event{Timestamp: time.Now(), Path: "a", Type: scry.Remove}
+	for _, event := range []*scry.Event{first, second} {
+		if err := store.AddEvent(event, chain.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assert.NotEqual(t, first.ID.Encode(), second.ID.Encode())
+
+	got, err := store.GetEventByID(second.ID)
+	if assert.NoError(t, err) && assert.NotNil(t, got) {
+		assert.Equal(t, scry.Remove, got.Type)
+	}
+	got, err = store.GetPrevEvent(second.ID)
+	if assert.NoError(t, err) && assert.NotNil(t, got) {
+		assert.Equal(t, first.ID.Encode(), got.ID.Encode())
+	}
+	got, err = store.GetPrevEvent(first.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+}
+
+// test a chain is found by its new ino after it's moved to one
+func TestSetChainIno(t *testing.T) {
+	store := newTestBadgerStore(t)
+	dir := &scry.Dir{Path: "d"}
+	if err := store.AddDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	chain := &scry.Chain{Ino: 1}
+	if err := store.AddChain(chain, dir.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetChainIno(chain.ID, 2); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.GetChainByIno(2)
+	if assert.NoError(t, err) && assert.NotNil(t, got) {
+		assert.Equal(t, chain.ID.Encode(), got.ID.Encode())
+		assert.Equal(t, uint64(2), got.Ino)
+	}
+	got, err = store.GetChainByIno(1)
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+
+	missing := badgerstore.BadgerID([]byte{0, 0, 0, 0, 0, 0, 1, 0})
+	assert.Error(t, store.SetChainIno(&missing, 3))
+}
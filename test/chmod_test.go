@@ -0,0 +1,68 @@
+package test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/ceejimus/kusari/scry"
+	"github.com/ceejimus/kusari/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// test mode changes are recorded as chmod events (live and while we weren't watching)
+func TestChmodEvents(t *testing.T) {
+	tmpFs := utils.TmpFs{
+		Dirs: []*utils.TmpDir{{
+			Name:  "d",
+			Dirs:  []*utils.TmpDir{{Name: "s"}},
+			Files: []*utils.TmpFile{{Name: "a", Content: []byte("#!/bin/sh")}},
+		}},
+	}
+	scryDirs := []scry.ScriedDirectory{{Path: "d"}}
+	store, watcher := startScryer(t, &tmpFs, scryDirs, scry.Options{})
+
+	takeActionsIn(t, tmpFs.Path, []utils.FsAction{
+		{Kind: utils.CHMOD, DstPath: "d/a", Mode: 0755},
+		// only times change, no event
+		{Kind: utils.TOUCH, DstPath: "d/a"},
+		{Kind: utils.CHMOD, DstPath: "d/s", Mode: 0700},
+	})
+	watcher.Close()
+
+	wantedMap := make(DirPathToTailChainMap)
+	wantedMap["d"] = make(TailPathToChainMap)
+	wantedMap["d"]["a"] = Chains{Chain{{Path: "a", Type: scry.Create}, {Path: "a", Type: scry.Chmod}}}
+	wantedMap["d"]["s"] = Chains{Chain{{Path: "s", Type: scry.Create}, {Path: "s", Type: scry.Chmod}}}
+	if err := compareWanted(t, wantedMap, store); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := store.GetDirByPath("d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertMode := func(path string, mode os.FileMode) {
+		state, err := scry.GetDirState(store, dir.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, mode, state[path].Mode, "mode of %q", path)
+		assert.Equal(t, uint32(os.Getuid()), state[path].Uid, "owner of %q", path)
+	}
+	assertMode("a", 0755)
+	assertMode("s", 0700)
+
+	// changed while we weren't watching
+	takeActionsIn(t, tmpFs.Path, []utils.FsAction{
+		{Kind: utils.CHMOD, DstPath: "d/s", Mode: 0750},
+	})
+	if err = scry.Reconcile(tmpFs.Path, scryDirs[0], store, scry.Options{}); err != nil {
+		t.Fatal(err)
+	}
+	wantedMap["d"]["s"] = Chains{Chain{{Path: "s", Type: scry.Create}, {Path: "s", Type: scry.Chmod}, {Path: "s", Type: scry.Chmod}}}
+	// the touch's mod time is caught up on too
+	wantedMap["d"]["a"] = Chains{Chain{{Path: "a", Type: scry.Create}, {Path: "a", Type: scry.Chmod}, {Path: "a", Type: scry.Write}}}
+	if err := compareWanted(t, wantedMap, store); err != nil {
+		t.Fatal(err)
+	}
+	assertMode("s", 0750)
+}
@@ -0,0 +1,193 @@
+package test
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ceejimus/kusari/badgerstore"
+	"github.com/ceejimus/kusari/blobstore"
+	"github.com/ceejimus/kusari/scry"
+	"github.com/stretchr/testify/assert"
+)
+
+// add a dir whose history spans a few days
+func addCompactableDir(t *testing.T, store scry.EventStore, dirPath string, now time.Time) *scry.Dir {
+	at := func(hours int) time.Time { return now.Add(time.Duration(hours) * time.Hour) }
+	return addScriptedEvents(t, store, dirPath, []scriptedEvent{
+		{0, scry.Event{Timestamp: at(-72), Path: "s", Type: scry.Create}},
+		{1, scry.Event{Timestamp: at(-72), Path: "s/a", Type: scry.Create, Size: 2, Hash: hashPtr("a1")}},
+		{2, scry.Event{Timestamp: at(-72), Path: "s/b", Type: scry.Create, Size: 2, Hash: hashPtr("b1")}},
+		{1, scry.Event{Timestamp: at(-71), Path: "s/a", Type: scry.Write, Size: 2, Hash: hashPtr("a2")}},
+		{0, scry.Event{Timestamp: at(-48), Path: "s", Type: scry.Rename}},
+		{0, scry.Event{Timestamp: at(-48), Path: "t", Type: scry.Create}},
+		{1, scry.Event{Timestamp: at(-24), Path: "t/a", Type: scry.Write, Size: 2, Hash: hashPtr("a3")}},
+		{3, scry.Event{Timestamp: at(-24), Path: "c", Type: scry.Create, Size: 2, Hash: hashPtr("c1")}},
+		{3, scry.Event{Timestamp: at(-2), Path: "c", Type: scry.Remove}},
+		{1, scry.Event{Timestamp: at(-1), Path: "t/a", Type: scry.Write, Size: 2, Hash: hashPtr("a4")}},
+	})
+}
+
+// test compacting to a retention policy drops history w/o changing what's there now
+func TestCompact(t *testing.T) {
+	now := time.Now()
+	store := newTestBadgerStore(t)
+	bdgStore := store.(*badgerstore.BadgerStore)
+	dir := addCompactableDir(t, store, "d", now)
+	other := addCompactableDir(t, store, "e", now)
+
+	before, err := scry.GetDirState(store, dir.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dropped, err := bdgStore.Compact(scry.Retention{KeepLast: 1, KeepWithin: 30 * time.Hour}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// s's create and rename and a's create and first write (in each dir)
+	assert.Equal(t, 8, dropped)
+
+	after, err := scry.GetDirState(store, dir.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, before, after)
+	for _, path := range []string{"t", "t/a", "t/b"} {
+		chain, err := store.GetChainByPath(dir.ID, path)
+		assert.NoError(t, err)
+		assert.NotNil(t, chain, path)
+	}
+
+	// what's left is linked up
+	chains, err := store.GetChainsInDir(dir.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantedLens := []int{1, 2, 1, 2}
+	if assert.Len(t, chains, len(wantedLens)) {
+		for i, chain := range chains {
+			events, err := store.GetEventsInChain(chain.ID)
+			assert.NoError(t, err)
+			assert.Len(t, events, wantedLens[i])
+			walked := make([]scry.Event, 0)
+			event, err := store.GetChainTail(chain.ID)
+			for ; event != nil && err == nil; event, err = store.GetPrevEvent(event.ID) {
+				walked = append([]scry.Event{*event}, walked...)
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, events, walked)
+		}
+	}
+
+	// compacting again doesn't drop anything
+	dropped, err = bdgStore.Compact(scry.Retention{KeepLast: 1, KeepWithin: 30 * time.Hour}, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, dropped)
+
+	// removed dirs don't leave anything to sweep up
+	if err = store.RemoveDir(other.ID); err != nil {
+		t.Fatal(err)
+	}
+	_, err = bdgStore.Compact(scry.Retention{}, now)
+	assert.NoError(t, err)
+	after, err = scry.GetDirState(store, dir.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, before, after)
+}
+
+// test events can be added to chains while they're compacted
+func TestCompactWhileAdding(t *testing.T) {
+	now := time.Now()
+	store := newTestBadgerStore(t)
+	dir := addCompactableDir(t, store, "d", now)
+	chain, err := store.GetChainByPath(dir.ID, "t/a")
+	if err != nil || chain == nil {
+		t.Fatal("t/a should have a chain", err)
+	}
+
+	added := make(chan error)
+	go func() {
+		for i := 0; i < 200; i++ {
+			event := scry.Event{Timestamp: time.Now(), Path: "t/a", Type: scry.Write, Size: 2, Hash: hashPtr("a4")}
+			if err := store.AddEvent(&event, chain.ID); err != nil {
+				added <- err
+				return
+			}
+		}
+		added <- nil
+	}()
+	for done := false; !done; {
+		select {
+		case err = <-added:
+			done = true
+			assert.NoError(t, err)
+		default:
+			_, err := store.(*badgerstore.BadgerStore).Compact(scry.Retention{KeepLast: 1}, time.Now())
+			assert.NoError(t, err)
+		}
+	}
+
+	_, err = store.(*badgerstore.BadgerStore).Compact(scry.Retention{KeepLast: 1}, time.Now())
+	assert.NoError(t, err)
+	events, err := store.GetEventsInChain(chain.ID)
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+}
+
+// test the compactor has compacted once it's stopped and collected the content only dropped events had
+func TestCompactor(t *testing.T) {
+	now := time.Now()
+	store := newTestBadgerStore(t)
+	dir := addCompactableDir(t, store, "d", now)
+
+	blobsPath := filepath.Join(t.TempDir(), "blobs")
+	blobs, err := blobstore.NewBlobStore(blobsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range []string{"a1", "a2", "a3", "a4", "b1", "c1"} {
+		if err = blobs.Add(*hashPtr(content), bytes.NewBufferString(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// make them older than the grace period
+	old := now.Add(-time.Hour)
+	if err = filepath.WalkDir(blobsPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		return os.Chtimes(path, old, old)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// content just added may be for an event that's not stored yet
+	if err = blobs.Add(*hashPtr("new"), bytes.NewBufferString("new")); err != nil {
+		t.Fatal(err)
+	}
+
+	compactor := store.(*badgerstore.BadgerStore).StartCompactor(scry.Retention{KeepLast: 1}, time.Hour, blobs)
+	compactor.Stop()
+
+	chains, err := store.GetChainsInDir(dir.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, chain := range chains {
+		events, err := store.GetEventsInChain(chain.ID)
+		assert.NoError(t, err)
+		// s keeps its move
+		assert.LessOrEqual(t, len(events), 2)
+	}
+
+	for content, kept := range map[string]bool{"a1": false, "a2": false, "a3": false, "c1": false, "a4": true, "b1": true, "new": true} {
+		has, err := blobs.Has(*hashPtr(content))
+		assert.NoError(t, err)
+		assert.Equal(t, kept, has, content)
+	}
+}
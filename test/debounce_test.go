@@ -0,0 +1,115 @@
+package test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ceejimus/kusari/fnode"
+	"github.com/ceejimus/kusari/scry"
+	"github.com/ceejimus/kusari/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// test an editor's save (vim w/ a backup file) ends up as a single write to the file's chain
+func TestDebouncedEditorSave(t *testing.T) {
+	content := []byte("i am the new a")
+	tmpFs := utils.TmpFs{
+		Dirs: []*utils.TmpDir{{
+			Name:  "d",
+			Files: []*utils.TmpFile{{Name: "a", Content: []byte("i am a")}},
+		}},
+	}
+	store, watcher := startScryer(t, &tmpFs, []scry.ScriedDirectory{{Path: "d"}}, scry.Options{Debounce: time.Second, RawEvents: true})
+
+	takeActionsIn(t, tmpFs.Path, []utils.FsAction{
+		// check the dir is writable
+		{Kind: utils.TOUCH, DstPath: "d/4913"},
+		{Kind: utils.REMOVE, DstPath: "d/4913"},
+		// keep a backup and write the new file
+		{Kind: utils.MOVE, SrcPath: "d/a", DstPath: "d/a~"},
+		{Kind: utils.TOUCH, DstPath: "d/a"},
+		{Kind: utils.WRITE, DstPath: "d/a", Content: content[:4]},
+		{Kind: utils.WRITE, DstPath: "d/a", Content: content[4:]},
+		{Kind: utils.REMOVE, DstPath: "d/a~"},
+	})
+	watcher.Close()
+
+	wantedMap := make(DirPathToTailChainMap)
+	wantedMap["d"] = make(TailPathToChainMap)
+	wantedMap["d"]["a"] = Chains{
+		Chain{
+			{Path: "a", Type: scry.Create},
+			{Path: "a", Type: scry.Write, Size: uint64(len(content)), Hash: hashPtr(string(content))},
+		},
+	}
+	if err := compareWanted(t, wantedMap, store); err != nil {
+		t.Fatal(err)
+	}
+	assertChainFollows(t, store, filepath.Join(tmpFs.Path, "d/a"), "a")
+
+	// the raw stream has everything
+	raw := make([]string, 0)
+	for {
+		select {
+		case nodeEvent := <-watcher.RawChanRx:
+			raw = append(raw, nodeEvent.Type.String()+" "+nodeEvent.Path)
+			continue
+		case <-time.After(100 * time.Millisecond):
+		}
+		break
+	}
+	assert.Contains(t, raw, "create 4913")
+	assert.Contains(t, raw, "rename a")
+	assert.Contains(t, raw, "create a~")
+	assert.Contains(t, raw, "remove a~")
+}
+
+// test an atomic save (write a temp file, rename it over the original) keeps the file's chain
+func TestDebouncedAtomicSave(t *testing.T) {
+	content := []byte("i am the new a")
+	tmpFs := utils.TmpFs{
+		Dirs: []*utils.TmpDir{{
+			Name:  "d",
+			Files: []*utils.TmpFile{{Name: "a", Content: []byte("i am a")}},
+		}},
+	}
+	store, watcher := startScryer(t, &tmpFs, []scry.ScriedDirectory{{Path: "d"}}, scry.Options{Debounce: time.Second})
+
+	takeActionsIn(t, tmpFs.Path, []utils.FsAction{
+		{Kind: utils.TOUCH, DstPath: "d/.a.tmp"},
+		{Kind: utils.WRITE, DstPath: "d/.a.tmp", Content: content},
+		{Kind: utils.MOVE, SrcPath: "d/.a.tmp", DstPath: "d/a"},
+	})
+	watcher.Close()
+
+	wantedMap := make(DirPathToTailChainMap)
+	wantedMap["d"] = make(TailPathToChainMap)
+	wantedMap["d"]["a"] = Chains{
+		Chain{
+			{Path: "a", Type: scry.Create},
+			{Path: "a", Type: scry.Write, Size: uint64(len(content)), Hash: hashPtr(string(content))},
+		},
+	}
+	if err := compareWanted(t, wantedMap, store); err != nil {
+		t.Fatal(err)
+	}
+	assertChainFollows(t, store, filepath.Join(tmpFs.Path, "d/a"), "a")
+}
+
+// check the chain for the node at fullPath (by inode) is the one for path
+func assertChainFollows(t *testing.T, store scry.EventStore, fullPath string, path string) {
+	node, err := fnode.NewNode(fullPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := store.GetChainByIno(node.Ino)
+	if err != nil || chain == nil {
+		t.Fatalf("no chain for %q: %v", fullPath, err)
+	}
+	tail, err := store.GetChainTail(chain.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, path, tail.Path, "the chain should follow the node that replaced the file")
+}
@@ -0,0 +1,138 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ceejimus/kusari/memstore"
+	"github.com/ceejimus/kusari/scry"
+	"github.com/ceejimus/kusari/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func dirPaths(dirs []scry.Dir) []string {
+	paths := make([]string, len(dirs))
+	for i, dir := range dirs {
+		paths[i] = dir.Path
+	}
+	return paths
+}
+
+// test dirs added to the config are stored and the history of removed ones is kept unless purged
+func TestReconcileDirs(t *testing.T) {
+	store := memstore.NewMemStore()
+	for _, path := range []string{"a", "b"} {
+		dir := scry.Dir{Path: path}
+		if err := store.AddDir(&dir); err != nil {
+			t.Fatal(err)
+		}
+		chain := scry.Chain{}
+		if err := store.AddChain(&chain, dir.ID); err != nil {
+			t.Fatal(err)
+		}
+		event := scry.Event{Path: "f", Type: scry.Create, Timestamp: time.Now()}
+		if err := store.AddEvent(&event, chain.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	scryDirs := []scry.ScriedDirectory{{Path: "c"}, {Path: "a"}}
+
+	added, inactive, err := scry.ReconcileDirs(store, scryDirs, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"c"}, dirPaths(added))
+	assert.Equal(t, []string{"b"}, dirPaths(inactive))
+	dirs, err := scry.GetScriedDirs(store, scryDirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"c", "a"}, dirPaths(dirs))
+	// b's history is kept
+	chains, err := store.GetChainsInDir(inactive[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, chains, 1)
+
+	// nothing new to add, b stays inactive
+	added, inactive, err = scry.ReconcileDirs(store, scryDirs, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, added)
+	assert.Equal(t, []string{"b"}, dirPaths(inactive))
+
+	added, inactive, err = scry.ReconcileDirs(store, scryDirs, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, added)
+	assert.Equal(t, []string{"b"}, dirPaths(inactive))
+	dir, err := store.GetDirByPath("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, dir, "purged dir should be removed")
+	dirs, err = store.GetDirs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.ElementsMatch(t, []string{"a", "c"}, dirPaths(dirs))
+
+	// a dir that's configured again starts over
+	added, inactive, err = scry.ReconcileDirs(store, append(scryDirs, scry.ScriedDirectory{Path: "b"}), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"b"}, dirPaths(added))
+	assert.Empty(t, inactive)
+	chains, err = store.GetChainsInDir(added[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, chains)
+
+	_, err = scry.GetScriedDirs(store, []scry.ScriedDirectory{{Path: "x"}})
+	assert.Error(t, err, "dirs that aren't stored can't be scried")
+}
+
+// test the scryer only watches configured dirs even if others are stored
+func TestScryerSkipsInactiveDirs(t *testing.T) {
+	tmpFs := utils.TmpFs{
+		Path: t.TempDir(),
+		Dirs: []*utils.TmpDir{
+			{Name: "a", Files: []*utils.TmpFile{{Name: "f", Content: []byte("i am f")}}},
+			{Name: "b", Files: []*utils.TmpFile{{Name: "g", Content: []byte("i am g")}}},
+		},
+	}
+	if err := tmpFs.Instantiate(); err != nil {
+		t.Fatal(err)
+	}
+	store := newTestBadgerStore(t)
+	if err := setupStoreFromLocalState(&tmpFs, []scry.ScriedDirectory{{Path: "a"}, {Path: "b"}}, store); err != nil {
+		t.Fatal(err)
+	}
+	watcher, err := scry.InitScryer(tmpFs.Path, []scry.ScriedDirectory{{Path: "a"}}, store, scry.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go watcher.Run()
+
+	takeActionsIn(t, tmpFs.Path, []utils.FsAction{
+		{Kind: utils.TOUCH, DstPath: "a/h"},
+		{Kind: utils.TOUCH, DstPath: "b/h"},
+		{Kind: utils.WRITE, DstPath: "b/g", Content: []byte(" and more")},
+	})
+	watcher.Close()
+
+	wantedMap := make(DirPathToTailChainMap)
+	wantedMap["a"] = make(TailPathToChainMap)
+	wantedMap["a"]["f"] = Chains{Chain{{Path: "f", Type: scry.Create}}}
+	wantedMap["a"]["h"] = Chains{Chain{{Path: "h", Type: scry.Create}}}
+	wantedMap["b"] = make(TailPathToChainMap)
+	wantedMap["b"]["g"] = Chains{Chain{{Path: "g", Type: scry.Create}}}
+	if err := compareWanted(t, wantedMap, store); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,118 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ceejimus/kusari/scry"
+	"github.com/ceejimus/kusari/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// test events for nodes excluded by a dir's globs aren't stored
+func TestScryerExcludes(t *testing.T) {
+	tmpFs := utils.TmpFs{
+		Dirs: []*utils.TmpDir{{
+			Name: "d",
+			Dirs: []*utils.TmpDir{{
+				Name: ".git",
+				Dirs: []*utils.TmpDir{{Name: "objects"}},
+			}},
+			Files: []*utils.TmpFile{{Name: "a", Content: []byte("i am a")}},
+		}},
+	}
+	scryDirs := []scry.ScriedDirectory{{Path: "d", Exclude: []string{".git/", "*.swp"}}}
+	store, watcher := startScryer(t, &tmpFs, scryDirs, scry.Options{})
+
+	takeActionsIn(t, tmpFs.Path, []utils.FsAction{
+		{Kind: utils.TOUCH, DstPath: "d/.a.swp"},
+		{Kind: utils.WRITE, DstPath: "d/.a.swp", Content: []byte("swap")},
+		{Kind: utils.TOUCH, DstPath: "d/.git/HEAD"},
+		{Kind: utils.TOUCH, DstPath: "d/.git/objects/ab"},
+		{Kind: utils.MKDIR, DstPath: "d/s"},
+		{Kind: utils.TOUCH, DstPath: "d/s/.b.swp"},
+		{Kind: utils.TOUCH, DstPath: "d/s/b"},
+		{Kind: utils.WRITE, DstPath: "d/a", Content: []byte(" and more")},
+	})
+	watcher.Close()
+
+	wantedMap := make(DirPathToTailChainMap)
+	wantedMap["d"] = make(TailPathToChainMap)
+	wantedMap["d"]["a"] = Chains{
+		Chain{
+			{Path: "a", Type: scry.Create},
+			{Path: "a", Type: scry.Write},
+		},
+	}
+	wantedMap["d"]["s"] = Chains{Chain{{Path: "s", Type: scry.Create}}}
+	wantedMap["d"]["s/b"] = Chains{Chain{{Path: "s/b", Type: scry.Create}}}
+	if err := compareWanted(t, wantedMap, store); err != nil {
+		t.Fatal(err)
+	}
+
+	// reconciling finds the same nodes
+	if err := scry.Reconcile(tmpFs.Path, scryDirs[0], store, scry.Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := compareWanted(t, wantedMap, store); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// test ignore files are honored and changes to them take effect right away
+func TestScryerIgnoreFile(t *testing.T) {
+	tmpFs := utils.TmpFs{
+		Dirs: []*utils.TmpDir{{
+			Name: "d",
+			Dirs: []*utils.TmpDir{{
+				Name:  "build",
+				Files: []*utils.TmpFile{{Name: "o"}},
+			}},
+			Files: []*utils.TmpFile{
+				{Name: scry.IGNORE_FILE, Content: []byte("*.log\nbuild/\n")},
+				{Name: "a"},
+				{Name: "x.log"},
+			},
+		}},
+	}
+	store, watcher := startScryer(t, &tmpFs, []scry.ScriedDirectory{{Path: "d"}}, scry.Options{})
+	dir, err := store.GetDirByPath("d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertState := func(wanted ...string) {
+		state, err := scry.GetDirState(store, dir.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.ElementsMatch(t, wanted, statePaths(state))
+	}
+	assertState(scry.IGNORE_FILE, "a")
+
+	takeActionsIn(t, tmpFs.Path, []utils.FsAction{
+		{Kind: utils.TOUCH, DstPath: "d/y.log"},
+		{Kind: utils.TOUCH, DstPath: "d/build/p"},
+	})
+	assertState(scry.IGNORE_FILE, "a")
+
+	// stop ignoring logs and build, start ignoring a
+	takeActionsIn(t, tmpFs.Path, []utils.FsAction{
+		{Kind: utils.REMOVE, DstPath: "d/" + scry.IGNORE_FILE},
+		{Kind: utils.TOUCH, DstPath: "d/" + scry.IGNORE_FILE},
+		{Kind: utils.WRITE, DstPath: "d/" + scry.IGNORE_FILE, Content: []byte("a\n")},
+	})
+	time.Sleep(200 * time.Millisecond)
+	assertState(scry.IGNORE_FILE, "x.log", "y.log", "build", "build/o", "build/p")
+
+	// a nested file overrides it
+	takeActionsIn(t, tmpFs.Path, []utils.FsAction{
+		{Kind: utils.MKDIR, DstPath: "d/s"},
+		{Kind: utils.TOUCH, DstPath: "d/s/a"},
+		{Kind: utils.TOUCH, DstPath: "d/s/" + scry.IGNORE_FILE},
+		{Kind: utils.WRITE, DstPath: "d/s/" + scry.IGNORE_FILE, Content: []byte("!a\n")},
+		{Kind: utils.TOUCH, DstPath: "d/build/q"},
+	})
+	time.Sleep(200 * time.Millisecond)
+	watcher.Close()
+	assertState(scry.IGNORE_FILE, "x.log", "y.log", "build", "build/o", "build/p", "build/q", "s", "s/a", "s/"+scry.IGNORE_FILE)
+}
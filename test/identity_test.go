@@ -0,0 +1,99 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ceejimus/kusari/config"
+	"github.com/ceejimus/kusari/identity"
+	"github.com/stretchr/testify/assert"
+)
+
+// test an identity is created once and loaded after that
+func TestIdentityLoadOrCreate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "identity.pem")
+
+	created, err := identity.LoadOrCreate(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "private key should only be readable by us")
+
+	loaded, err := identity.LoadOrCreate(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, created.NodeID, loaded.NodeID)
+	assert.True(t, created.PublicKey.Equal(loaded.PublicKey))
+	assert.Equal(t, identity.NodeIDFor(loaded.PublicKey), loaded.NodeID)
+
+	if err = os.WriteFile(path, []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = identity.LoadOrCreate(path)
+	assert.Error(t, err, "a bad identity shouldn't be replaced")
+}
+
+// test the identity is kept in DataDir and only created when asked to
+func TestConfigIdentity(t *testing.T) {
+	tmp := t.TempDir()
+	dataDir := filepath.Join(tmp, "data")
+	configPath := filepath.Join(tmp, "config.yaml")
+	if err := os.WriteFile(configPath, []byte("dataDir: "+dataDir+"\ntopDir: "+tmp+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cnf, err := config.LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, filepath.Join(dataDir, config.IDENTITY_FILE_NAME), cnf.IdentityFile)
+	assert.NoFileExists(t, cnf.IdentityFile, "loading the config shouldn't create an identity")
+
+	assert.Error(t, cnf.LoadIdentity(false))
+	assert.NoFileExists(t, cnf.IdentityFile, "an identity shouldn't be created unless asked to")
+
+	if err = cnf.LoadIdentity(true); err != nil {
+		t.Fatal(err)
+	}
+	assert.FileExists(t, cnf.IdentityFile)
+	created := cnf.NodeID
+
+	cnf, err = config.LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cnf.LoadIdentity(false); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, created, cnf.NodeID)
+}
+
+func TestTrustedKeys(t *testing.T) {
+	a, err := identity.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := identity.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trusted, err := identity.ParseTrustedKeys([]string{a.EncodedPublicKey()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, trusted.Trusts(a.NodeID, a.PublicKey))
+	assert.False(t, trusted.Trusts(b.NodeID, b.PublicKey))
+	assert.False(t, trusted.Trusts(a.NodeID, b.PublicKey), "a key claiming another node's ID shouldn't be trusted")
+
+	_, err = identity.ParseTrustedKeys([]string{"not a key"})
+	assert.Error(t, err)
+	_, err = identity.ParseTrustedKeys([]string{"c2hvcnQ="})
+	assert.Error(t, err)
+}
@@ -0,0 +1,796 @@
+package test
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io"
+	"io/fs"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ceejimus/kusari/blobstore"
+	"github.com/ceejimus/kusari/fnode"
+	"github.com/ceejimus/kusari/identity"
+	"github.com/ceejimus/kusari/peer"
+	"github.com/ceejimus/kusari/resolve"
+	"github.com/ceejimus/kusari/scry"
+	"github.com/ceejimus/kusari/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+type testPeer struct {
+	nodeID  string
+	id      *identity.Identity
+	trusted identity.TrustedKeys
+	topDir  string
+	store   scry.EventStore
+	blobs   *countingBlobStore
+	applier *scry.Applier
+	node    *peer.Node
+}
+
+// a blob store that counts the blobs opened (e.g. to serve them to peers)
+type countingBlobStore struct {
+	scry.BlobStore
+	mu     sync.Mutex
+	opened int
+}
+
+func (b *countingBlobStore) Open(hash string) (io.ReadCloser, error) {
+	b.mu.Lock()
+	b.opened++
+	b.mu.Unlock()
+	return b.BlobStore.Open(hash)
+}
+
+func (b *countingBlobStore) openCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.opened
+}
+
+// start two nodes that trust each other
+func newTestPeers(t *testing.T, tmpDirA *utils.TmpDir, tmpDirB *utils.TmpDir) (*testPeer, *testPeer) {
+	a, b := newTestPeer(t, tmpDirA), newTestPeer(t, tmpDirB)
+	a.trusted.Add(b.id.PublicKey)
+	b.trusted.Add(a.id.PublicKey)
+	return a, b
+}
+
+// start a node listening on loopback w/ the given dir scried and reconciled
+// it doesn't trust any peers
+func newTestPeer(t *testing.T, tmpDir *utils.TmpDir) *testPeer {
+	blobs, err := blobstore.NewBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := identity.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &testPeer{
+		nodeID:  id.NodeID,
+		id:      id,
+		trusted: identity.TrustedKeys{},
+		topDir:  t.TempDir(),
+		store:   newTestBadgerStore(t),
+		blobs:   &countingBlobStore{BlobStore: blobs},
+		applier: scry.NewApplier(),
+	}
+	if err := tmpDir.Instantiate(p.topDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.store.AddDir(&scry.Dir{Path: tmpDir.Name}); err != nil {
+		t.Fatal(err)
+	}
+	p.reconcile(t, tmpDir.Name)
+	if p.node, err = peer.NewNode(p.topDir, []scry.ScriedDirectory{{Path: tmpDir.Name}}, p.store, p.opts(), p.id, p.trusted); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.node.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	go p.node.Serve()
+	t.Cleanup(func() { p.node.Close() })
+	return p
+}
+
+func (p *testPeer) opts() scry.Options {
+	return scry.Options{NodeID: p.nodeID, Blobs: p.blobs, Applier: p.applier}
+}
+
+func (p *testPeer) reconcile(t *testing.T, dirPath string) {
+	if err := scry.Reconcile(p.topDir, scry.ScriedDirectory{Path: dirPath}, p.store, p.opts()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (p *testPeer) syncWith(t *testing.T, other *testPeer) {
+	if err := p.node.SyncWith(other.node.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (p *testPeer) takeActions(t *testing.T, actions []utils.FsAction) {
+	takeActionsIn(t, p.topDir, actions)
+}
+
+// relative path -> hash ("" for dirs) for everything under a dir on disk
+func (p *testPeer) diskState(t *testing.T, dirPath string) map[string]string {
+	root := filepath.Join(p.topDir, dirPath)
+	state := make(map[string]string)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == root {
+			return err
+		}
+		hash := ""
+		if !d.IsDir() {
+			if hash, err = fnode.FileHash(path); err != nil {
+				return err
+			}
+		}
+		state[fnode.GetRelativePath(path, root)] = hash
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+// relative path -> hash ("" for dirs) for the expected state in the store
+func (p *testPeer) storeState(t *testing.T, dirPath string) map[string]string {
+	dir, err := p.store.GetDirByPath(dirPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirState, err := scry.GetDirState(p.store, dir.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := make(map[string]string, len(dirState))
+	for path, nodeState := range dirState {
+		state[path] = ""
+		if nodeState.Hash != nil {
+			state[path] = *nodeState.Hash
+		}
+	}
+	return state
+}
+
+// the target stored for the link at path
+func (p *testPeer) linkState(t *testing.T, dirPath string, path string) string {
+	dir, err := p.store.GetDirByPath(dirPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirState, err := scry.GetDirState(p.store, dir.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dirState[path].Target
+}
+
+func (p *testPeer) conflicts(t *testing.T, dirPath string) []scry.Conflict {
+	dir, err := p.store.GetDirByPath(dirPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conflicts, err := p.store.GetConflictsInDir(dir.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conflicts
+}
+
+func (p *testPeer) resolve(t *testing.T, dirPath string, conflict *scry.Conflict, resolution resolve.Resolution) {
+	dir, err := p.store.GetDirByPath(dirPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = resolve.NewResolver(p.topDir, dir, p.store, p.opts()).Resolve(conflict, resolution); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (p *testPeer) eventCount(t *testing.T, dirPath string) int {
+	dir, err := p.store.GetDirByPath(dirPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chains, err := p.store.GetChainsInDir(dir.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for _, chain := range chains {
+		events, err := p.store.GetEventsInChain(chain.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		count += len(events)
+	}
+	return count
+}
+
+// check both peers have the same nodes on disk and in their stores
+func assertConverged(t *testing.T, a *testPeer, b *testPeer, dirPath string) {
+	wanted := a.diskState(t, dirPath)
+	assert.Equal(t, wanted, b.diskState(t, dirPath), "peers should have the same nodes on disk")
+	assert.Equal(t, wanted, a.storeState(t, dirPath), "first peer's store should match its disk")
+	assert.Equal(t, wanted, b.storeState(t, dirPath), "second peer's store should match its disk")
+}
+
+// test a new peer pulls everything, then changes flow both ways
+func TestPeerSync(t *testing.T) {
+	a, b := newTestPeers(t, &utils.TmpDir{
+		Name: "d",
+		Dirs: []*utils.TmpDir{{
+			Name:  "s",
+			Files: []*utils.TmpFile{{Name: "c", Content: []byte("i am c")}},
+		}},
+		Files: []*utils.TmpFile{
+			{Name: "a", Content: []byte("i am a")},
+			{Name: "b", Content: []byte("i am b")},
+		},
+	}, &utils.TmpDir{Name: "d"})
+
+	// b starts empty and pulls everything from a
+	b.syncWith(t, a)
+	assertConverged(t, a, b, "d")
+	// the synced nodes match what b stored for them
+	count := b.eventCount(t, "d")
+	b.reconcile(t, "d")
+	assert.Equal(t, count, b.eventCount(t, "d"), "reconciling synced nodes shouldn't add events")
+
+	// a writes, moves and removes
+	a.takeActions(t, []utils.FsAction{
+		{Kind: utils.WRITE, DstPath: "d/a", Content: []byte(" and more")},
+		{Kind: utils.MOVE, SrcPath: "d/b", DstPath: "d/s/b2"},
+		{Kind: utils.REMOVE, DstPath: "d/s/c"},
+		{Kind: utils.MOVE, SrcPath: "d/s", DstPath: "d/t"},
+		{Kind: utils.MKDIR, DstPath: "d/u"},
+	})
+	a.reconcile(t, "d")
+	b.syncWith(t, a)
+	assertConverged(t, a, b, "d")
+
+	// moves are applied to the existing chains
+	dir, err := b.store.GetDirByPath("d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := b.store.GetChainByPath(dir.ID, "t/b2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.NotNil(t, chain, "moved node should be found at its new path") {
+		events, err := b.store.GetEventsInChain(chain.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = eventSlicesMatch([]scry.Event{
+			{Path: "b", Type: scry.Create},
+			{Path: "b", Type: scry.Rename},
+			{Path: "t/b2", Type: scry.Create},
+		}, events)
+		assert.NoError(t, err)
+	}
+
+	// b changes things and a pulls them
+	b.takeActions(t, []utils.FsAction{
+		{Kind: utils.WRITE, DstPath: "d/t/b2", Content: []byte(" from b")},
+		{Kind: utils.TOUCH, DstPath: "d/u/n"},
+	})
+	b.reconcile(t, "d")
+	a.syncWith(t, b)
+	assertConverged(t, a, b, "d")
+
+	// nothing changed so nothing is synced
+	countA, countB := a.eventCount(t, "d"), b.eventCount(t, "d")
+	a.syncWith(t, b)
+	b.syncWith(t, a)
+	assert.Equal(t, countA, a.eventCount(t, "d"), "syncing w/o changes shouldn't add events")
+	assert.Equal(t, countB, b.eventCount(t, "d"), "syncing w/o changes shouldn't add events")
+}
+
+// test modes are synced w/ new nodes and on their own
+func TestPeerSyncMode(t *testing.T) {
+	a, b := newTestPeers(t, &utils.TmpDir{
+		Name:  "d",
+		Files: []*utils.TmpFile{{Name: "run", Content: []byte("#!/bin/sh")}},
+	}, &utils.TmpDir{Name: "d"})
+	mode := func(p *testPeer) os.FileMode {
+		info, err := os.Stat(filepath.Join(p.topDir, "d", "run"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.Mode().Perm()
+	}
+
+	a.takeActions(t, []utils.FsAction{{Kind: utils.CHMOD, DstPath: "d/run", Mode: 0755}})
+	a.reconcile(t, "d")
+	b.syncWith(t, a)
+	assertConverged(t, a, b, "d")
+	assert.Equal(t, os.FileMode(0755), mode(b))
+
+	a.takeActions(t, []utils.FsAction{{Kind: utils.CHMOD, DstPath: "d/run", Mode: 0700}})
+	a.reconcile(t, "d")
+	b.syncWith(t, a)
+	assert.Equal(t, os.FileMode(0700), mode(b))
+	// what b stored matches its disk
+	count := b.eventCount(t, "d")
+	b.reconcile(t, "d")
+	assert.Equal(t, count, b.eventCount(t, "d"), "reconciling synced modes shouldn't add events")
+}
+
+// test a small change to a big file only sends the chunks that changed
+func TestPeerSyncDelta(t *testing.T) {
+	content := make([]byte, 3*fnode.CHUNKED_SIZE)
+	rand.New(rand.NewSource(1)).Read(content)
+	a, b := newTestPeers(t, &utils.TmpDir{
+		Name:  "d",
+		Files: []*utils.TmpFile{{Name: "big", Content: content}},
+	}, &utils.TmpDir{Name: "d"})
+	_, chunks, err := fnode.GetChunks(bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// b has nothing so it gets every chunk
+	b.syncWith(t, a)
+	assertConverged(t, a, b, "d")
+	served := a.blobs.openCount()
+	assert.Equal(t, len(chunks), served)
+
+	// a changes a few bytes in the middle
+	file, err := os.OpenFile(filepath.Join(a.topDir, "d", "big"), os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = file.WriteAt([]byte("i am changed"), int64(len(content)/2))
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.reconcile(t, "d")
+	served = a.blobs.openCount()
+	b.syncWith(t, a)
+	assertConverged(t, a, b, "d")
+	// the rest are taken from b's copy of the old version
+	assert.LessOrEqual(t, a.blobs.openCount()-served, 2)
+	count := b.eventCount(t, "d")
+	b.reconcile(t, "d")
+	assert.Equal(t, count, b.eventCount(t, "d"), "reconciling a delta synced node shouldn't add events")
+}
+
+// test symlinks are synced as links and retargeted
+func TestPeerSyncSymlink(t *testing.T) {
+	a, b := newTestPeers(t, &utils.TmpDir{
+		Name:  "d",
+		Files: []*utils.TmpFile{{Name: "x", Content: []byte("i am x")}, {Name: "y", Content: []byte("i am y")}},
+	}, &utils.TmpDir{Name: "d"})
+	target := func(p *testPeer) string {
+		target, err := os.Readlink(filepath.Join(p.topDir, "d", "l"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return target
+	}
+
+	a.takeActions(t, []utils.FsAction{{Kind: utils.SYMLINK, SrcPath: "x", DstPath: "d/l"}})
+	a.reconcile(t, "d")
+	b.syncWith(t, a)
+	assert.Equal(t, "x", target(b))
+
+	a.takeActions(t, []utils.FsAction{
+		{Kind: utils.REMOVE, DstPath: "d/l"},
+		{Kind: utils.SYMLINK, SrcPath: "y", DstPath: "d/l"},
+	})
+	a.reconcile(t, "d")
+	b.syncWith(t, a)
+	assert.Equal(t, "y", target(b))
+	b.reconcile(t, "d")
+	assert.Equal(t, "y", b.linkState(t, "d", "l"), "b's store should have the new target")
+}
+
+// test a node removed on one peer isn't brought back by syncing w/ a peer that didn't change it
+func TestPeerSyncRemove(t *testing.T) {
+	a, b := newTestPeers(t, &utils.TmpDir{
+		Name:  "d",
+		Files: []*utils.TmpFile{{Name: "a", Content: []byte("i am a")}, {Name: "b", Content: []byte("i am b")}},
+	}, &utils.TmpDir{Name: "d"})
+	b.syncWith(t, a)
+	assertConverged(t, a, b, "d")
+
+	b.takeActions(t, []utils.FsAction{{Kind: utils.REMOVE, DstPath: "d/a"}})
+	b.reconcile(t, "d")
+	b.syncWith(t, a)
+	assert.NotContains(t, b.diskState(t, "d"), "a", "removed node shouldn't be re-created")
+
+	a.syncWith(t, b)
+	assertConverged(t, a, b, "d")
+	assert.NotContains(t, a.diskState(t, "d"), "a", "remove should be synced")
+	assert.Empty(t, a.conflicts(t, "d"))
+	assert.Empty(t, b.conflicts(t, "d"))
+}
+
+// test a file replaced by a new node (e.g. an editor's save) is synced as a change
+func TestPeerSyncReplace(t *testing.T) {
+	a, b := newTestPeers(t, tmpDirWithA(), &utils.TmpDir{Name: "d"})
+	b.syncWith(t, a)
+
+	a.takeActions(t, []utils.FsAction{
+		{Kind: utils.TOUCH, DstPath: "d/a.tmp"},
+		{Kind: utils.WRITE, DstPath: "d/a.tmp", Content: []byte("new a")},
+		{Kind: utils.MOVE, SrcPath: "d/a.tmp", DstPath: "d/a"},
+	})
+	a.reconcile(t, "d")
+	b.syncWith(t, a)
+	assertConverged(t, a, b, "d")
+	assert.Equal(t, *hashPtr("new a"), b.diskState(t, "d")["a"])
+	assert.Empty(t, b.conflicts(t, "d"))
+}
+
+// test changes synced while the peer's dir is scried aren't stored again as local changes
+func TestPeerSyncNoEcho(t *testing.T) {
+	a, b := newTestPeers(t, &utils.TmpDir{
+		Name: "d",
+		Dirs: []*utils.TmpDir{{
+			Name:  "s",
+			Files: []*utils.TmpFile{{Name: "c", Content: []byte("i am c")}},
+		}},
+		Files: []*utils.TmpFile{
+			{Name: "a", Content: []byte("i am a")},
+			{Name: "b", Content: []byte("i am b")},
+		},
+	}, &utils.TmpDir{Name: "d"})
+	scryer, err := scry.InitScryer(b.topDir, []scry.ScriedDirectory{{Path: "d"}}, b.store, b.opts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go scryer.Run()
+	defer scryer.Close()
+
+	// the scryer sees the applied changes, let it catch up before counting
+	settle := func() int {
+		time.Sleep(500 * time.Millisecond)
+		return b.eventCount(t, "d")
+	}
+
+	b.syncWith(t, a)
+	assertConverged(t, a, b, "d")
+	count := settle()
+	assert.Equal(t, a.eventCount(t, "d"), count, "synced nodes shouldn't be stored again")
+
+	a.takeActions(t, []utils.FsAction{
+		{Kind: utils.WRITE, DstPath: "d/a", Content: []byte(" and more")},
+		{Kind: utils.MOVE, SrcPath: "d/s/c", DstPath: "d/c2"},
+		{Kind: utils.MKDIR, DstPath: "d/s/u"},
+		{Kind: utils.REMOVE, DstPath: "d/b"},
+	})
+	a.reconcile(t, "d")
+	b.syncWith(t, a)
+	assertConverged(t, a, b, "d")
+	assert.Equal(t, a.eventCount(t, "d"), settle(), "synced changes shouldn't be stored again")
+
+	// the replaced file keeps its chain
+	dir, err := b.store.GetDirByPath("d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := fnode.NewNode(filepath.Join(b.topDir, "d", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := b.store.GetChainByIno(node.Ino)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pathChain, err := b.store.GetChainByPath(dir.ID, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.NotNil(t, chain, "replaced file's chain should be found by its new inode") {
+		assert.Equal(t, pathChain.ID, chain.ID)
+	}
+
+	// local changes are still stored
+	count = b.eventCount(t, "d")
+	b.takeActions(t, []utils.FsAction{{Kind: utils.WRITE, DstPath: "d/a", Content: []byte(" from b")}})
+	assert.Greater(t, settle(), count, "local changes should be stored")
+}
+
+func tmpDirWithA() *utils.TmpDir {
+	return &utils.TmpDir{
+		Name:  "d",
+		Files: []*utils.TmpFile{{Name: "a", Content: []byte("i am a")}},
+	}
+}
+
+// concurrently edit d/a on two peers that started w/ the same d/a
+func concurrentWrites(t *testing.T) (*testPeer, *testPeer) {
+	a, b := newTestPeers(t, tmpDirWithA(), tmpDirWithA())
+
+	// the same node created independently isn't a conflict
+	a.syncWith(t, b)
+	b.syncWith(t, a)
+	assertConverged(t, a, b, "d")
+	assert.Empty(t, a.conflicts(t, "d"))
+	assert.Empty(t, b.conflicts(t, "d"))
+
+	a.takeActions(t, []utils.FsAction{{Kind: utils.WRITE, DstPath: "d/a", Content: []byte(" from a")}})
+	a.reconcile(t, "d")
+	b.takeActions(t, []utils.FsAction{{Kind: utils.WRITE, DstPath: "d/a", Content: []byte(" from b")}})
+	b.reconcile(t, "d")
+
+	a.syncWith(t, b)
+	b.syncWith(t, a)
+	return a, b
+}
+
+// test concurrent writes are recorded as conflicts and not applied
+func TestPeerSyncConflict(t *testing.T) {
+	a, b := concurrentWrites(t)
+
+	assert.Equal(t, *hashPtr("i am a from a"), a.diskState(t, "d")["a"], "conflicting write shouldn't be applied")
+	assert.Equal(t, *hashPtr("i am a from b"), b.diskState(t, "d")["a"], "conflicting write shouldn't be applied")
+	conflicts := a.conflicts(t, "d")
+	if assert.Len(t, conflicts, 1) {
+		assert.Equal(t, "a", conflicts[0].Path)
+		assert.Equal(t, b.nodeID, conflicts[0].Remote.Origin)
+		assert.Equal(t, *hashPtr("i am a from b"), *conflicts[0].Remote.Hash)
+		assert.Equal(t, *hashPtr("i am a from a"), *conflicts[0].Local.Hash)
+		assert.Equal(t, scry.VersionConcurrent, conflicts[0].Local.Version.Compare(conflicts[0].Remote.Version))
+	}
+	assert.Len(t, b.conflicts(t, "d"), 1)
+
+	// syncing again doesn't record the conflict twice
+	a.syncWith(t, b)
+	assert.Len(t, a.conflicts(t, "d"), 1)
+}
+
+// test resolving a conflict on one peer resolves it on the other after syncing
+func TestPeerSyncResolve(t *testing.T) {
+	tests := []struct {
+		resolution resolve.Resolution
+		wanted     string // the content of d/a
+		copied     bool   // the remote content was copied next to d/a
+	}{
+		{resolve.KEEP_LOCAL, "i am a from a", false},
+		{resolve.KEEP_REMOTE, "i am a from b", false},
+		{resolve.KEEP_BOTH, "i am a from a", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.resolution.String(), func(t *testing.T) {
+			a, b := concurrentWrites(t)
+			conflicts := a.conflicts(t, "d")
+			if !assert.Len(t, conflicts, 1) {
+				return
+			}
+			a.resolve(t, "d", &conflicts[0], tt.resolution)
+			assert.Empty(t, a.conflicts(t, "d"))
+			wanted := map[string]string{"a": *hashPtr(tt.wanted)}
+			if tt.copied {
+				wanted["a"+resolve.CONFLICT_SUFFIX+b.nodeID] = *hashPtr("i am a from b")
+			}
+			assert.Equal(t, wanted, a.diskState(t, "d"))
+
+			b.syncWith(t, a)
+			assertConverged(t, a, b, "d")
+			assert.Empty(t, b.conflicts(t, "d"), "the peer's conflict should be cleared")
+			a.syncWith(t, b)
+			assert.Empty(t, a.conflicts(t, "d"), "the resolved conflict shouldn't come back")
+		})
+	}
+}
+
+// test the remote content kept for a conflict isn't collected before it's resolved
+func TestPeerSyncConflictGC(t *testing.T) {
+	a, _ := concurrentWrites(t)
+	removed, err := a.blobs.BlobStore.(*blobstore.BlobStore).GC(a.store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, removed)
+	has, err := a.blobs.Has(*hashPtr("i am a from b"))
+	assert.NoError(t, err)
+	assert.True(t, has, "the conflict's remote content should be kept")
+
+	conflicts := a.conflicts(t, "d")
+	if !assert.Len(t, conflicts, 1) {
+		return
+	}
+	a.resolve(t, "d", &conflicts[0], resolve.KEEP_REMOTE)
+	assert.Equal(t, map[string]string{"a": *hashPtr("i am a from b")}, a.diskState(t, "d"))
+}
+
+// test paths from a peer that lead outside the dir aren't synced
+func TestPeerSyncPathOutsideDir(t *testing.T) {
+	a, b := newTestPeers(t, tmpDirWithA(), &utils.TmpDir{Name: "d"})
+	victim := filepath.Join(b.topDir, "victim")
+	if err := os.WriteFile(victim, []byte("i am victim"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// a's store has nodes outside d (as if it was buggy or compromised)
+	dir, err := a.store.GetDirByPath("d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := "i am evil"
+	if err = a.blobs.Add(*hashPtr(content), bytes.NewBufferString(content)); err != nil {
+		t.Fatal(err)
+	}
+	for i, path := range []string{"../victim", "../../evil"} {
+		chain := scry.Chain{Ino: uint64(1<<40 + i)}
+		if err = a.store.AddChain(&chain, dir.ID); err != nil {
+			t.Fatal(err)
+		}
+		event := scry.Event{
+			Timestamp: time.Now(),
+			Path:      path,
+			Type:      scry.Create,
+			Hash:      hashPtr(content),
+			Size:      uint64(len(content)),
+			ModTime:   time.Now(),
+			Origin:    a.nodeID,
+			Version:   scry.VersionVector{}.Bump(a.nodeID),
+		}
+		if err = a.store.AddEvent(&event, chain.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b.syncWith(t, a)
+	assert.Equal(t, a.diskState(t, "d"), b.diskState(t, "d"), "nodes inside the dir should still be synced")
+	got, err := os.ReadFile(victim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "i am victim", string(got), "nodes outside the dir shouldn't be written")
+	assert.NoFileExists(t, filepath.Join(filepath.Dir(b.topDir), "evil"))
+	assert.NotContains(t, b.storeState(t, "d"), "../victim")
+}
+
+// test nodes from a peer aren't written under a synced link
+func TestPeerSyncUnderLink(t *testing.T) {
+	a, b := newTestPeers(t, &utils.TmpDir{Name: "d"}, &utils.TmpDir{Name: "d"})
+	outside := filepath.Join(b.topDir, "outside")
+	if err := os.Mkdir(outside, 0755); err != nil {
+		t.Fatal(err)
+	}
+	a.takeActions(t, []utils.FsAction{{Kind: utils.SYMLINK, SrcPath: outside, DstPath: "d/l"}})
+	a.reconcile(t, "d")
+
+	// a's store has a node under the link (as if it was buggy or compromised)
+	dir, err := a.store.GetDirByPath("d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := "i am evil"
+	if err = a.blobs.Add(*hashPtr(content), bytes.NewBufferString(content)); err != nil {
+		t.Fatal(err)
+	}
+	chain := scry.Chain{Ino: 1 << 40}
+	if err = a.store.AddChain(&chain, dir.ID); err != nil {
+		t.Fatal(err)
+	}
+	event := scry.Event{
+		Timestamp: time.Now(),
+		Path:      "l/x",
+		Type:      scry.Create,
+		Hash:      hashPtr(content),
+		Size:      uint64(len(content)),
+		ModTime:   time.Now(),
+		Origin:    a.nodeID,
+		Version:   scry.VersionVector{}.Bump(a.nodeID),
+	}
+	if err = a.store.AddEvent(&event, chain.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	b.syncWith(t, a)
+	assert.Equal(t, outside, b.linkState(t, "d", "l"), "the link should still be synced")
+	assert.NoFileExists(t, filepath.Join(outside, "x"), "nodes under a link shouldn't be written")
+	assert.NotContains(t, b.storeState(t, "d"), "l/x")
+}
+
+// test a peer whose clock is ahead doesn't hide the local changes made after its synced ones
+func TestPeerSyncClockAhead(t *testing.T) {
+	a, b := newTestPeers(t, tmpDirWithA(), &utils.TmpDir{Name: "d"})
+	b.syncWith(t, a)
+	assertConverged(t, a, b, "d")
+
+	// a writes d/a w/ its clock an hour ahead
+	content := "i am a from a"
+	a.takeActions(t, []utils.FsAction{{Kind: utils.WRITE, DstPath: "d/a", Content: []byte(" from a")}})
+	if err := a.blobs.Add(*hashPtr(content), bytes.NewBufferString(content)); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := a.store.GetDirByPath("d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := a.store.GetChainByPath(dir.ID, "a")
+	if err != nil || chain == nil {
+		t.Fatal("a should have a chain", err)
+	}
+	tail, err := a.store.GetChainTail(chain.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(a.topDir, "d/a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := *tail
+	event.ID = nil
+	event.OldPath = nil
+	event.Type = scry.Write
+	event.Timestamp = time.Now().Add(time.Hour)
+	event.Hash = hashPtr(content)
+	event.Size = uint64(len(content))
+	event.ModTime = info.ModTime()
+	event.Version = tail.Version.Bump(a.nodeID)
+	if err = a.store.AddEvent(&event, chain.ID); err != nil {
+		t.Fatal(err)
+	}
+	b.syncWith(t, a)
+	assertConverged(t, a, b, "d")
+
+	// b writes after it (by its own clock)
+	b.takeActions(t, []utils.FsAction{{Kind: utils.WRITE, DstPath: "d/a", Content: []byte(" and b")}})
+	b.reconcile(t, "d")
+	assert.Equal(t, b.diskState(t, "d"), b.storeState(t, "d"), "the local write should be the latest")
+	count := b.eventCount(t, "d")
+	b.reconcile(t, "d")
+	assert.Equal(t, count, b.eventCount(t, "d"), "reconciling again shouldn't add events")
+
+	a.syncWith(t, b)
+	assertConverged(t, a, b, "d")
+	assert.Equal(t, *hashPtr("i am a from a and b"), a.diskState(t, "d")["a"])
+	assert.Empty(t, a.conflicts(t, "d"))
+}
+
+// test peers that don't trust each other can't sync
+func TestPeerSyncUntrusted(t *testing.T) {
+	a := newTestPeer(t, tmpDirWithA())
+	b := newTestPeer(t, &utils.TmpDir{Name: "d"})
+
+	// neither trusts the other
+	assert.Error(t, b.node.SyncWith(a.node.Addr().String()))
+	// b trusts a but a (serving) doesn't trust b
+	b.trusted.Add(a.id.PublicKey)
+	assert.Error(t, b.node.SyncWith(a.node.Addr().String()))
+	assert.Empty(t, b.diskState(t, "d"))
+
+	// both trust each other
+	a.trusted.Add(b.id.PublicKey)
+	b.syncWith(t, a)
+	assertConverged(t, a, b, "d")
+}
+
+// test a peer that doesn't speak TLS isn't served
+func TestPeerPlaintextRejected(t *testing.T) {
+	a := newTestPeer(t, tmpDirWithA())
+
+	c, err := net.DialTimeout("tcp", a.node.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	if err = gob.NewEncoder(c).Encode(&peer.Request{Type: peer.GET_DIRS}); err != nil {
+		return // already hung up on
+	}
+	var res peer.Response
+	assert.Error(t, gob.NewDecoder(c).Decode(&res), "plaintext requests shouldn't be answered")
+	assert.Empty(t, res.Dirs)
+}
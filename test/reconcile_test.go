@@ -0,0 +1,289 @@
+package test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ceejimus/kusari/badgerstore"
+	"github.com/ceejimus/kusari/fnode"
+	"github.com/ceejimus/kusari/scry"
+	"github.com/ceejimus/kusari/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// take actions while nothing is watching, then start a scryer and check the store caught up
+func runReconcileTest(t *testing.T, tmpFs *utils.TmpFs, watchedDirPaths []string, actions []utils.FsAction, wantedMap DirPathToTailChainMap) scry.EventStore {
+	if err := tmpFs.Instantiate(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tmpFs.Destroy() })
+
+	dbDir := filepath.Join(tmpFs.Path, "./.db/")
+	store, err := badgerstore.NewBadgerStore(dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		store.Close()
+		os.RemoveAll(dbDir)
+	})
+
+	scryDirs := make([]scry.ScriedDirectory, len(watchedDirPaths))
+	for i, watchedDirPath := range watchedDirPaths {
+		scryDirs[i] = scry.ScriedDirectory{Path: watchedDirPath}
+	}
+	if err := setupStoreFromLocalState(tmpFs, scryDirs, store); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range actions {
+		if actions[i].SrcPath != "" {
+			actions[i].SrcPath = filepath.Join(tmpFs.Path, actions[i].SrcPath)
+		}
+		if actions[i].DstPath != "" {
+			actions[i].DstPath = filepath.Join(tmpFs.Path, actions[i].DstPath)
+		}
+	}
+	takeActions(t, actions)
+
+	watcher, err := scry.InitScryer(tmpFs.Path, scryDirs, store, scry.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go watcher.Run()
+	watcher.Close()
+
+	if err := compareWanted(t, wantedMap, store); err != nil {
+		t.Fatal(err)
+	}
+
+	return store
+}
+
+// test a write, move, remove, create and dir move that happen while not watching
+func TestReconcileOfflineChanges(t *testing.T) {
+	content := []byte("i am a")
+	more := []byte(" and more")
+	hash, err := fnode.GetHash(bytes.NewBuffer(append(append([]byte{}, content...), more...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantedMap := make(DirPathToTailChainMap)
+	wantedMap["d"] = make(TailPathToChainMap)
+	wantedMap["d"]["a"] = Chains{
+		Chain{
+			{Path: "a", Type: scry.Create},
+			{Path: "a", Type: scry.Write, Size: uint64(len(content) + len(more)), Hash: &hash},
+		},
+	}
+	wantedMap["d"]["t"] = Chains{
+		Chain{
+			{Path: "s", Type: scry.Create},
+			{Path: "s", Type: scry.Rename},
+			{Path: "t", Type: scry.Create},
+		},
+	}
+	wantedMap["d"]["t/b2"] = Chains{
+		Chain{
+			{Path: "b", Type: scry.Create},
+			{Path: "b", Type: scry.Rename},
+			{Path: "t/b2", Type: scry.Create},
+		},
+	}
+	wantedMap["d"]["s/c"] = Chains{
+		Chain{
+			{Path: "s/c", Type: scry.Create},
+			{Path: "s/c", Type: scry.Remove},
+		},
+	}
+	wantedMap["d"]["n"] = Chains{
+		Chain{
+			{Path: "n", Type: scry.Create},
+		},
+	}
+
+	tmpDir := utils.TmpDir{
+		Name: "d",
+		Dirs: []*utils.TmpDir{{
+			Name:  "s",
+			Files: []*utils.TmpFile{{Name: "c", Content: []byte("i am c")}},
+		}},
+		Files: []*utils.TmpFile{
+			{Name: "a", Content: content},
+			{Name: "b", Content: []byte("i am b")},
+		},
+	}
+
+	tmpFs := utils.TmpFs{Path: "../.data/tmp/", Dirs: []*utils.TmpDir{&tmpDir}}
+
+	actions := []utils.FsAction{
+		{Kind: utils.WRITE, DstPath: "d/a", Content: more},
+		{Kind: utils.REMOVE, DstPath: "d/s/c"},
+		{Kind: utils.MOVE, SrcPath: "d/b", DstPath: "d/s/b2"},
+		{Kind: utils.MOVE, SrcPath: "d/s", DstPath: "d/t"},
+		{Kind: utils.TOUCH, DstPath: "d/n"},
+	}
+
+	store := runReconcileTest(t, &tmpFs, []string{"d"}, actions, wantedMap)
+
+	dir, err := store.GetDirByPath("d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := store.GetChainByPath(dir.ID, "t/b2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotNil(t, chain, "moved node should be found at its new path")
+	chain, err = store.GetChainByPath(dir.ID, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, chain, "moved node shouldn't be found at its old path")
+}
+
+// test that an unchanged dir doesn't get any new events
+func TestReconcileNoChanges(t *testing.T) {
+	wantedMap := make(DirPathToTailChainMap)
+	wantedMap["d"] = make(TailPathToChainMap)
+	wantedMap["d"]["a"] = Chains{
+		Chain{
+			{Path: "a", Type: scry.Create},
+		},
+	}
+	wantedMap["d"]["s"] = Chains{
+		Chain{
+			{Path: "s", Type: scry.Create},
+		},
+	}
+	wantedMap["d"]["s/b"] = Chains{
+		Chain{
+			{Path: "s/b", Type: scry.Create},
+		},
+	}
+
+	tmpDir := utils.TmpDir{
+		Name: "d",
+		Dirs: []*utils.TmpDir{{
+			Name:  "s",
+			Files: []*utils.TmpFile{{Name: "b", Content: []byte("i am b")}},
+		}},
+		Files: []*utils.TmpFile{{Name: "a", Content: []byte("i am a")}},
+	}
+
+	tmpFs := utils.TmpFs{Path: "../.data/tmp/", Dirs: []*utils.TmpDir{&tmpDir}}
+
+	runReconcileTest(t, &tmpFs, []string{"d"}, []utils.FsAction{}, wantedMap)
+}
+
+// test nodes stored w/ md5 hashes (from before hashes said what they were) aren't seen as changed
+func TestReconcileLegacyHashes(t *testing.T) {
+	tmpFs := utils.TmpFs{
+		Path: t.TempDir(),
+		Dirs: []*utils.TmpDir{{
+			Name:  "d",
+			Files: []*utils.TmpFile{{Name: "a", Content: []byte("i am a")}},
+		}},
+	}
+	if err := tmpFs.Instantiate(); err != nil {
+		t.Fatal(err)
+	}
+	store := newTestBadgerStore(t)
+	dir := &scry.Dir{Path: "d"}
+	if err := store.AddDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	node, err := fnode.NewNode(filepath.Join(tmpFs.Path, "d", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := &scry.Chain{Ino: node.Ino}
+	if err = store.AddChain(chain, dir.ID); err != nil {
+		t.Fatal(err)
+	}
+	hash, _ := fnode.FileHashWith(fnode.HASH_MD5, node.Path)
+	legacy := hash[len(fnode.HASH_MD5+fnode.HASH_SEP):]
+	state := node.State()
+	event := &scry.Event{Path: "a", Type: scry.Create, Size: state.Size, Hash: &legacy, ModTime: state.ModTime, Mode: state.Mode, Uid: state.Uid, Gid: state.Gid}
+	if err = store.AddEvent(event, chain.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = scry.Reconcile(tmpFs.Path, scry.ScriedDirectory{Path: "d"}, store, scry.Options{}); err != nil {
+		t.Fatal(err)
+	}
+	events, err := store.GetEventsInChain(chain.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, events, 1, "the node didn't change")
+}
+
+// test reconcile trusts the stored stat cache unless it's paranoid
+func TestReconcileStatCache(t *testing.T) {
+	tmpFs := utils.TmpFs{
+		Path: t.TempDir(),
+		Dirs: []*utils.TmpDir{{
+			Name:  "d",
+			Files: []*utils.TmpFile{{Name: "a", Content: []byte("i am a")}},
+		}},
+	}
+	if err := tmpFs.Instantiate(); err != nil {
+		t.Fatal(err)
+	}
+	store := newTestBadgerStore(t)
+	dir := &scry.Dir{Path: "d"}
+	if err := store.AddDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	node, err := fnode.NewNode(filepath.Join(tmpFs.Path, "d", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := &scry.Chain{Ino: node.Ino}
+	if err = store.AddChain(chain, dir.ID); err != nil {
+		t.Fatal(err)
+	}
+	state := node.State()
+	event := &scry.Event{Path: "a", Type: scry.Create, Size: state.Size, Hash: state.Hash, ModTime: state.ModTime, Mode: state.Mode, Uid: state.Uid, Gid: state.Gid}
+	if err = store.AddEvent(event, chain.ID); err != nil {
+		t.Fatal(err)
+	}
+	// the cache says the file (w/ its current stat) has other content
+	stale := hashPtr("i am not a")
+	entry := &fnode.StatEntry{Ino: node.Ino, Size: node.Size(), ModTime: node.ModTime(), Ctime: node.Ctime(), Hash: *stale}
+	if err = store.PutStatEntry(entry); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.GetStatEntry(node.Ino)
+	assert.NoError(t, err)
+	assert.Equal(t, entry.Hash, got.Hash)
+	assert.True(t, entry.Ctime.Equal(got.Ctime))
+
+	// paranoid reads the file so nothing changed
+	scryDir := scry.ScriedDirectory{Path: "d"}
+	if err = scry.Reconcile(tmpFs.Path, scryDir, store, scry.Options{Paranoid: true}); err != nil {
+		t.Fatal(err)
+	}
+	events, err := store.GetEventsInChain(chain.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, events, 1, "the node didn't change")
+
+	// otherwise the cached hash is used w/o reading the file
+	if err = scry.Reconcile(tmpFs.Path, scryDir, store, scry.Options{}); err != nil {
+		t.Fatal(err)
+	}
+	events, err = store.GetEventsInChain(chain.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, scry.Write, events[1].Type)
+		assert.Equal(t, *stale, *events[1].Hash)
+	}
+}
@@ -0,0 +1,180 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ceejimus/kusari/blobstore"
+	"github.com/ceejimus/kusari/restore"
+	"github.com/ceejimus/kusari/scry"
+	"github.com/ceejimus/kusari/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func assertContent(t *testing.T, path string, wanted string) {
+	content, err := os.ReadFile(path)
+	if assert.NoError(t, err) {
+		assert.Equal(t, wanted, string(content))
+	}
+}
+
+// test restoring writes, removes and moves to earlier times and events
+func TestRestore(t *testing.T) {
+	topDir := t.TempDir()
+	tmpDir := utils.TmpDir{
+		Name: "d",
+		Dirs: []*utils.TmpDir{{
+			Name:  "s",
+			Files: []*utils.TmpFile{{Name: "c", Content: []byte("i am c")}},
+		}},
+		Files: []*utils.TmpFile{{Name: "a", Content: []byte("i am a v1")}},
+	}
+	if err := tmpDir.Instantiate(topDir); err != nil {
+		t.Fatal(err)
+	}
+	store := newTestBadgerStore(t)
+	dir := &scry.Dir{Path: "d"}
+	if err := store.AddDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	blobs, err := blobstore.NewBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// take actions then catch the store up
+	act := func(actions []utils.FsAction) time.Time {
+		for i := range actions {
+			if actions[i].SrcPath != "" {
+				actions[i].SrcPath = filepath.Join(topDir, actions[i].SrcPath)
+			}
+			actions[i].DstPath = filepath.Join(topDir, actions[i].DstPath)
+		}
+		takeActions(t, actions)
+		if err := scry.Reconcile(topDir, scry.ScriedDirectory{Path: "d"}, store, scry.Options{Blobs: blobs}); err != nil {
+			t.Fatal(err)
+		}
+		return time.Now()
+	}
+	full := func(path string) string { return filepath.Join(topDir, "d", path) }
+	restorer := restore.NewRestorer(topDir, dir, store, blobs)
+
+	v1 := act(nil)
+	act([]utils.FsAction{{Kind: utils.WRITE, DstPath: "d/a", Content: []byte(" and v2")}})
+
+	// restore a write by time
+	restored, err := restorer.Restore("a", v1, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a"}, restored)
+	assertContent(t, full("a"), "i am a v1")
+	act(nil)
+
+	// restore a write by event ID
+	events, err := restorer.History("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, events, 3, "restoring should add to the existing chain") { // create, write, write (the restore)
+		at, err := restorer.EventTime("a", restore.FormatID(events[1].ID))
+		assert.NoError(t, err)
+		_, err = restorer.Restore("a", at, false)
+		assert.NoError(t, err)
+		assertContent(t, full("a"), "i am a v1 and v2")
+	}
+	_, err = restorer.EventTime("a", "nope")
+	assert.Error(t, err)
+
+	// restore a removed file
+	beforeRemove := act(nil)
+	act([]utils.FsAction{{Kind: utils.REMOVE, DstPath: "d/a"}})
+	_, err = restorer.Restore("a", beforeRemove, false)
+	assert.NoError(t, err)
+	assertContent(t, full("a"), "i am a v1 and v2")
+
+	// restore a moved dir w/ a removed child, to its current path then its old path
+	beforeMove := act([]utils.FsAction{})
+	act([]utils.FsAction{
+		{Kind: utils.REMOVE, DstPath: "d/s/c"},
+		{Kind: utils.MOVE, SrcPath: "d/s", DstPath: "d/t"},
+	})
+	restored, err = restorer.Restore("t", beforeMove, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"t", "t/c"}, restored)
+	assertContent(t, full("t/c"), "i am c")
+
+	restored, err = restorer.Restore("t", beforeMove, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"s", "s/c"}, restored)
+	assertContent(t, full("s/c"), "i am c")
+
+	// can't restore to before a node existed
+	_, err = restorer.Restore("t", time.Time{}.Add(time.Hour), false)
+	assert.Error(t, err)
+}
+
+// test restoring doesn't write through symlinks that replaced a node or its parent
+func TestRestoreThroughSymlink(t *testing.T) {
+	topDir := t.TempDir()
+	outside := t.TempDir()
+	tmpDir := utils.TmpDir{
+		Name: "d",
+		Dirs: []*utils.TmpDir{{
+			Name:  "s",
+			Files: []*utils.TmpFile{{Name: "c", Content: []byte("i am c")}},
+		}},
+		Files: []*utils.TmpFile{{Name: "a", Content: []byte("i am a")}},
+	}
+	if err := tmpDir.Instantiate(topDir); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "c"} {
+		if err := os.WriteFile(filepath.Join(outside, name), []byte("i am outside"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store := newTestBadgerStore(t)
+	dir := &scry.Dir{Path: "d"}
+	if err := store.AddDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	blobs, err := blobstore.NewBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	reconcile := func() time.Time {
+		if err := scry.Reconcile(topDir, scry.ScriedDirectory{Path: "d"}, store, scry.Options{Blobs: blobs}); err != nil {
+			t.Fatal(err)
+		}
+		return time.Now()
+	}
+	before := reconcile()
+	takeActionsIn(t, topDir, []utils.FsAction{{Kind: utils.WRITE, DstPath: "d/a", Content: []byte(" and v2")}})
+	reconcile()
+	// swap in links before the store sees them
+	takeActionsIn(t, topDir, []utils.FsAction{
+		{Kind: utils.REMOVE, DstPath: "d/s/c"},
+		{Kind: utils.REMOVE, DstPath: "d/s"},
+		{Kind: utils.SYMLINK, SrcPath: outside, DstPath: "d/s"},
+		{Kind: utils.REMOVE, DstPath: "d/a"},
+		{Kind: utils.SYMLINK, SrcPath: filepath.Join(outside, "a"), DstPath: "d/a"},
+	})
+	restorer := restore.NewRestorer(topDir, dir, store, blobs)
+
+	// the link at a is replaced
+	_, err = restorer.Restore("a", before, false)
+	assert.NoError(t, err)
+	info, err := os.Lstat(filepath.Join(topDir, "d/a"))
+	if assert.NoError(t, err) {
+		assert.True(t, info.Mode().IsRegular(), "the link should be replaced w/ the file")
+	}
+	assertContent(t, filepath.Join(topDir, "d/a"), "i am a")
+
+	// s/c would be written through the link at s
+	_, err = restorer.Restore("s/c", before, false)
+	assert.Error(t, err)
+
+	for _, name := range []string{"a", "c"} {
+		assertContent(t, filepath.Join(outside, name), "i am outside")
+	}
+}
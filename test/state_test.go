@@ -0,0 +1,169 @@
+package test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/ceejimus/kusari/badgerstore"
+	"github.com/ceejimus/kusari/fnode"
+	"github.com/ceejimus/kusari/scry"
+	"github.com/ceejimus/kusari/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+type scriptedEvent struct {
+	chain int        // index of chain to add the event to
+	event scry.Event // the event to add
+}
+
+// add a dir w/ the given events, chains are created as needed
+func addScriptedEvents(t *testing.T, store scry.EventStore, dirPath string, script []scriptedEvent) *scry.Dir {
+	dir := &scry.Dir{Path: dirPath}
+	if err := store.AddDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	chains := make(map[int]*scry.Chain)
+	for i, s := range script {
+		chain, ok := chains[s.chain]
+		if !ok {
+			chain = &scry.Chain{Ino: uint64(s.chain + 1)}
+			if err := store.AddChain(chain, dir.ID); err != nil {
+				t.Fatal(err)
+			}
+			chains[s.chain] = chain
+		}
+		event := s.event
+		if err := store.AddEvent(&event, chain.ID); err != nil {
+			t.Fatalf("Failed to add scripted event %d: %s", i, err)
+		}
+	}
+	return dir
+}
+
+func newTestBadgerStore(t *testing.T) scry.EventStore {
+	store, err := badgerstore.NewBadgerStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// instantiate a tmp fs in a test temp dir, store what's in it and start a scryer on it
+func startScryer(t *testing.T, tmpFs *utils.TmpFs, scryDirs []scry.ScriedDirectory, opts scry.Options) (scry.EventStore, *scry.Scryer) {
+	tmpFs.Path = t.TempDir()
+	if err := tmpFs.Instantiate(); err != nil {
+		t.Fatal(err)
+	}
+	store := newTestBadgerStore(t)
+	if err := setupStoreFromLocalState(tmpFs, scryDirs, store); err != nil {
+		t.Fatal(err)
+	}
+	watcher, err := scry.InitScryer(tmpFs.Path, scryDirs, store, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go watcher.Run()
+	return store, watcher
+}
+
+func hashPtr(content string) *string {
+	hash, _ := fnode.GetHash(bytes.NewBufferString(content))
+	return &hash
+}
+
+func statePaths(state scry.DirState) []string {
+	paths := make([]string, 0, len(state))
+	for path := range state {
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func TestDirStateAt(t *testing.T) {
+	t0 := time.Now().Add(-time.Hour)
+	at := func(minutes int) time.Time { return t0.Add(time.Duration(minutes) * time.Minute) }
+
+	store := newTestBadgerStore(t)
+	dir := addScriptedEvents(t, store, "d", []scriptedEvent{
+		{0, scry.Event{Timestamp: at(1), Path: "s", Type: scry.Create}},
+		{1, scry.Event{Timestamp: at(2), Path: "s/a", Type: scry.Create, Size: 2, Hash: hashPtr("v1")}},
+		{2, scry.Event{Timestamp: at(2), Path: "b", Type: scry.Create, Size: 2, Hash: hashPtr("b1")}},
+		{1, scry.Event{Timestamp: at(3), Path: "s/a", Type: scry.Write, Size: 2, Hash: hashPtr("v2")}},
+		{2, scry.Event{Timestamp: at(4), Path: "b", Type: scry.Remove}},
+		{0, scry.Event{Timestamp: at(5), Path: "s", Type: scry.Rename}},
+		{0, scry.Event{Timestamp: at(6), Path: "t", Type: scry.Create}},
+	})
+
+	tests := []struct {
+		name   string
+		at     time.Time
+		wanted map[string]*string
+	}{
+		{"before anything", at(0), map[string]*string{}},
+		{"after first write", at(2), map[string]*string{"s": nil, "s/a": hashPtr("v1"), "b": hashPtr("b1")}},
+		{"after second write", at(3), map[string]*string{"s": nil, "s/a": hashPtr("v2"), "b": hashPtr("b1")}},
+		{"after remove", at(4), map[string]*string{"s": nil, "s/a": hashPtr("v2")}},
+		{"mid move", at(5), map[string]*string{}},
+		{"after move", at(6), map[string]*string{"t": nil, "t/a": hashPtr("v2")}},
+		{"now", time.Time{}, map[string]*string{"t": nil, "t/a": hashPtr("v2")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, err := scry.GetDirStateAt(store, dir.ID, tt.at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantedPaths := make([]string, 0, len(tt.wanted))
+			for path := range tt.wanted {
+				wantedPaths = append(wantedPaths, path)
+			}
+			if !assert.ElementsMatch(t, wantedPaths, statePaths(state)) {
+				return
+			}
+			for path, hash := range tt.wanted {
+				assert.Equal(t, path, state[path].Path)
+				if hash == nil {
+					assert.Nil(t, state[path].Hash)
+				} else if assert.NotNil(t, state[path].Hash) {
+					assert.Equal(t, *hash, *state[path].Hash)
+				}
+			}
+		})
+	}
+
+	state, err := scry.GetDirState(store, dir.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.ElementsMatch(t, []string{"t", "t/a"}, statePaths(state))
+}
+
+// test a new node at a path follows the version of what was there (w/o folding the dir)
+func TestPathVersion(t *testing.T) {
+	store := newTestBadgerStore(t)
+	dir := addScriptedEvents(t, store, "d", []scriptedEvent{
+		{0, scry.Event{Path: "f", Type: scry.Create, Version: scry.VersionVector{"a": 1}}},
+		{0, scry.Event{Path: "f", Type: scry.Remove, Version: scry.VersionVector{"a": 2}}},
+		{1, scry.Event{Path: "g", Type: scry.Create, Version: scry.VersionVector{"b": 1}}},
+		// a node at f that doesn't know about the removed one
+		{2, scry.Event{Path: "f", Type: scry.Create, Version: scry.VersionVector{"c": 1}}},
+		{2, scry.Event{Path: "f", Type: scry.Write, Version: scry.VersionVector{"c": 2}}},
+	})
+
+	tests := []struct {
+		path   string
+		wanted scry.VersionVector
+	}{
+		{"f", scry.VersionVector{"a": 2, "c": 2}},
+		{"g", scry.VersionVector{"b": 1}},
+		{"h", scry.VersionVector{}},
+	}
+	for _, tt := range tests {
+		version, err := scry.GetPathVersion(store, dir.ID, tt.path)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.wanted, version, tt.path)
+	}
+}
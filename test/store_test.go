@@ -0,0 +1,85 @@
+package test
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ceejimus/kusari/scry"
+	"github.com/ceejimus/kusari/sqlitestore"
+	"github.com/ceejimus/kusari/storetest"
+	"github.com/stretchr/testify/assert"
+)
+
+// every store should pass the conformance suite
+func TestStoreConformance(t *testing.T) {
+	for _, testStore := range testStores {
+		t.Run(testStore.name, func(t *testing.T) {
+			storetest.Run(t, func(t *testing.T) scry.EventStore {
+				store, err := testStore.open(t.TempDir())
+				if err != nil {
+					t.Fatal(err)
+				}
+				return store
+			})
+		})
+	}
+}
+
+// test the file_state view still answers the old query_filestate script
+func TestSqliteFileState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kusari.db")
+	store, err := sqlitestore.NewSqliteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := &scry.Dir{Path: "d"}
+	if err = store.AddDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	chain := &scry.Chain{Ino: 1}
+	if err = store.AddChain(chain, dir.ID); err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Now().Add(-time.Hour)
+	h1, h2 := "h1", "h2"
+	for _, event := range []*scry.Event{
+		{Timestamp: ts, Path: "f", Type: scry.Create, Hash: &h1, Size: 1, ModTime: ts},
+		{Timestamp: ts.Add(time.Second), Path: "f", Type: scry.Write, Hash: &h2, Size: 2, ModTime: ts},
+		{Timestamp: ts.Add(2 * time.Second), Path: "f", Type: scry.Write, Hash: &h1, Size: 3, ModTime: ts},
+	} {
+		if err = store.AddEvent(event, chain.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Close()
+
+	query, err := os.ReadFile("../sql/query_filestate.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query(string(query), sql.Named("path", "d/f"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	sizes := map[string]int64{}
+	for rows.Next() {
+		var path, hash string
+		var size, timestamp, modtime int64
+		if err = rows.Scan(&path, &hash, &size, &timestamp, &modtime); err != nil {
+			t.Fatal(err)
+		}
+		sizes[hash] = size
+	}
+	assert.NoError(t, rows.Err())
+	assert.Equal(t, map[string]int64{"h1": 3, "h2": 2}, sizes, "each hash should have the latest state it was seen w/")
+}
@@ -0,0 +1,96 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/ceejimus/kusari/fnode"
+	"github.com/ceejimus/kusari/scry"
+	"github.com/ceejimus/kusari/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// test symlinks are tracked as links: created, retargeted (replaced) and removed
+func TestSymlinkEvents(t *testing.T) {
+	tmpFs := utils.TmpFs{
+		Dirs: []*utils.TmpDir{{
+			Name:  "d",
+			Files: []*utils.TmpFile{{Name: "a"}, {Name: "b"}},
+		}},
+	}
+	store, watcher := startScryer(t, &tmpFs, []scry.ScriedDirectory{{Path: "d"}}, scry.Options{})
+
+	takeActionsIn(t, tmpFs.Path, []utils.FsAction{
+		{Kind: utils.SYMLINK, SrcPath: "a", DstPath: "d/l"},
+		// ln -sf
+		{Kind: utils.REMOVE, DstPath: "d/l"},
+		{Kind: utils.SYMLINK, SrcPath: "b", DstPath: "d/l"},
+		{Kind: utils.SYMLINK, SrcPath: "nowhere", DstPath: "d/dangling"},
+		{Kind: utils.REMOVE, DstPath: "d/dangling"},
+	})
+	watcher.Close()
+
+	wantedMap := make(DirPathToTailChainMap)
+	wantedMap["d"] = make(TailPathToChainMap)
+	wantedMap["d"]["a"] = Chains{Chain{{Path: "a", Type: scry.Create}}}
+	wantedMap["d"]["b"] = Chains{Chain{{Path: "b", Type: scry.Create}}}
+	wantedMap["d"]["l"] = Chains{
+		Chain{{Path: "l", Type: scry.Create}, {Path: "l", Type: scry.Remove}},
+		Chain{{Path: "l", Type: scry.Create}},
+	}
+	wantedMap["d"]["dangling"] = Chains{Chain{{Path: "dangling", Type: scry.Create}, {Path: "dangling", Type: scry.Remove}}}
+	if err := compareWanted(t, wantedMap, store); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := store.GetDirByPath("d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := scry.GetDirState(store, dir.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "b", state["l"].Target)
+	assert.Nil(t, state["l"].Hash)
+}
+
+// test links to files are scried as the files w/ the follow policy
+func TestSymlinkFollow(t *testing.T) {
+	tmpFs := utils.TmpFs{
+		Dirs: []*utils.TmpDir{
+			{Name: "dotfiles", Files: []*utils.TmpFile{{Name: "vimrc", Content: []byte("set nu")}}},
+			{Name: "d", Dirs: []*utils.TmpDir{{Name: "s"}}},
+		},
+	}
+	if err := tmpFs.Instantiate(); err != nil {
+		t.Fatal(err)
+	}
+	takeActionsIn(t, tmpFs.Path, []utils.FsAction{
+		{Kind: utils.SYMLINK, SrcPath: "../dotfiles/vimrc", DstPath: "d/.vimrc"},
+		{Kind: utils.SYMLINK, SrcPath: "s", DstPath: "d/t"},
+	})
+
+	nodes, err := scry.GetScriedNodes(tmpFs.Path, scry.ScriedDirectory{Path: "d", Symlinks: scry.SYMLINKS_FOLLOW})
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := make(map[string]fnode.NodeType)
+	for _, node := range nodes {
+		types[fnode.GetRelativePath(node.Path, tmpFs.Path+"/d")] = node.Type()
+		if node.Type() == fnode.FILE {
+			assert.Equal(t, *hashPtr("set nu"), *node.State().Hash)
+		}
+	}
+	// links to dirs are kept as links
+	assert.Equal(t, map[string]fnode.NodeType{".vimrc": fnode.FILE, "s": fnode.DIR, "t": fnode.SYMLINK}, types)
+
+	nodes, err = scry.GetScriedNodes(tmpFs.Path, scry.ScriedDirectory{Path: "d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, node := range nodes {
+		if node.Info.Name() == ".vimrc" {
+			assert.Equal(t, fnode.SYMLINK, node.Type())
+		}
+	}
+}
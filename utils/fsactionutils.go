@@ -19,11 +19,14 @@ const (
 	MOVE
 	MKDIR
 	RMDIR
+	CHMOD
+	SYMLINK // link DstPath to SrcPath (used as is, so relative targets are relative to the link)
 )
 
 type FsAction struct {
 	Kind     ActionKind
 	Content  []byte
+	Mode     os.FileMode
 	SrcPath  string
 	DstPath  string
 	WaitTime *time.Duration
@@ -46,6 +49,10 @@ func (a *FsAction) Take() error {
 		err = mkDir(a.DstPath)
 	case RMDIR:
 		err = rmDir(a.DstPath)
+	case CHMOD:
+		err = os.Chmod(a.DstPath, a.Mode)
+	case SYMLINK:
+		err = os.Symlink(a.SrcPath, a.DstPath)
 	default:
 		return errors.New(fmt.Sprintf("unexpected utils.ActionKind: %#v", a.Kind))
 	}